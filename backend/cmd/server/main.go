@@ -13,6 +13,7 @@ import (
 	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/config"
 	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/handlers"
 	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/middleware"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/idempotency"
 )
 
 func main() {
@@ -36,6 +37,8 @@ func main() {
 
 	// API routes
 	api := router.Group("/api/v1")
+	// Retried POST requests carrying an Idempotency-Key run only once
+	api.Use(middleware.Idempotency(idempotency.NewStore(idempotency.DefaultTTL)))
 	{
 		api.GET("/ping", handlers.Ping)
 		// Add more routes as needed
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/idempotency"
+)
+
+// Idempotency runs the handlers after it through store.Middleware, so POST
+// requests retried with the same Idempotency-Key are answered from the store
+func Idempotency(store *idempotency.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handled := false
+		store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handled = true
+			c.Request = r
+			original := c.Writer
+			c.Writer = &recordedWriter{ResponseWriter: original, w: w}
+			defer func() { c.Writer = original }()
+			c.Next()
+		})).ServeHTTP(c.Writer, c.Request)
+
+		if !handled {
+			// Replayed or rejected by the store
+			c.Abort()
+		}
+	}
+}
+
+// recordedWriter sends what Gin handlers write through the store's recorder,
+// which passes it on to the original writer
+type recordedWriter struct {
+	gin.ResponseWriter
+	w http.ResponseWriter
+}
+
+func (r *recordedWriter) WriteHeader(status int) {
+	r.w.WriteHeader(status)
+}
+
+func (r *recordedWriter) Write(b []byte) (int, error) {
+	return r.w.Write(b)
+}
+
+func (r *recordedWriter) WriteString(s string) (int, error) {
+	return io.WriteString(r.w, s)
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/idempotency"
+)
+
+func TestIdempotency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	router := gin.New()
+	router.Use(Idempotency(idempotency.NewStore(time.Hour)))
+	router.POST("/items", func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"call": calls})
+	})
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/items", strings.NewReader(body))
+		req.Header.Set(idempotency.Header, key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := post("abc", "item")
+	retry := post("abc", "item")
+	if calls != 1 {
+		t.Fatalf("Expected the handler to run once, ran %d times", calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() ||
+		retry.Header().Get("Content-Type") != first.Header().Get("Content-Type") ||
+		retry.Header().Get(idempotency.ReplayedHeader) != "true" {
+		t.Errorf("Unexpected replay %v %q %v", retry.Code, retry.Body, retry.Header())
+	}
+	if rr := post("abc", "other item"); rr.Code != http.StatusUnprocessableEntity || calls != 1 {
+		t.Errorf("Expected %v without running the handler, got %v", http.StatusUnprocessableEntity, rr.Code)
+	}
+	if rr := post("def", "item"); rr.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("Expected a new key to run the handler, got %v", rr.Code)
+	}
+}
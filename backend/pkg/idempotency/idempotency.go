@@ -0,0 +1,214 @@
+// Package idempotency lets clients retry POST requests safely. A request
+// carrying an Idempotency-Key header is handled once; retries with the same
+// key get the stored response instead of running the handler again. The
+// Gin server in /backend uses it through its middleware.Idempotency.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Headers used by Middleware
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed" // "true" on stored responses
+)
+
+// Defaults for NewStore
+const (
+	DefaultTTL   = 24 * time.Hour
+	MaxKeyLength = 255
+	MaxBodySize  = 1 << 20 // Largest request body read to fingerprint it
+	sweepEvery   = time.Minute
+)
+
+// response is a stored response
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// entry is the state of one key. done is closed once the first request
+// finished; response is nil if it was not stored.
+type entry struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	response    *response
+	expires     time.Time // Zero while the first request runs
+}
+
+// Store remembers responses by key for a TTL. It is safe for concurrent use.
+type Store struct {
+	mutex     sync.Mutex
+	entries   map[string]*entry
+	ttl       time.Duration
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+// NewStore creates a Store keeping responses for ttl, DefaultTTL when zero
+func NewStore(ttl time.Duration) *Store {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Store{entries: make(map[string]*entry), ttl: ttl, now: time.Now}
+}
+
+// Middleware handles POST and PATCH requests with an Idempotency-Key once.
+// Keys are scoped to the Authorization header, so callers cannot see each
+// other's responses. A retry with the same key gets the first response,
+// marked with Idempotent-Replayed; a retry whose method, URL or body differ
+// gets 422; a retry arriving while the first is still running waits for it.
+// Responses with a 5xx status are not stored, so those requests can be
+// retried. Bodies over MaxBodySize get 413.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > MaxKeyLength {
+			http.Error(w, Header+" is too long", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "request body is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "could not read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256([]byte(r.Method + " " + r.URL.RequestURI() + "\n" + string(body)))
+		scope := r.Header.Get("Authorization") + "\n" + key
+
+		for {
+			e, owner := s.claim(scope, fingerprint)
+			switch {
+			case e.fingerprint != fingerprint:
+				http.Error(w, Header+" was already used for a different request", http.StatusUnprocessableEntity)
+				return
+			case owner:
+				s.run(scope, e, next, w, r)
+				return
+			}
+
+			select {
+			case <-e.done:
+			case <-r.Context().Done():
+				return
+			}
+			if e.response != nil {
+				replay(w, e.response)
+				return
+			}
+			// The first request was not stored; try to handle this one
+		}
+	})
+}
+
+// claim returns the entry for scope, creating it if there is none. owner
+// reports whether the caller created it and must run the request.
+func (s *Store) claim(scope string, fingerprint [sha256.Size]byte) (e *entry, owner bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepEvery {
+		for k, old := range s.entries {
+			if !old.expires.IsZero() && !now.Before(old.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if e, ok := s.entries[scope]; ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		return e, false
+	}
+	e = &entry{fingerprint: fingerprint, done: make(chan struct{})}
+	s.entries[scope] = e
+	return e, true
+}
+
+// run handles the first request for an entry and stores its response
+func (s *Store) run(scope string, e *entry, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	rec := &recorder{ResponseWriter: w}
+	stored := false
+	defer func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if stored {
+			e.expires = s.now().Add(s.ttl)
+		} else {
+			delete(s.entries, scope)
+		}
+		close(e.done)
+	}()
+
+	next.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.header == nil {
+		rec.header = w.Header().Clone()
+	}
+	if rec.status < http.StatusInternalServerError {
+		e.response = &response{status: rec.status, header: rec.header, body: rec.body.Bytes()}
+		stored = true
+	}
+}
+
+// replay writes a stored response
+func replay(w http.ResponseWriter, resp *response) {
+	for name, values := range resp.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// recorder passes a response through while keeping a copy. The headers are
+// copied when the body starts, as some frameworks add headers after setting
+// the status.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.header == nil {
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
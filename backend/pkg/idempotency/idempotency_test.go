@@ -0,0 +1,173 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counter answers 201 with the number of requests it has handled
+func counter(calls *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("X-Call", strconv.Itoa(int(n)))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+}
+
+func send(handler http.Handler, method, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/messages", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMiddleware_Replays(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewStore(time.Hour).Middleware(counter(&calls))
+
+	first := send(handler, "POST", "abc", "hello")
+	retry := send(handler, "POST", "abc", "hello")
+	if calls.Load() != 1 {
+		t.Fatalf("Expected the handler to run once, ran %d times", calls.Load())
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != "created" || retry.Header().Get("X-Call") != "1" {
+		t.Errorf("Unexpected replay %v %q %v", retry.Code, retry.Body, retry.Header())
+	}
+	if first.Header().Get(ReplayedHeader) != "" || retry.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("Expected only the retry to be marked as replayed")
+	}
+
+	if rr := send(handler, "POST", "abc", "different"); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected %v for a different body, got %v", http.StatusUnprocessableEntity, rr.Code)
+	}
+	send(handler, "POST", "", "hello")
+	send(handler, "POST", "other", "hello")
+	send(handler, "PUT", "abc", "hello")
+	if calls.Load() != 4 {
+		t.Errorf("Expected requests without the key, with another key or another method to run, ran %d times", calls.Load())
+	}
+	if rr := send(handler, "POST", "large", strings.Repeat("b", MaxBodySize+1)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected %v for a large body, got %v", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("Expected a request with a large body not to run, ran %d times", calls.Load()-4)
+	}
+	if rr := send(handler, "POST", strings.Repeat("k", MaxKeyLength+1), ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v for a long key, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestMiddleware_ScopedToCaller(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewStore(time.Hour).Middleware(counter(&calls))
+	for _, authorization := range []string{"Bearer alice", "Bearer bob"} {
+		req := httptest.NewRequest("POST", "/messages", strings.NewReader("hi"))
+		req.Header.Set(Header, "same")
+		req.Header.Set("Authorization", authorization)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected each caller's request to run, ran %d times", calls.Load())
+	}
+}
+
+func TestMiddleware_Expires(t *testing.T) {
+	var calls atomic.Int32
+	store := NewStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	handler := store.Middleware(counter(&calls))
+
+	send(handler, "POST", "abc", "hello")
+	now = now.Add(time.Minute)
+	send(handler, "POST", "abc", "hello")
+	if calls.Load() != 2 {
+		t.Errorf("Expected the key to expire, handler ran %d times", calls.Load())
+	}
+	if len(store.entries) != 1 {
+		t.Errorf("Expected the expired entry to be swept, have %d", len(store.entries))
+	}
+}
+
+func TestMiddleware_ServerErrorsAreNotStored(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewStore(time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		}
+	}))
+
+	send(handler, "POST", "abc", "hello")
+	if rr := send(handler, "POST", "abc", "hello"); rr.Code != http.StatusOK || calls.Load() != 2 {
+		t.Errorf("Expected the retry to run, got %v after %d calls", rr.Code, calls.Load())
+	}
+}
+
+func TestMiddleware_ConcurrentDuplicatesWait(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := NewStore(time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		codes[0] = send(handler, "POST", "abc", "hello").Code
+	}()
+	<-started
+	for i := 1; i < len(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = send(handler, "POST", "abc", "hello").Code
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected the handler to run once, ran %d times", calls.Load())
+	}
+	for i, code := range codes {
+		if code != http.StatusCreated {
+			t.Errorf("Request %d got %v", i, code)
+		}
+	}
+}
+
+func TestMiddleware_PanicReleasesKey(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewStore(time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+	}))
+
+	func() {
+		defer func() { recover() }()
+		send(handler, "POST", "abc", "hello")
+	}()
+	send(handler, "POST", "abc", "hello")
+	if calls.Load() != 2 {
+		t.Errorf("Expected the key to be released after a panic, handler ran %d times", calls.Load())
+	}
+}
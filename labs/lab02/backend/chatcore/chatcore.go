@@ -2,61 +2,249 @@ package chatcore
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrBrokerClosed is returned when the broker's context is done
+	ErrBrokerClosed = errors.New("broker closed")
+	// ErrUserNotRegistered is returned when subscribing an unknown user
+	ErrUserNotRegistered = errors.New("user not registered")
+	// ErrUnknownMessage is returned when acknowledging a message that is not pending
+	ErrUnknownMessage = errors.New("unknown message")
+	// ErrNoRecipient is returned when sending a message that is not a
+	// broadcast or topic message and has no recipient
+	ErrNoRecipient = errors.New("message has no recipient")
 )
 
 // Message represents a chat message
-// Sender, Recipient, Content, Broadcast, Timestamp
-// TODO: Add more fields if needed
+// ID, Sender, Recipient, Content, Broadcast, Timestamp
+// Topic is set for messages published to a topic
+// The broker assigns an ID to messages sent without one
+// Payload carries application data the broker routes without inspecting
 
 type Message struct {
+	ID        string
 	Sender    string
 	Recipient string
 	Content   string
 	Broadcast bool
 	Timestamp int64
+	Topic     string
+	Payload   any
+}
+
+// Config holds broker delivery settings
+
+type Config struct {
+	MailboxCapacity    int           // Messages kept per offline user; 0 disables mailboxes
+	MailboxTTL         time.Duration // How long offline messages are kept; 0 keeps them until delivered
+	AckTimeout         time.Duration // Redelivery timeout for unacknowledged messages; 0 disables acks
+	MaxDeliveries      int           // Delivery attempts before an unacknowledged message is dead-lettered
+	DeadLetterCapacity int           // Most recent dead letters kept for inspection
+	SweepInterval      time.Duration // How often redelivery, expiry and mailbox flushing run
+	Middleware         []Middleware  // Stages every message passes through in SendMessage
+}
+
+// DefaultConfig returns the settings used by NewBroker
+func DefaultConfig() Config {
+	return Config{
+		MailboxCapacity:    100,
+		MailboxTTL:         24 * time.Hour,
+		AckTimeout:         0,
+		MaxDeliveries:      5,
+		DeadLetterCapacity: 1000,
+		SweepInterval:      100 * time.Millisecond,
+	}
 }
 
 // Broker handles message routing between users
-// Contains context, input channel, user registry, mutex, done channel
+// Contains context, input channel, user registry, topic subscriptions,
+// offline mailboxes, pending acknowledgements, mutex, done channel
+//
+// Delivery guarantees:
+//   - Messages are routed by a single goroutine in the order SendMessage
+//     accepted them, so every recipient sees them in that order.
+//   - Without acknowledgements (the default) delivery is at-most-once: if a
+//     recipient's channel is full the message is dropped for that recipient.
+//   - With Config.AckTimeout set, delivery is at-least-once: each delivered
+//     message stays pending until the recipient calls Ack and is redelivered
+//     after the timeout, up to Config.MaxDeliveries attempts, after which it
+//     becomes a dead letter. Recipients must tolerate duplicates.
+//   - Direct messages to users that are not registered are kept in a bounded
+//     mailbox and delivered when the user registers. Messages that expire or
+//     overflow the mailbox become dead letters.
+//   - A message accepted after RegisterUser or Subscribe returns is routed
+//     to that user; once UnregisterUser or Unsubscribe returns, nothing more
+//     is written to the user's channel for the removed registration.
+//   - A topic message reaches each user at most once, even when several
+//     of their subscriptions match.
 
 type Broker struct {
-	ctx        context.Context
-	input      chan Message            // Incoming messages
-	users      map[string]chan Message // userID -> receiving channel
-	usersMutex sync.RWMutex            // Protects users map
-	done       chan struct{}           // For shutdown
-	// TODO: Add more fields if needed
+	ctx             context.Context
+	config          Config
+	input           chan Message                           // Incoming messages
+	users           map[string]chan Message                // userID -> receiving channel
+	subscriptions   map[string][]subscription              // userID -> topic subscriptions
+	mailboxes       map[string][]mailboxEntry              // userID -> messages awaiting delivery
+	pending         map[string]map[string]*pendingDelivery // userID -> message ID -> unacknowledged delivery
+	deadLetters     []DeadLetter                           // Most recent undeliverable messages
+	usersMutex      sync.RWMutex                           // Protects all of the above
+	nextID          atomic.Uint64                          // Source of generated message IDs
+	middleware      []Middleware                           // Pipeline run by SendMessage
+	middlewareMutex sync.RWMutex                           // Protects middleware
+	done            chan struct{}                          // For shutdown
 }
 
 // NewBroker creates a new message broker
 func NewBroker(ctx context.Context) *Broker {
-	// TODO: Initialize broker fields
+	return NewBrokerWithConfig(ctx, DefaultConfig())
+}
+
+// NewBrokerWithConfig creates a new message broker with the given delivery settings
+func NewBrokerWithConfig(ctx context.Context, config Config) *Broker {
+	if config.SweepInterval <= 0 {
+		config.SweepInterval = DefaultConfig().SweepInterval
+	}
+	if config.MaxDeliveries <= 0 {
+		config.MaxDeliveries = DefaultConfig().MaxDeliveries
+	}
+
 	return &Broker{
-		ctx:   ctx,
-		input: make(chan Message, 100),
-		users: make(map[string]chan Message),
-		done:  make(chan struct{}),
+		ctx:           ctx,
+		config:        config,
+		input:         make(chan Message, 100),
+		users:         make(map[string]chan Message),
+		subscriptions: make(map[string][]subscription),
+		mailboxes:     make(map[string][]mailboxEntry),
+		pending:       make(map[string]map[string]*pendingDelivery),
+		middleware:    append([]Middleware(nil), config.Middleware...),
+		done:          make(chan struct{}),
 	}
 }
 
 // Run starts the broker event loop (goroutine)
 func (b *Broker) Run() {
-	// TODO: Implement event loop (fan-in/fan-out pattern)
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.config.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case msg := <-b.input:
+			b.route(msg)
+		case now := <-ticker.C:
+			b.sweep(now)
+		}
+	}
+}
+
+// Done returns a channel closed when Run has returned
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
 }
 
-// SendMessage sends a message to the broker
+// route fans a message out to its recipients
+func (b *Broker) route(msg Message) {
+	b.usersMutex.Lock()
+	defer b.usersMutex.Unlock()
+
+	now := time.Now()
+	switch {
+	case msg.Topic != "":
+		topic := strings.Split(msg.Topic, ".")
+		for userID, subs := range b.subscriptions {
+			if matchesAny(subs, topic, msg) {
+				b.deliverTo(userID, msg, now)
+			}
+		}
+	case msg.Broadcast:
+		for userID := range b.users {
+			b.deliverTo(userID, msg, now)
+		}
+	default:
+		if _, ok := b.users[msg.Recipient]; ok {
+			b.deliverTo(msg.Recipient, msg, now)
+		} else {
+			b.storeOffline(msg.Recipient, msg, now)
+		}
+	}
+}
+
+// deliver writes msg to recv without blocking the broker
+func deliver(recv chan Message, msg Message) bool {
+	select {
+	case recv <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// SendMessage sends a message to the broker after running it through the
+// middleware pipeline. A rejected message returns a *RejectError.
 func (b *Broker) SendMessage(msg Message) error {
-	// TODO: Send message to appropriate channel/queue
-	return nil
+	_, err := b.Submit(msg)
+	return err
+}
+
+// Submit is SendMessage that also returns the message as routed, with its
+// ID and any changes made by middleware
+func (b *Broker) Submit(msg Message) (Message, error) {
+	if b.ctx.Err() != nil {
+		return Message{}, ErrBrokerClosed
+	}
+	if msg.ID == "" {
+		msg.ID = strconv.FormatUint(b.nextID.Add(1), 10)
+	}
+	if err := b.runMiddleware(&msg); err != nil {
+		return Message{}, err
+	}
+	switch {
+	case msg.Topic != "":
+		if err := validateTopic(msg.Topic); err != nil {
+			return Message{}, err
+		}
+	case !msg.Broadcast && msg.Recipient == "":
+		return Message{}, ErrNoRecipient
+	}
+
+	select {
+	case b.input <- msg:
+		return msg, nil
+	case <-b.ctx.Done():
+		return Message{}, ErrBrokerClosed
+	}
+}
+
+// Publish sends a message to every user subscribed to a matching topic
+func (b *Broker) Publish(topic string, msg Message) error {
+	msg.Topic = topic
+	return b.SendMessage(msg)
 }
 
-// RegisterUser adds a user to the broker
+// RegisterUser adds a user to the broker and delivers their offline mailbox
 func (b *Broker) RegisterUser(userID string, recv chan Message) {
-	// TODO: Register user and their receiving channel
+	b.usersMutex.Lock()
+	defer b.usersMutex.Unlock()
+	b.users[userID] = recv
+	b.flushMailbox(userID, time.Now())
 }
 
-// UnregisterUser removes a user from the broker
+// UnregisterUser removes a user and all of their subscriptions from the broker.
+// Unacknowledged messages go back to the user's mailbox.
 func (b *Broker) UnregisterUser(userID string) {
-	// TODO: Remove user from registry
+	b.usersMutex.Lock()
+	defer b.usersMutex.Unlock()
+	b.returnPending(userID)
+	delete(b.users, userID)
+	delete(b.subscriptions, userID)
 }
@@ -2,6 +2,7 @@ package chatcore
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -123,6 +124,20 @@ func TestBrokerPrivateMessage(t *testing.T) {
 	}
 }
 
+func TestBrokerRejectsMissingRecipient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	if err := broker.SendMessage(Message{Sender: "A", Content: "to nobody"}); !errors.Is(err, ErrNoRecipient) {
+		t.Errorf("Expected ErrNoRecipient, got %v", err)
+	}
+	if size := broker.MailboxSize(""); size != 0 {
+		t.Errorf("Expected no mailbox for an empty recipient, got %d messages", size)
+	}
+}
+
 func TestBrokerContextCancellation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	broker := NewBroker(ctx)
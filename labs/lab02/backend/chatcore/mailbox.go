@@ -0,0 +1,280 @@
+package chatcore
+
+import (
+	"sort"
+	"time"
+)
+
+// Dead letter reasons
+const (
+	ReasonMailboxFull   = "mailbox full"
+	ReasonExpired       = "expired"
+	ReasonMaxDeliveries = "max deliveries exceeded"
+	ReasonNoMailbox     = "recipient offline"
+)
+
+// DeadLetter is a message the broker gave up delivering
+// Message, UserID (intended recipient), Reason, Attempts, Time
+
+type DeadLetter struct {
+	Message  Message
+	UserID   string
+	Reason   string
+	Attempts int
+	Time     time.Time
+}
+
+// mailboxEntry is a message waiting for its recipient
+type mailboxEntry struct {
+	msg      Message
+	stored   time.Time
+	attempts int // Deliveries already made before the message came back to the mailbox
+}
+
+// pendingDelivery is a delivered message awaiting Ack
+type pendingDelivery struct {
+	msg      Message
+	seq      uint64 // Delivery order, used to requeue in order
+	attempts int
+	deadline time.Time
+	sent     bool // False while the recipient's channel had no room
+}
+
+// acksEnabled reports whether delivery is at-least-once
+func (b *Broker) acksEnabled() bool {
+	return b.config.AckTimeout > 0
+}
+
+// deliverTo writes msg to a registered user's channel, tracking it for
+// acknowledgement when acks are enabled. Caller must hold usersMutex.
+func (b *Broker) deliverTo(userID string, msg Message, now time.Time) {
+	b.deliverAttempt(userID, msg, 0, now)
+}
+
+// deliverAttempt delivers msg counting previous attempts toward MaxDeliveries
+func (b *Broker) deliverAttempt(userID string, msg Message, attempts int, now time.Time) {
+	sent := deliver(b.users[userID], msg)
+	if b.acksEnabled() {
+		b.track(userID, msg, attempts, sent, now)
+	}
+}
+
+// track records a delivery attempt awaiting acknowledgement. Caller must
+// hold usersMutex.
+func (b *Broker) track(userID string, msg Message, attempts int, sent bool, now time.Time) {
+	pending := b.pending[userID]
+	if pending == nil {
+		pending = make(map[string]*pendingDelivery)
+		b.pending[userID] = pending
+	}
+	pending[msg.ID] = &pendingDelivery{
+		msg:      msg,
+		seq:      b.nextID.Add(1),
+		attempts: attempts + 1,
+		deadline: now.Add(b.config.AckTimeout),
+		sent:     sent, // A message that found no room is retried by sweep
+	}
+}
+
+// storeOffline appends msg to an unregistered user's mailbox, dead-lettering
+// the oldest entry when the mailbox is full. Caller must hold usersMutex.
+func (b *Broker) storeOffline(userID string, msg Message, now time.Time) {
+	b.storeEntry(userID, mailboxEntry{msg: msg, stored: now}, now)
+}
+
+func (b *Broker) storeEntry(userID string, entry mailboxEntry, now time.Time) {
+	if b.config.MailboxCapacity <= 0 {
+		b.deadLetter(userID, entry.msg, ReasonNoMailbox, entry.attempts, now)
+		return
+	}
+
+	mailbox := append(b.mailboxes[userID], entry)
+	for len(mailbox) > b.config.MailboxCapacity {
+		b.deadLetter(userID, mailbox[0].msg, ReasonMailboxFull, mailbox[0].attempts, now)
+		mailbox = mailbox[1:]
+	}
+	b.mailboxes[userID] = mailbox
+}
+
+// flushMailbox delivers as much of a registered user's mailbox as fits in
+// their channel; the rest stays queued for the next sweep. Caller must hold
+// usersMutex.
+func (b *Broker) flushMailbox(userID string, now time.Time) {
+	mailbox := b.mailboxes[userID]
+	if len(mailbox) == 0 {
+		return
+	}
+	recv := b.users[userID]
+
+	for len(mailbox) > 0 {
+		entry := mailbox[0]
+		if b.expired(entry, now) {
+			b.deadLetter(userID, entry.msg, ReasonExpired, entry.attempts, now)
+			mailbox = mailbox[1:]
+			continue
+		}
+		if !deliver(recv, entry.msg) {
+			break
+		}
+		if b.acksEnabled() {
+			b.track(userID, entry.msg, entry.attempts, true, now)
+		}
+		mailbox = mailbox[1:]
+	}
+
+	if len(mailbox) == 0 {
+		delete(b.mailboxes, userID)
+	} else {
+		b.mailboxes[userID] = mailbox
+	}
+}
+
+// returnPending moves a user's unacknowledged messages back to the front of
+// their mailbox, oldest first. Caller must hold usersMutex.
+func (b *Broker) returnPending(userID string) {
+	pending := b.pending[userID]
+	delete(b.pending, userID)
+	if len(pending) == 0 {
+		return
+	}
+
+	requeue := make([]*pendingDelivery, 0, len(pending))
+	for _, p := range pending {
+		requeue = append(requeue, p)
+	}
+	sort.Slice(requeue, func(i, j int) bool { return requeue[i].seq < requeue[j].seq })
+
+	now := time.Now()
+	queued := b.mailboxes[userID]
+	b.mailboxes[userID] = nil
+	for _, p := range requeue {
+		attempts := p.attempts
+		if !p.sent {
+			attempts--
+		}
+		b.storeEntry(userID, mailboxEntry{msg: p.msg, stored: now, attempts: attempts}, now)
+	}
+	for _, entry := range queued {
+		b.storeEntry(userID, entry, now)
+	}
+}
+
+// expired reports whether a mailbox entry has outlived MailboxTTL
+func (b *Broker) expired(entry mailboxEntry, now time.Time) bool {
+	return b.config.MailboxTTL > 0 && now.Sub(entry.stored) >= b.config.MailboxTTL
+}
+
+// sweep redelivers timed-out messages, expires offline messages and flushes
+// mailboxes of registered users
+func (b *Broker) sweep(now time.Time) {
+	b.usersMutex.Lock()
+	defer b.usersMutex.Unlock()
+
+	for userID, pending := range b.pending {
+		recv := b.users[userID]
+		retry := make([]*pendingDelivery, 0)
+		for _, p := range pending {
+			if p.sent && now.Before(p.deadline) {
+				continue
+			}
+			retry = append(retry, p)
+		}
+		sort.Slice(retry, func(i, j int) bool { return retry[i].seq < retry[j].seq })
+
+		for _, p := range retry {
+			if p.sent && p.attempts >= b.config.MaxDeliveries {
+				delete(pending, p.msg.ID)
+				b.deadLetter(userID, p.msg, ReasonMaxDeliveries, p.attempts, now)
+				continue
+			}
+			if !deliver(recv, p.msg) {
+				continue
+			}
+			if p.sent {
+				p.attempts++
+			}
+			p.sent = true
+			p.deadline = now.Add(b.config.AckTimeout)
+		}
+		if len(pending) == 0 {
+			delete(b.pending, userID)
+		}
+	}
+
+	for userID, mailbox := range b.mailboxes {
+		if _, ok := b.users[userID]; ok {
+			b.flushMailbox(userID, now)
+			continue
+		}
+		kept := mailbox[:0]
+		for _, entry := range mailbox {
+			if b.expired(entry, now) {
+				b.deadLetter(userID, entry.msg, ReasonExpired, entry.attempts, now)
+			} else {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == 0 {
+			delete(b.mailboxes, userID)
+		} else {
+			b.mailboxes[userID] = kept
+		}
+	}
+}
+
+// deadLetter records an undeliverable message, keeping the most recent
+// DeadLetterCapacity entries. Caller must hold usersMutex.
+func (b *Broker) deadLetter(userID string, msg Message, reason string, attempts int, now time.Time) {
+	if b.config.DeadLetterCapacity <= 0 {
+		return
+	}
+	b.deadLetters = append(b.deadLetters, DeadLetter{
+		Message:  msg,
+		UserID:   userID,
+		Reason:   reason,
+		Attempts: attempts,
+		Time:     now,
+	})
+	if over := len(b.deadLetters) - b.config.DeadLetterCapacity; over > 0 {
+		b.deadLetters = append([]DeadLetter(nil), b.deadLetters[over:]...)
+	}
+}
+
+// Ack confirms that userID processed the message with the given ID, so it
+// will not be redelivered. Message IDs are shared by every recipient of a
+// broadcast, hence the user ID.
+func (b *Broker) Ack(userID, messageID string) error {
+	b.usersMutex.Lock()
+	defer b.usersMutex.Unlock()
+
+	pending := b.pending[userID]
+	if _, ok := pending[messageID]; !ok {
+		return ErrUnknownMessage
+	}
+	delete(pending, messageID)
+	if len(pending) == 0 {
+		delete(b.pending, userID)
+	}
+	return nil
+}
+
+// Pending returns the number of messages delivered to userID and not yet acknowledged
+func (b *Broker) Pending(userID string) int {
+	b.usersMutex.RLock()
+	defer b.usersMutex.RUnlock()
+	return len(b.pending[userID])
+}
+
+// MailboxSize returns the number of messages waiting in userID's mailbox
+func (b *Broker) MailboxSize(userID string) int {
+	b.usersMutex.RLock()
+	defer b.usersMutex.RUnlock()
+	return len(b.mailboxes[userID])
+}
+
+// DeadLetters returns a copy of the recorded dead letters, oldest first
+func (b *Broker) DeadLetters() []DeadLetter {
+	b.usersMutex.RLock()
+	defer b.usersMutex.RUnlock()
+	return append([]DeadLetter(nil), b.deadLetters...)
+}
@@ -0,0 +1,210 @@
+package chatcore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestBroker(t *testing.T, config Config) *Broker {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	broker := NewBrokerWithConfig(ctx, config)
+	go broker.Run()
+	return broker
+}
+
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMailboxFlushOnRegister(t *testing.T) {
+	config := DefaultConfig()
+	config.SweepInterval = 10 * time.Millisecond
+	broker := newTestBroker(t, config)
+
+	for i := 0; i < 15; i++ {
+		broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: fmt.Sprint(i)})
+	}
+	waitFor(t, "mailbox", func() bool { return broker.MailboxSize("B") == 15 })
+
+	// The channel only holds 10; the rest follows once there is room
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	for i := 0; i < 15; i++ {
+		expectMessage(t, b, fmt.Sprint(i))
+	}
+	if size := broker.MailboxSize("B"); size != 0 {
+		t.Errorf("Expected empty mailbox, got %d", size)
+	}
+}
+
+func TestMailboxFlushUnbufferedChannel(t *testing.T) {
+	for _, acks := range []bool{false, true} {
+		t.Run(fmt.Sprintf("acks=%v", acks), func(t *testing.T) {
+			config := DefaultConfig()
+			config.SweepInterval = 10 * time.Millisecond
+			if acks {
+				config.AckTimeout = time.Minute
+			}
+			broker := newTestBroker(t, config)
+
+			broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: "waiting"})
+			waitFor(t, "mailbox", func() bool { return broker.MailboxSize("B") == 1 })
+
+			// A ready receiver takes the message on a later sweep
+			recv := make(chan Message)
+			broker.RegisterUser("B", recv)
+			select {
+			case msg := <-recv:
+				if msg.Content != "waiting" {
+					t.Errorf("Unexpected message %+v", msg)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Mailbox was never flushed to an unbuffered channel")
+			}
+			if acks {
+				waitFor(t, "pending", func() bool { return broker.Pending("B") == 1 })
+			}
+		})
+	}
+}
+
+func TestMailboxCapacityAndTTL(t *testing.T) {
+	config := DefaultConfig()
+	config.MailboxCapacity = 3
+	config.MailboxTTL = 50 * time.Millisecond
+	config.SweepInterval = 10 * time.Millisecond
+	broker := newTestBroker(t, config)
+
+	for i := 0; i < 5; i++ {
+		broker.SendMessage(Message{ID: fmt.Sprint(i), Sender: "A", Recipient: "B", Content: "x"})
+	}
+	waitFor(t, "overflow", func() bool { return len(broker.DeadLetters()) == 2 })
+	for i, dl := range broker.DeadLetters() {
+		if dl.Reason != ReasonMailboxFull || dl.Message.ID != fmt.Sprint(i) || dl.UserID != "B" {
+			t.Errorf("Unexpected dead letter %+v", dl)
+		}
+	}
+
+	waitFor(t, "expiry", func() bool { return broker.MailboxSize("B") == 0 })
+	dead := broker.DeadLetters()
+	if len(dead) != 5 || dead[4].Reason != ReasonExpired {
+		t.Errorf("Expected 3 expired dead letters, got %+v", dead)
+	}
+
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	expectNoMessage(t, b)
+}
+
+func TestAckRedelivery(t *testing.T) {
+	config := DefaultConfig()
+	config.AckTimeout = 30 * time.Millisecond
+	config.MaxDeliveries = 3
+	config.SweepInterval = 5 * time.Millisecond
+	broker := newTestBroker(t, config)
+
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	broker.SendMessage(Message{ID: "m1", Sender: "A", Recipient: "B", Content: "acked"})
+	broker.SendMessage(Message{ID: "m2", Sender: "A", Recipient: "B", Content: "ignored"})
+
+	expectMessage(t, b, "acked")
+	if err := broker.Ack("B", "m1"); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	if err := broker.Ack("B", "m1"); err != ErrUnknownMessage {
+		t.Errorf("Expected ErrUnknownMessage for double ack, got %v", err)
+	}
+
+	// m2 is never acked: delivered MaxDeliveries times, then dead-lettered
+	for i := 0; i < 3; i++ {
+		expectMessage(t, b, "ignored")
+	}
+	waitFor(t, "dead letter", func() bool { return len(broker.DeadLetters()) == 1 })
+	dl := broker.DeadLetters()[0]
+	if dl.Message.ID != "m2" || dl.Reason != ReasonMaxDeliveries || dl.Attempts != 3 {
+		t.Errorf("Unexpected dead letter %+v", dl)
+	}
+	if n := broker.Pending("B"); n != 0 {
+		t.Errorf("Expected nothing pending, got %d", n)
+	}
+	expectNoMessage(t, b)
+}
+
+func TestAckFullChannelIsRetried(t *testing.T) {
+	config := DefaultConfig()
+	config.AckTimeout = time.Hour
+	config.SweepInterval = 5 * time.Millisecond
+	broker := newTestBroker(t, config)
+
+	recv := make(chan Message, 1)
+	broker.RegisterUser("B", recv)
+	broker.SendMessage(Message{ID: "m1", Recipient: "B", Content: "first"})
+	broker.SendMessage(Message{ID: "m2", Recipient: "B", Content: "second"})
+	waitFor(t, "pending", func() bool { return broker.Pending("B") == 2 })
+
+	// m2 found no room; it is sent as soon as m1 is read, without waiting for AckTimeout
+	for _, want := range []string{"first", "second"} {
+		select {
+		case m := <-recv:
+			if m.Content != want {
+				t.Errorf("Got %q, want %q", m.Content, want)
+			}
+			broker.Ack("B", m.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive %q", want)
+		}
+	}
+}
+
+func TestUnregisterReturnsPendingToMailbox(t *testing.T) {
+	config := DefaultConfig()
+	config.AckTimeout = time.Hour
+	broker := newTestBroker(t, config)
+
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	broker.SendMessage(Message{ID: "m1", Recipient: "B", Content: "one"})
+	broker.SendMessage(Message{ID: "m2", Recipient: "B", Content: "two"})
+	expectMessage(t, b, "one")
+	expectMessage(t, b, "two")
+	broker.Ack("B", "m1")
+
+	// Disconnecting without acking m2 queues it for the next session
+	broker.UnregisterUser("B")
+	broker.SendMessage(Message{ID: "m3", Recipient: "B", Content: "three"})
+	waitFor(t, "mailbox", func() bool { return broker.MailboxSize("B") == 2 })
+
+	b = newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	expectMessage(t, b, "two")
+	expectMessage(t, b, "three")
+}
+
+func TestSendMessageAssignsIDs(t *testing.T) {
+	config := DefaultConfig()
+	config.AckTimeout = time.Hour
+	broker := newTestBroker(t, config)
+
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	broker.SendMessage(Message{Recipient: "B", Content: "x"})
+	broker.SendMessage(Message{Recipient: "B", Content: "y"})
+
+	first, second := <-b.Recv, <-b.Recv
+	if first.ID == "" || first.ID == second.ID {
+		t.Errorf("Expected distinct generated IDs, got %q and %q", first.ID, second.ID)
+	}
+}
@@ -0,0 +1,258 @@
+package chatcore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Errors wrapped by RejectError, for use with errors.Is
+var (
+	ErrProfanity      = errors.New("message contains profanity")
+	ErrMessageTooLong = errors.New("message too long")
+	ErrSpam           = errors.New("repeated message")
+	ErrBlocked        = errors.New("sender is blocked by recipient")
+)
+
+// RejectError is returned from SendMessage when a middleware stage rejects a message
+// Stage names the middleware, Err is one of the sentinel errors above or a
+// custom stage error
+
+type RejectError struct {
+	Stage string
+	Err   error
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// reject wraps err in a RejectError for stage
+func reject(stage string, err error) error {
+	return &RejectError{Stage: stage, Err: err}
+}
+
+// Middleware inspects or transforms a message before it is routed.
+// Returning an error rejects the message; SendMessage returns the error
+// wrapped in a RejectError. Middleware runs on the caller's goroutine and
+// must be safe for concurrent use.
+type Middleware func(msg *Message) error
+
+// Chain combines middleware into one stage that runs them in order and stops
+// at the first rejection
+func Chain(stages ...Middleware) Middleware {
+	return func(msg *Message) error {
+		for _, stage := range stages {
+			if err := stage(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Use appends middleware to the broker's pipeline
+func (b *Broker) Use(stages ...Middleware) {
+	b.middlewareMutex.Lock()
+	defer b.middlewareMutex.Unlock()
+	b.middleware = append(b.middleware, stages...)
+}
+
+// runMiddleware passes msg through the pipeline
+func (b *Broker) runMiddleware(msg *Message) error {
+	b.middlewareMutex.RLock()
+	stages := b.middleware
+	b.middlewareMutex.RUnlock()
+
+	if err := Chain(stages...)(msg); err != nil {
+		var rejected *RejectError
+		if errors.As(err, &rejected) {
+			return err
+		}
+		return reject("middleware", err)
+	}
+	return nil
+}
+
+// MaxLength rejects messages whose content is longer than limit characters
+func MaxLength(limit int) Middleware {
+	return func(msg *Message) error {
+		if utf8.RuneCountInString(msg.Content) > limit {
+			return reject("max_length", ErrMessageTooLong)
+		}
+		return nil
+	}
+}
+
+// ProfanityFilter matches whole words case-insensitively. With mask set,
+// matching words are replaced by asterisks instead of rejecting the message.
+func ProfanityFilter(words []string, mask bool) Middleware {
+	banned := make(map[string]bool, len(words))
+	for _, word := range words {
+		banned[strings.ToLower(word)] = true
+	}
+
+	return func(msg *Message) error {
+		var out strings.Builder
+		found := false
+		content := msg.Content
+		for len(content) > 0 {
+			// Split off the next run of letters/digits, copying separators as-is
+			end := strings.IndexFunc(content, func(r rune) bool { return !isWordRune(r) })
+			if end == 0 {
+				_, size := utf8.DecodeRuneInString(content)
+				out.WriteString(content[:size])
+				content = content[size:]
+				continue
+			}
+			if end < 0 {
+				end = len(content)
+			}
+			word := content[:end]
+			content = content[end:]
+
+			if banned[strings.ToLower(word)] {
+				if !mask {
+					return reject("profanity", ErrProfanity)
+				}
+				found = true
+				word = strings.Repeat("*", utf8.RuneCountInString(word))
+			}
+			out.WriteString(word)
+		}
+		if found {
+			msg.Content = out.String()
+		}
+		return nil
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// spamKey identifies identical content from one sender
+type spamKey struct {
+	sender  string
+	content string
+}
+
+// SpamFilter rejects a message when its sender already sent the same content
+// maxRepeats times within window. Comparison ignores case and surrounding
+// whitespace.
+func SpamFilter(maxRepeats int, window time.Duration) Middleware {
+	return spamFilter(maxRepeats, window, time.Now)
+}
+
+func spamFilter(maxRepeats int, window time.Duration, now func() time.Time) Middleware {
+	var mutex sync.Mutex
+	seen := make(map[spamKey][]time.Time)
+	lastPrune := now()
+
+	return func(msg *Message) error {
+		key := spamKey{msg.Sender, strings.ToLower(strings.TrimSpace(msg.Content))}
+		t := now()
+		cutoff := t.Add(-window)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// Forget senders that went quiet so the map does not grow without bound
+		if t.Sub(lastPrune) > window {
+			for k, times := range seen {
+				if !times[len(times)-1].After(cutoff) {
+					delete(seen, k)
+				}
+			}
+			lastPrune = t
+		}
+
+		times := seen[key]
+		for len(times) > 0 && !times[0].After(cutoff) {
+			times = times[1:]
+		}
+		if len(times) >= maxRepeats {
+			seen[key] = times
+			return reject("spam", ErrSpam)
+		}
+		seen[key] = append(times, t)
+		return nil
+	}
+}
+
+// BlockList records which users have blocked which senders
+type BlockList struct {
+	mutex   sync.RWMutex
+	blocked map[string]map[string]bool // userID -> blocked sender IDs
+}
+
+// NewBlockList creates an empty block list
+func NewBlockList() *BlockList {
+	return &BlockList{blocked: make(map[string]map[string]bool)}
+}
+
+// Block stops direct messages from sender reaching userID
+func (l *BlockList) Block(userID, sender string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.blocked[userID] == nil {
+		l.blocked[userID] = make(map[string]bool)
+	}
+	l.blocked[userID][sender] = true
+}
+
+// Unblock removes a block
+func (l *BlockList) Unblock(userID, sender string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.blocked[userID], sender)
+	if len(l.blocked[userID]) == 0 {
+		delete(l.blocked, userID)
+	}
+}
+
+// IsBlocked reports whether userID has blocked sender
+func (l *BlockList) IsBlocked(userID, sender string) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.blocked[userID][sender]
+}
+
+// Middleware rejects direct messages to a recipient who blocked the sender.
+// Broadcast and topic messages are not addressed to one user and pass through.
+func (l *BlockList) Middleware() Middleware {
+	return func(msg *Message) error {
+		if msg.Broadcast || msg.Topic != "" {
+			return nil
+		}
+		if l.IsBlocked(msg.Recipient, msg.Sender) {
+			return reject("block_list", ErrBlocked)
+		}
+		return nil
+	}
+}
+
+// Enrich applies fn to every message, e.g. to normalize content or set fields
+func Enrich(fn func(msg *Message)) Middleware {
+	return func(msg *Message) error {
+		fn(msg)
+		return nil
+	}
+}
+
+// Timestamp sets Timestamp (Unix seconds) on messages sent without one
+func Timestamp() Middleware {
+	return Enrich(func(msg *Message) {
+		if msg.Timestamp == 0 {
+			msg.Timestamp = time.Now().Unix()
+		}
+	})
+}
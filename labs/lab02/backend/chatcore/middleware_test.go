@@ -0,0 +1,191 @@
+package chatcore
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxLength(t *testing.T) {
+	stage := MaxLength(5)
+	if err := stage(&Message{Content: "héllo"}); err != nil {
+		t.Errorf("Five characters should pass, got %v", err)
+	}
+	err := stage(&Message{Content: "hello!"})
+	if !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("Expected ErrMessageTooLong, got %v", err)
+	}
+}
+
+func TestProfanityFilter(t *testing.T) {
+	words := []string{"darn", "heck"}
+
+	if err := ProfanityFilter(words, false)(&Message{Content: "oh DARN it"}); !errors.Is(err, ErrProfanity) {
+		t.Errorf("Expected ErrProfanity, got %v", err)
+	}
+	// Whole words only
+	if err := ProfanityFilter(words, false)(&Message{Content: "checkered darning"}); err != nil {
+		t.Errorf("Substrings should not match, got %v", err)
+	}
+
+	msg := Message{Content: "what the heck, darn!"}
+	if err := ProfanityFilter(words, true)(&msg); err != nil {
+		t.Fatalf("Masking filter should not reject, got %v", err)
+	}
+	if msg.Content != "what the ****, ****!" {
+		t.Errorf("Unexpected masked content %q", msg.Content)
+	}
+}
+
+func TestSpamFilter(t *testing.T) {
+	now := time.Unix(0, 0)
+	stage := spamFilter(2, time.Minute, func() time.Time { return now })
+
+	send := func(sender, content string) error {
+		return stage(&Message{Sender: sender, Content: content})
+	}
+	if err := send("A", "buy now"); err != nil {
+		t.Fatalf("First message rejected: %v", err)
+	}
+	if err := send("A", "  BUY NOW "); err != nil {
+		t.Fatalf("Second message rejected: %v", err)
+	}
+	if err := send("A", "buy now"); !errors.Is(err, ErrSpam) {
+		t.Errorf("Expected ErrSpam, got %v", err)
+	}
+	if err := send("B", "buy now"); err != nil {
+		t.Errorf("Other senders are tracked separately, got %v", err)
+	}
+	if err := send("A", "something else"); err != nil {
+		t.Errorf("Different content should pass, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := send("A", "buy now"); err != nil {
+		t.Errorf("Repeats should be forgotten after the window, got %v", err)
+	}
+}
+
+func TestBlockList(t *testing.T) {
+	list := NewBlockList()
+	list.Block("A", "B")
+	stage := list.Middleware()
+
+	if err := stage(&Message{Sender: "B", Recipient: "A"}); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Expected ErrBlocked, got %v", err)
+	}
+	if err := stage(&Message{Sender: "A", Recipient: "B"}); err != nil {
+		t.Errorf("Blocking is one-way, got %v", err)
+	}
+	if err := stage(&Message{Sender: "B", Broadcast: true}); err != nil {
+		t.Errorf("Broadcasts pass through, got %v", err)
+	}
+
+	list.Unblock("A", "B")
+	if err := stage(&Message{Sender: "B", Recipient: "A"}); err != nil {
+		t.Errorf("Expected message after unblock, got %v", err)
+	}
+}
+
+func TestBrokerMiddlewarePipeline(t *testing.T) {
+	blocks := NewBlockList()
+	blocks.Block("B", "C")
+
+	config := DefaultConfig()
+	config.Middleware = []Middleware{
+		MaxLength(20),
+		ProfanityFilter([]string{"darn"}, true),
+		blocks.Middleware(),
+		Timestamp(),
+	}
+	broker := newTestBroker(t, config)
+	broker.Use(Enrich(func(msg *Message) {
+		msg.Content = strings.TrimSpace(msg.Content)
+	}))
+
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+
+	if err := broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: "  darn it  "}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	select {
+	case msg := <-b.Recv:
+		if msg.Content != "**** it" || msg.Timestamp == 0 {
+			t.Errorf("Expected masked, trimmed and timestamped message, got %+v", msg)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Message not delivered")
+	}
+
+	err := broker.SendMessage(Message{Sender: "C", Recipient: "B", Content: "hi"})
+	var rejected *RejectError
+	if !errors.As(err, &rejected) || rejected.Stage != "block_list" || !errors.Is(err, ErrBlocked) {
+		t.Errorf("Expected block_list RejectError, got %v", err)
+	}
+	err = broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: strings.Repeat("x", 21)})
+	if !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("Expected ErrMessageTooLong, got %v", err)
+	}
+	expectNoMessage(t, b)
+}
+
+func TestBrokerSubmitReturnsRoutedMessage(t *testing.T) {
+	broker := newTestBroker(t, DefaultConfig())
+	broker.Use(ProfanityFilter([]string{"darn"}, true))
+
+	routed, err := broker.Submit(Message{Sender: "A", Broadcast: true, Content: "darn"})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if routed.Content != "****" || routed.ID == "" {
+		t.Errorf("Expected masked message with an ID, got %+v", routed)
+	}
+
+	broker.Use(MaxLength(3))
+	if routed, err := broker.Submit(Message{Sender: "A", Broadcast: true, Content: "hello"}); !errors.Is(err, ErrMessageTooLong) || routed.ID != "" {
+		t.Errorf("Expected ErrMessageTooLong and no message, got %+v, %v", routed, err)
+	}
+}
+
+func TestBrokerMiddlewareCustomError(t *testing.T) {
+	errNoShouting := errors.New("no shouting")
+	broker := newTestBroker(t, DefaultConfig())
+	broker.Use(func(msg *Message) error {
+		if msg.Content == strings.ToUpper(msg.Content) {
+			return errNoShouting
+		}
+		return nil
+	})
+
+	err := broker.SendMessage(Message{Sender: "A", Broadcast: true, Content: "HELLO"})
+	var rejected *RejectError
+	if !errors.As(err, &rejected) || !errors.Is(err, errNoShouting) {
+		t.Errorf("Expected custom error wrapped in RejectError, got %v", err)
+	}
+}
+
+func TestSpamFilterConcurrent(t *testing.T) {
+	stage := SpamFilter(10, time.Minute)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	accepted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if stage(&Message{Sender: "A", Content: "same"}) == nil {
+				mutex.Lock()
+				accepted++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 10 {
+		t.Errorf("Expected exactly 10 accepted, got %d", accepted)
+	}
+}
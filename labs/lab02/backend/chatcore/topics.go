@@ -0,0 +1,130 @@
+package chatcore
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidTopic is returned for malformed topics and topic patterns
+var ErrInvalidTopic = errors.New("invalid topic")
+
+// Filter decides whether a subscriber receives a message.
+// Filters run on the broker goroutine and must not call back into the broker.
+type Filter func(Message) bool
+
+// subscription is one topic pattern a user listens to
+// Topics are dot-separated segments such as "team.backend". In patterns,
+// "*" matches exactly one segment and a trailing ">" matches one or more.
+
+type subscription struct {
+	pattern string
+	parts   []string
+	filter  Filter
+}
+
+// validateTopic checks a concrete topic used for publishing
+func validateTopic(topic string) error {
+	for _, part := range strings.Split(topic, ".") {
+		if part == "" || part == "*" || part == ">" {
+			return ErrInvalidTopic
+		}
+	}
+	return nil
+}
+
+// validatePattern checks a subscription pattern
+func validatePattern(pattern string) error {
+	parts := strings.Split(pattern, ".")
+	for i, part := range parts {
+		switch {
+		case part == "":
+			return ErrInvalidTopic
+		case part == ">" && i != len(parts)-1:
+			return ErrInvalidTopic
+		case part != "*" && part != ">" && strings.ContainsAny(part, "*>"):
+			return ErrInvalidTopic
+		}
+	}
+	return nil
+}
+
+// match reports whether a topic's segments match a pattern's segments
+func match(pattern, topic []string) bool {
+	for i, part := range pattern {
+		if part == ">" {
+			return len(topic) > i
+		}
+		if i >= len(topic) || (part != "*" && part != topic[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
+
+// matchesAny reports whether any subscription accepts the message
+func matchesAny(subs []subscription, topic []string, msg Message) bool {
+	for _, sub := range subs {
+		if match(sub.parts, topic) && (sub.filter == nil || sub.filter(msg)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe subscribes a registered user to a topic pattern
+func (b *Broker) Subscribe(userID, pattern string) error {
+	return b.SubscribeWithFilter(userID, pattern, nil)
+}
+
+// SubscribeWithFilter subscribes a registered user to a topic pattern,
+// delivering only messages accepted by filter. Subscribing again to the
+// same pattern replaces its filter.
+func (b *Broker) SubscribeWithFilter(userID, pattern string, filter Filter) error {
+	if err := validatePattern(pattern); err != nil {
+		return err
+	}
+
+	b.usersMutex.Lock()
+	defer b.usersMutex.Unlock()
+
+	if _, ok := b.users[userID]; !ok {
+		return ErrUserNotRegistered
+	}
+
+	sub := subscription{pattern: pattern, parts: strings.Split(pattern, "."), filter: filter}
+	subs := b.subscriptions[userID]
+	for i := range subs {
+		if subs[i].pattern == pattern {
+			subs[i] = sub
+			return nil
+		}
+	}
+	b.subscriptions[userID] = append(subs, sub)
+	return nil
+}
+
+// Unsubscribe removes a user's subscription to a topic pattern
+func (b *Broker) Unsubscribe(userID, pattern string) {
+	b.usersMutex.Lock()
+	defer b.usersMutex.Unlock()
+
+	subs := b.subscriptions[userID]
+	for i := range subs {
+		if subs[i].pattern == pattern {
+			b.subscriptions[userID] = append(subs[:i:i], subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Subscriptions returns the topic patterns a user is subscribed to
+func (b *Broker) Subscriptions(userID string) []string {
+	b.usersMutex.RLock()
+	defer b.usersMutex.RUnlock()
+
+	patterns := make([]string, 0, len(b.subscriptions[userID]))
+	for _, sub := range b.subscriptions[userID] {
+		patterns = append(patterns, sub.pattern)
+	}
+	return patterns
+}
@@ -0,0 +1,220 @@
+package chatcore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTopicMatching(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"team.backend", "team.backend", true},
+		{"team.backend", "team.frontend", false},
+		{"team.*", "team.backend", true},
+		{"team.*", "team", false},
+		{"team.*", "team.backend.alerts", false},
+		{"*.alerts", "ops.alerts", true},
+		{"team.>", "team.backend", true},
+		{"team.>", "team.backend.alerts", true},
+		{"team.>", "team", false},
+		{">", "anything.at.all", true},
+	}
+
+	for _, tt := range tests {
+		got := match(strings.Split(tt.pattern, "."), strings.Split(tt.topic, "."))
+		if got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestTopicValidation(t *testing.T) {
+	for _, pattern := range []string{"", "team.", ".team", "team.>.x", "te*m", "team..backend"} {
+		if err := validatePattern(pattern); err != ErrInvalidTopic {
+			t.Errorf("validatePattern(%q) = %v, want ErrInvalidTopic", pattern, err)
+		}
+	}
+	for _, topic := range []string{"", "team.*", "team.>", "a..b"} {
+		if err := validateTopic(topic); err != ErrInvalidTopic {
+			t.Errorf("validateTopic(%q) = %v, want ErrInvalidTopic", topic, err)
+		}
+	}
+}
+
+func expectMessage(t *testing.T, u *testUser, content string) {
+	t.Helper()
+	select {
+	case m := <-u.Recv:
+		if m.Content != content {
+			t.Errorf("%s got %q, want %q", u.ID, m.Content, content)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Errorf("%s did not receive %q", u.ID, content)
+	}
+}
+
+func expectNoMessage(t *testing.T, u *testUser) {
+	t.Helper()
+	select {
+	case m := <-u.Recv:
+		t.Errorf("%s got unexpected message %+v", u.ID, m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBrokerTopics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	a := newTestUser("A")
+	b := newTestUser("B")
+	c := newTestUser("C")
+	for _, u := range []*testUser{a, b, c} {
+		broker.RegisterUser(u.ID, u.Recv)
+	}
+
+	if err := broker.Subscribe("A", "team.*"); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	// Overlapping subscriptions must not cause duplicate delivery
+	if err := broker.Subscribe("A", "team.backend"); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if err := broker.Subscribe("B", "team.frontend"); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	if err := broker.Publish("team.backend", Message{Sender: "C", Content: "deploy"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	expectMessage(t, a, "deploy")
+	expectNoMessage(t, a)
+	expectNoMessage(t, b)
+	expectNoMessage(t, c)
+
+	broker.Unsubscribe("A", "team.*")
+	if got := broker.Subscriptions("A"); len(got) != 1 || got[0] != "team.backend" {
+		t.Errorf("Expected [team.backend], got %v", got)
+	}
+	if err := broker.Publish("team.frontend", Message{Sender: "C", Content: "ui"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	expectMessage(t, b, "ui")
+	expectNoMessage(t, a)
+}
+
+func TestBrokerTopicFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	a := newTestUser("A")
+	broker.RegisterUser(a.ID, a.Recv)
+	err := broker.SubscribeWithFilter("A", "alerts.>", func(m Message) bool {
+		return strings.HasPrefix(m.Content, "CRITICAL")
+	})
+	if err != nil {
+		t.Fatalf("SubscribeWithFilter failed: %v", err)
+	}
+
+	broker.Publish("alerts.db", Message{Sender: "ops", Content: "info: vacuum done"})
+	broker.Publish("alerts.db.replica", Message{Sender: "ops", Content: "CRITICAL: replica lag"})
+	expectMessage(t, a, "CRITICAL: replica lag")
+	expectNoMessage(t, a)
+}
+
+func TestBrokerTopicErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	if err := broker.Subscribe("ghost", "team.*"); err != ErrUserNotRegistered {
+		t.Errorf("Expected ErrUserNotRegistered, got %v", err)
+	}
+
+	a := newTestUser("A")
+	broker.RegisterUser(a.ID, a.Recv)
+	if err := broker.Subscribe("A", "team.>.x"); err != ErrInvalidTopic {
+		t.Errorf("Expected ErrInvalidTopic, got %v", err)
+	}
+	if err := broker.Publish("team.*", Message{Content: "x"}); err != ErrInvalidTopic {
+		t.Errorf("Expected ErrInvalidTopic for wildcard publish, got %v", err)
+	}
+
+	// Unregistering drops subscriptions
+	broker.Subscribe("A", "team.*")
+	broker.UnregisterUser("A")
+	if got := broker.Subscriptions("A"); len(got) != 0 {
+		t.Errorf("Expected no subscriptions after unregister, got %v", got)
+	}
+}
+
+func TestBrokerTopicChurn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	stop := make(chan struct{})
+	var publishers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		publishers.Add(1)
+		go func(i int) {
+			defer publishers.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				broker.Publish(fmt.Sprintf("team.t%d", j%3), Message{Sender: "pub", Content: "x"})
+				broker.SendMessage(Message{Sender: "pub", Content: "all", Broadcast: true})
+			}
+		}(i)
+	}
+
+	var users sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		users.Add(1)
+		go func(i int) {
+			defer users.Done()
+			id := fmt.Sprintf("user%d", i)
+			recv := make(chan Message, 1000)
+
+			broker.RegisterUser(id, recv)
+			if err := broker.Subscribe(id, "team.*"); err != nil {
+				t.Errorf("Subscribe failed: %v", err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+			broker.UnregisterUser(id)
+
+			// Nothing may be written after UnregisterUser returns
+			queued := len(recv)
+			time.Sleep(5 * time.Millisecond)
+			if len(recv) != queued {
+				t.Errorf("%s received %d messages after unregistering", id, len(recv)-queued)
+			}
+			for len(recv) > 0 {
+				if msg := <-recv; msg.Topic != "" && !strings.HasPrefix(msg.Topic, "team.") {
+					t.Errorf("%s received message for unexpected topic %q", id, msg.Topic)
+				}
+			}
+		}(i)
+	}
+
+	users.Wait()
+	close(stop)
+	publishers.Wait()
+}
@@ -0,0 +1,89 @@
+package message
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// invertedIndex maps lowercase tokens to the IDs of messages containing them
+type invertedIndex struct {
+	postings map[string]map[uint64]struct{}
+}
+
+func newInvertedIndex() *invertedIndex {
+	return &invertedIndex{postings: make(map[string]map[uint64]struct{})}
+}
+
+// tokenize splits text into unique lowercase words of letters and digits
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(fields))
+	tokens := fields[:0]
+	for _, field := range fields {
+		if !seen[field] {
+			seen[field] = true
+			tokens = append(tokens, field)
+		}
+	}
+	return tokens
+}
+
+func (idx *invertedIndex) add(id uint64, content string) {
+	for _, token := range tokenize(content) {
+		ids := idx.postings[token]
+		if ids == nil {
+			ids = make(map[uint64]struct{})
+			idx.postings[token] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+func (idx *invertedIndex) remove(id uint64, content string) {
+	for _, token := range tokenize(content) {
+		ids := idx.postings[token]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(idx.postings, token)
+		}
+	}
+}
+
+// search returns, in ascending order, the IDs of messages containing every
+// token of query. A query without tokens matches nothing.
+func (idx *invertedIndex) search(query string) []uint64 {
+	tokens := tokenize(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	// Intersect starting from the rarest token
+	sets := make([]map[uint64]struct{}, len(tokens))
+	for i, token := range tokens {
+		sets[i] = idx.postings[token]
+		if len(sets[i]) == 0 {
+			return nil
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+
+	var result []uint64
+	for id := range sets[0] {
+		found := true
+		for _, set := range sets[1:] {
+			if _, ok := set[id]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			result = append(result, id)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
@@ -0,0 +1,553 @@
+package message
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStoreClosed is returned when adding to a closed LogStore
+	ErrStoreClosed = errors.New("store closed")
+	// ErrCorruptLog is returned when a sealed segment fails its checksum
+	ErrCorruptLog = errors.New("corrupt message log")
+)
+
+// SyncPolicy controls when appended messages are flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every message; nothing acknowledged is lost
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs in the background every LogConfig.SyncInterval;
+	// a crash loses at most that much
+	SyncInterval
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// LogConfig holds settings for a LogStore
+// Zero values fall back to DefaultLogConfig
+
+type LogConfig struct {
+	Dir          string
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	SegmentSize  int64     // Active segment is rotated once it reaches this many bytes
+	Retention    Retention // Applied to the in-memory view; Compact drops evicted messages from disk
+}
+
+// DefaultLogConfig returns the settings used for zero LogConfig fields
+func DefaultLogConfig(dir string) LogConfig {
+	return LogConfig{
+		Dir:          dir,
+		Sync:         SyncInterval,
+		SyncInterval: 100 * time.Millisecond,
+		SegmentSize:  16 << 20,
+	}
+}
+
+// Record framing: 4-byte payload length, 4-byte CRC-32 of the payload, JSON payload
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 20
+	segmentSuffix    = ".log"
+	tempSuffix       = ".tmp"
+)
+
+// errTornRecord marks a record that was only partially written
+var errTornRecord = errors.New("torn record")
+
+// logRecord is one entry of the log: a message, or a compaction marker
+// (ID 0) carrying the highest message ID assigned before compaction
+type logRecord struct {
+	Message
+	HighWater uint64 `json:",omitempty"`
+}
+
+// segmentFile is the part of *os.File used for the active segment
+type segmentFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// LogStore is a Store persisted as an append-only log of segment files.
+// Messages are kept in memory for queries and replayed from the log on open.
+// A segment starting with a compaction marker replaces every older segment.
+
+type LogStore struct {
+	config     LogConfig
+	memory     *MessageStore
+	mutex      sync.Mutex // Serializes appends, rotation, compaction and sync
+	active     segmentFile
+	activeSeq  uint64
+	activeSize int64
+	nextID     uint64
+	dirty      bool  // Appended since the last fsync
+	failed     error // Set when a failed append could not be undone
+	closed     bool
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// OpenLogStore opens or creates the log in config.Dir, recovering from any
+// partially written record at the end of the newest segment
+func OpenLogStore(config LogConfig) (*LogStore, error) {
+	defaults := DefaultLogConfig(config.Dir)
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = defaults.SyncInterval
+	}
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = defaults.SegmentSize
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &LogStore{
+		config: config,
+		memory: NewMessageStoreWithRetention(config.Retention),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	if config.Sync == SyncInterval {
+		go s.syncLoop()
+	} else {
+		close(s.done)
+	}
+	return s, nil
+}
+
+// segmentPath returns the file name of segment seq
+func (s *LogStore) segmentPath(seq uint64) string {
+	return filepath.Join(s.config.Dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+// segments lists segment sequence numbers in ascending order, removing
+// leftovers of interrupted compactions
+func (s *LogStore) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(s.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, tempSuffix) {
+			os.Remove(filepath.Join(s.config.Dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// recover replays the log into memory and opens the newest segment for appending
+func (s *LogStore) recover() error {
+	seqs, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	// Only the newest compacted segment and those after it are live
+	start := 0
+	for i := len(seqs) - 1; i > 0; i-- {
+		compacted, err := s.isCompacted(seqs[i])
+		if err != nil {
+			return err
+		}
+		if compacted {
+			start = i
+			break
+		}
+	}
+	for _, seq := range seqs[:start] {
+		if err := os.Remove(s.segmentPath(seq)); err != nil {
+			return err
+		}
+	}
+	seqs = seqs[start:]
+
+	for i, seq := range seqs {
+		if err := s.replay(seq, i == len(seqs)-1); err != nil {
+			return err
+		}
+	}
+
+	if len(seqs) == 0 {
+		return s.openSegment(1)
+	}
+	return s.openSegment(seqs[len(seqs)-1])
+}
+
+// isCompacted reports whether a segment starts with a compaction marker
+func (s *LogStore) isCompacted(seq uint64) (bool, error) {
+	f, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	record, _, err := readRecord(bufio.NewReader(f))
+	if err != nil {
+		return false, nil
+	}
+	return record.ID == 0, nil
+}
+
+// replay loads a segment into memory. A torn or corrupt record ends the
+// newest segment, which is truncated there; anywhere else it is an error.
+func (s *LogStore) replay(seq uint64, newest bool) error {
+	path := s.segmentPath(seq)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		record, n, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if !newest {
+				return fmt.Errorf("%w: %s at offset %d: %v", ErrCorruptLog, filepath.Base(path), offset, err)
+			}
+			return os.Truncate(path, offset)
+		}
+		offset += n
+
+		if record.ID == 0 {
+			s.nextID = max(s.nextID, record.HighWater)
+			continue
+		}
+		s.nextID = max(s.nextID, record.ID)
+		s.memory.restore(record.Message)
+	}
+}
+
+// readRecord decodes one framed record, returning its size on disk
+func readRecord(r io.Reader) (logRecord, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return logRecord{}, 0, errTornRecord
+		}
+		return logRecord{}, 0, err
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	if size > maxRecordSize {
+		return logRecord{}, 0, errTornRecord
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return logRecord{}, 0, errTornRecord
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return logRecord{}, 0, errTornRecord
+	}
+
+	var record logRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return logRecord{}, 0, err
+	}
+	return record, int64(recordHeaderSize + size), nil
+}
+
+// encodeRecord frames a record for appending
+func encodeRecord(record logRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	framed := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(framed[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(framed[4:8], crc32.ChecksumIEEE(payload))
+	copy(framed[recordHeaderSize:], payload)
+	return framed, nil
+}
+
+// openSegment makes seq the active segment, creating it if needed
+func (s *LogStore) openSegment(seq uint64) error {
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.active = f
+	s.activeSeq = seq
+	s.activeSize = info.Size()
+	return nil
+}
+
+// rotate seals the active segment and starts the next one. Caller must hold mutex.
+func (s *LogStore) rotate() error {
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	s.dirty = false
+	return s.openSegment(s.activeSeq + 1)
+}
+
+// AddMessage appends a message to the log, then makes it visible to queries
+func (s *LogStore) AddMessage(msg Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+	if s.failed != nil {
+		return s.failed
+	}
+
+	msg.ID = s.nextID + 1
+	record, err := encodeRecord(logRecord{Message: msg})
+	if err != nil {
+		return err
+	}
+	if len(record) > recordHeaderSize+maxRecordSize {
+		return fmt.Errorf("message too large to log: %d bytes", len(record))
+	}
+
+	if s.activeSize > 0 && s.activeSize+int64(len(record)) > s.config.SegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.active.Write(record); err != nil {
+		s.discard(msg.ID)
+		return err
+	}
+	if s.config.Sync == SyncAlways {
+		if err := s.active.Sync(); err != nil {
+			s.discard(msg.ID)
+			return err
+		}
+	} else {
+		s.dirty = true
+	}
+	s.activeSize += int64(len(record))
+
+	s.nextID = msg.ID
+	s.memory.restore(msg)
+	return nil
+}
+
+// discard drops a record that failed to append from the end of the active
+// segment. If that fails too, the record may come back when the log is
+// replayed, so its ID is never handed out again and the store refuses
+// further appends. Caller must hold mutex.
+func (s *LogStore) discard(id uint64) {
+	if err := s.active.Truncate(s.activeSize); err != nil {
+		s.nextID = id
+		s.failed = fmt.Errorf("message log needs reopening after a failed append: %w", err)
+	}
+}
+
+// syncLoop flushes the active segment every SyncInterval
+func (s *LogStore) syncLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.Sync()
+		}
+	}
+}
+
+// Sync flushes appended messages to stable storage
+func (s *LogStore) Sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed || !s.dirty {
+		return nil
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Compact rewrites the log to hold only the messages currently retained in
+// memory, removing evicted messages and merging segments
+func (s *LogStore) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	live, err := s.memory.GetMessages("")
+	if err != nil {
+		return err
+	}
+
+	seq := s.activeSeq + 1
+	path := s.segmentPath(seq)
+	tmp, err := os.Create(path + tempSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	records := make([]logRecord, 0, len(live)+1)
+	records = append(records, logRecord{HighWater: s.nextID})
+	for _, msg := range live {
+		records = append(records, logRecord{Message: msg})
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, r := range records {
+		record, err := encodeRecord(r)
+		if err == nil {
+			_, err = writer.Write(record)
+		}
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// The rename makes the compacted segment authoritative; older segments
+	// are ignored from here on even if removing them fails
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if err := syncDir(s.config.Dir); err != nil {
+		return err
+	}
+
+	s.active.Close()
+	s.dirty = false
+	if err := s.openSegment(seq); err != nil {
+		s.closed = true
+		return err
+	}
+
+	seqs, err := s.segments()
+	if err != nil {
+		return err
+	}
+	for _, old := range seqs {
+		if old < seq {
+			os.Remove(s.segmentPath(old))
+		}
+	}
+	return nil
+}
+
+// syncDir makes renames and removals in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close flushes and closes the log. The store cannot be used afterwards.
+func (s *LogStore) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.active.Sync()
+	if closeErr := s.active.Close(); err == nil {
+		err = closeErr
+	}
+	s.mutex.Unlock()
+
+	if s.config.Sync == SyncInterval {
+		close(s.stop)
+	}
+	<-s.done
+	return err
+}
+
+// GetMessages retrieves messages (all, or those sent by user)
+func (s *LogStore) GetMessages(user string) ([]Message, error) {
+	return s.memory.GetMessages(user)
+}
+
+// Query returns one page of messages matching q
+func (s *LogStore) Query(q Query) (Page, error) {
+	return s.memory.Query(q)
+}
+
+// Len returns the number of retained messages
+func (s *LogStore) Len() int {
+	return s.memory.Len()
+}
+
+// Segments returns the number of segment files on disk
+func (s *LogStore) Segments() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	seqs, err := s.segments()
+	return len(seqs), err
+}
@@ -0,0 +1,362 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openTestLog(t testing.TB, config LogConfig) *LogStore {
+	t.Helper()
+	store, err := OpenLogStore(config)
+	if err != nil {
+		t.Fatalf("OpenLogStore failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func addMessages(t testing.TB, store Store, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := store.AddMessage(Message{Sender: "alice", Content: fmt.Sprintf("message %d", i), Timestamp: int64(i)}); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+}
+
+// segmentFiles lists the segment files in dir in order
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestStoreImplementations(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMessageStore() },
+		"log": func(t *testing.T) Store {
+			return openTestLog(t, LogConfig{Dir: t.TempDir(), Sync: SyncNever})
+		},
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			store.AddMessage(Message{Sender: "alice", Recipient: "bob", Content: "hello bob"})
+			store.AddMessage(Message{Sender: "bob", Content: "hello everyone"})
+
+			if store.Len() != 2 {
+				t.Errorf("Expected 2 messages, got %d", store.Len())
+			}
+			msgs, _ := store.GetMessages("bob")
+			if len(msgs) != 1 || msgs[0].ID != 2 {
+				t.Errorf("Unexpected messages from bob: %+v", msgs)
+			}
+			page, _ := store.Query(Query{Search: "hello", Recipient: "bob"})
+			if len(page.Messages) != 1 || page.Messages[0].Content != "hello bob" {
+				t.Errorf("Unexpected query result: %+v", page.Messages)
+			}
+			if err := store.Close(); err != nil {
+				t.Errorf("Close failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestLogStore_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestLog(t, LogConfig{Dir: dir, Sync: SyncAlways})
+	addMessages(t, store, 5)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := store.AddMessage(Message{Content: "late"}); err != ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed, got %v", err)
+	}
+
+	store = openTestLog(t, LogConfig{Dir: dir, Sync: SyncAlways})
+	before, _ := store.GetMessages("")
+	if len(before) != 5 || before[4].Content != "message 4" {
+		t.Fatalf("Unexpected messages after reopen: %+v", before)
+	}
+
+	// IDs continue where the log left off
+	store.AddMessage(Message{Sender: "bob", Content: "after reopen"})
+	msgs, _ := store.GetMessages("bob")
+	if len(msgs) != 1 || msgs[0].ID != 6 {
+		t.Errorf("Expected ID 6, got %+v", msgs)
+	}
+}
+
+func TestLogStore_TruncatesTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestLog(t, LogConfig{Dir: dir, Sync: SyncAlways})
+	addMessages(t, store, 3)
+	store.Close()
+
+	// Simulate a crash halfway through appending a record
+	files := segmentFiles(t, dir)
+	path := files[len(files)-1]
+	info, _ := os.Stat(path)
+	record, _ := encodeRecord(logRecord{Message: Message{ID: 4, Content: "never finished"}})
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write(record[:len(record)/2])
+	f.Close()
+
+	store = openTestLog(t, LogConfig{Dir: dir, Sync: SyncAlways})
+	if store.Len() != 3 {
+		t.Fatalf("Expected 3 intact messages, got %d", store.Len())
+	}
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Errorf("Expected torn record to be truncated to %d bytes, file has %d", info.Size(), after.Size())
+	}
+
+	store.AddMessage(Message{Content: "after recovery"})
+	store.Close()
+	store = openTestLog(t, LogConfig{Dir: dir, Sync: SyncAlways})
+	msgs, _ := store.GetMessages("")
+	if len(msgs) != 4 || msgs[3].Content != "after recovery" || msgs[3].ID != 4 {
+		t.Errorf("Unexpected messages after second reopen: %+v", msgs)
+	}
+}
+
+func TestLogStore_ChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestLog(t, LogConfig{Dir: dir, Sync: SyncAlways, SegmentSize: 100})
+	addMessages(t, store, 4)
+	store.Close()
+
+	files := segmentFiles(t, dir)
+	if len(files) < 2 {
+		t.Fatalf("Expected several segments, got %d", len(files))
+	}
+
+	// Damage in the newest segment is treated as a torn write
+	flipLastByte(t, files[len(files)-1])
+	store = openTestLog(t, LogConfig{Dir: dir, Sync: SyncAlways, SegmentSize: 100})
+	if store.Len() != 3 {
+		t.Errorf("Expected the damaged record to be dropped, have %d messages", store.Len())
+	}
+	store.Close()
+
+	// Damage in a sealed segment is reported
+	flipLastByte(t, files[0])
+	if _, err := OpenLogStore(LogConfig{Dir: dir}); !errors.Is(err, ErrCorruptLog) {
+		t.Errorf("Expected ErrCorruptLog, got %v", err)
+	}
+}
+
+func flipLastByte(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogStore_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestLog(t, LogConfig{Dir: dir, Sync: SyncNever, SegmentSize: 512})
+	addMessages(t, store, 50)
+
+	segments, err := store.Segments()
+	if err != nil || segments < 5 {
+		t.Errorf("Expected rotation into several segments, got %d (%v)", segments, err)
+	}
+	for _, path := range segmentFiles(t, dir)[:segments-1] {
+		if info, _ := os.Stat(path); info.Size() > 512 {
+			t.Errorf("Segment %s exceeds the size limit: %d bytes", filepath.Base(path), info.Size())
+		}
+	}
+	store.Close()
+
+	store = openTestLog(t, LogConfig{Dir: dir, Sync: SyncNever, SegmentSize: 512})
+	if store.Len() != 50 {
+		t.Errorf("Expected 50 messages across segments, got %d", store.Len())
+	}
+}
+
+func TestLogStore_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	config := LogConfig{Dir: dir, Sync: SyncNever, SegmentSize: 256, Retention: Retention{MaxCount: 3}}
+	store := openTestLog(t, config)
+	addMessages(t, store, 20)
+
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if segments, _ := store.Segments(); segments != 1 {
+		t.Errorf("Expected a single segment after compaction, got %d", segments)
+	}
+	store.AddMessage(Message{Content: "after compaction"})
+	store.Close()
+
+	store = openTestLog(t, config)
+	msgs, _ := store.GetMessages("")
+	want := []string{"message 18", "message 19", "after compaction"}
+	if got := contents(msgs); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q after reopen, want %q", got, want)
+	}
+	if msgs[2].ID != 21 {
+		t.Errorf("Expected IDs to survive compaction, got %d", msgs[2].ID)
+	}
+
+	// Everything evicted: the marker still remembers the last ID
+	store.Close()
+	config.Retention = Retention{MaxCount: 1}
+	store = openTestLog(t, config)
+	store.Compact()
+	store.Close()
+	store = openTestLog(t, config)
+	store.AddMessage(Message{Content: "next"})
+	if msgs, _ := store.GetMessages(""); msgs[len(msgs)-1].ID != 22 {
+		t.Errorf("Expected ID 22, got %d", msgs[len(msgs)-1].ID)
+	}
+}
+
+func TestLogStore_InterruptedCompaction(t *testing.T) {
+	dir := t.TempDir()
+	config := LogConfig{Dir: dir, Sync: SyncNever, Retention: Retention{MaxCount: 2}}
+	store := openTestLog(t, config)
+	addMessages(t, store, 5)
+	old := segmentFiles(t, dir)[0]
+	oldData, _ := os.ReadFile(old)
+	store.Compact()
+	store.Close()
+
+	// Crash after the rename but before old segments were removed, with a
+	// stray temp file from another attempt
+	os.WriteFile(old, oldData, 0o644)
+	os.WriteFile(filepath.Join(dir, "00000000000000000009.log.tmp"), []byte("junk"), 0o644)
+
+	store = openTestLog(t, config)
+	msgs, _ := store.GetMessages("")
+	if got := contents(msgs); !reflect.DeepEqual(got, []string{"message 3", "message 4"}) {
+		t.Errorf("Got %q, want only the compacted messages", got)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected stale files to be removed, have %d files", len(files))
+	}
+}
+
+func TestLogStore_SyncInterval(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestLog(t, LogConfig{Dir: dir, Sync: SyncInterval})
+	addMessages(t, store, 10)
+	if err := store.Sync(); err != nil {
+		t.Errorf("Sync failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if store := openTestLog(t, LogConfig{Dir: dir}); store.Len() != 10 {
+		t.Errorf("Expected 10 messages, got %d", store.Len())
+	}
+}
+
+// failingSegment makes the active segment's Sync or Truncate fail
+type failingSegment struct {
+	segmentFile
+	syncErr, truncateErr error
+}
+
+func (f *failingSegment) Sync() error {
+	if f.syncErr != nil {
+		return f.syncErr
+	}
+	return f.segmentFile.Sync()
+}
+
+func (f *failingSegment) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	return f.segmentFile.Truncate(size)
+}
+
+func TestLogStore_FailedSync(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestLog(t, LogConfig{Dir: dir, Sync: SyncAlways})
+	addMessages(t, store, 2)
+
+	// The record is truncated away, so its ID is reused
+	segment := &failingSegment{segmentFile: store.active, syncErr: errors.New("disk gone")}
+	store.active = segment
+	if err := store.AddMessage(Message{Sender: "bob", Content: "lost"}); err == nil {
+		t.Fatal("Expected the failed sync to be reported")
+	}
+	segment.syncErr = nil
+	addMessages(t, store, 1)
+
+	// A record that cannot be truncated burns its ID and stops appends
+	segment.syncErr = errors.New("disk gone")
+	segment.truncateErr = errors.New("still gone")
+	store.AddMessage(Message{Sender: "bob", Content: "stuck"})
+	segment.syncErr, segment.truncateErr = nil, nil
+	if err := store.AddMessage(Message{Sender: "bob", Content: "refused"}); err == nil {
+		t.Error("Expected appends to fail after an append could not be undone")
+	}
+	store.Close()
+
+	store = openTestLog(t, LogConfig{Dir: dir, Sync: SyncAlways})
+	addMessages(t, store, 1)
+	msgs, _ := store.GetMessages("")
+	var ids []uint64
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	// ID 4 is the record that could not be truncated
+	if want := []uint64{1, 2, 3, 4, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Expected IDs %v, got %v", want, ids)
+	}
+}
+
+func BenchmarkAddMessage(b *testing.B) {
+	stores := []struct {
+		name string
+		open func(b *testing.B) Store
+	}{
+		{"memory", func(b *testing.B) Store { return NewMessageStore() }},
+		{"log-never", func(b *testing.B) Store { return openTestLog(b, LogConfig{Dir: b.TempDir(), Sync: SyncNever}) }},
+		{"log-interval", func(b *testing.B) Store { return openTestLog(b, LogConfig{Dir: b.TempDir(), Sync: SyncInterval}) }},
+		{"log-always", func(b *testing.B) Store { return openTestLog(b, LogConfig{Dir: b.TempDir(), Sync: SyncAlways}) }},
+	}
+
+	msg := Message{Sender: "alice", Recipient: "bob", Content: "the quick brown fox jumps over the lazy dog"}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			store := s.open(b)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				store.AddMessage(msg)
+			}
+		})
+	}
+}
+
+func BenchmarkOpenLogStore(b *testing.B) {
+	dir := b.TempDir()
+	store := openTestLog(b, LogConfig{Dir: dir, Sync: SyncNever})
+	addMessages(b, store, 10_000)
+	store.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store, err := OpenLogStore(LogConfig{Dir: dir, Sync: SyncNever})
+		if err != nil {
+			b.Fatal(err)
+		}
+		store.Close()
+	}
+}
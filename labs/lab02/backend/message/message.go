@@ -1,44 +1,171 @@
 package message
 
 import (
-	"errors"
+	"sort"
 	"sync"
+	"time"
 )
 
 // Message represents a chat message
-// TODO: Add more fields if needed
+// ID is assigned by the store, Timestamp is in Unix seconds
+// Recipient is empty for messages to everyone
 
 type Message struct {
+	ID        uint64
 	Sender    string
+	Recipient string
 	Content   string
 	Timestamp int64
 }
 
+// Retention limits how many messages a store keeps
+// Zero values disable the corresponding limit
+
+type Retention struct {
+	MaxAge   time.Duration // Evict messages whose Timestamp is older than this
+	MaxCount int           // Evict the oldest messages beyond this count
+}
+
+// ageSweepInterval bounds how often AddMessage scans for expired messages
+const ageSweepInterval = time.Second
+
 // MessageStore stores chat messages
-// Contains a slice of messages and a mutex for concurrency
+// Contains messages by ID, IDs in insertion order, a full-text index and a mutex for concurrency
 
 type MessageStore struct {
-	messages []Message
-	mutex    sync.RWMutex
-	// TODO: Add more fields if needed
+	messages  map[uint64]Message
+	order     []uint64 // Ascending IDs of stored messages
+	index     *invertedIndex
+	nextID    uint64
+	retention Retention
+	lastSweep time.Time
+	now       func() time.Time
+	mutex     sync.RWMutex
 }
 
-// NewMessageStore creates a new MessageStore
+// NewMessageStore creates a new MessageStore that keeps every message
 func NewMessageStore() *MessageStore {
-	// TODO: Initialize MessageStore fields
+	return NewMessageStoreWithRetention(Retention{})
+}
+
+// NewMessageStoreWithRetention creates a MessageStore that evicts messages
+// according to retention as new ones are added
+func NewMessageStoreWithRetention(retention Retention) *MessageStore {
 	return &MessageStore{
-		messages: make([]Message, 0, 100),
+		messages:  make(map[uint64]Message),
+		order:     make([]uint64, 0, 100),
+		index:     newInvertedIndex(),
+		retention: retention,
+		now:       time.Now,
 	}
 }
 
-// AddMessage stores a new message
+// AddMessage stores a new message, assigning its ID
 func (s *MessageStore) AddMessage(msg Message) error {
-	// TODO: Add message to storage (concurrent safe)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	msg.ID = s.nextID + 1
+	s.insert(msg)
 	return nil
 }
 
-// GetMessages retrieves messages (optionally by user)
+// restore adds a message that already has an ID, as when replaying a log.
+// IDs must arrive in ascending order.
+func (s *MessageStore) restore(msg Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.insert(msg)
+}
+
+// insert stores msg under its ID and applies retention. Caller must hold the write lock.
+func (s *MessageStore) insert(msg Message) {
+	s.nextID = max(s.nextID, msg.ID)
+	s.messages[msg.ID] = msg
+	s.order = append(s.order, msg.ID)
+	s.index.add(msg.ID, msg.Content)
+	s.enforceRetention(false)
+}
+
+// GetMessages retrieves messages (all, or those sent by user) in the order
+// they were added
 func (s *MessageStore) GetMessages(user string) ([]Message, error) {
-	// TODO: Retrieve messages (all or by user)
-	return nil, errors.New("not implemented")
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]Message, 0, len(s.order))
+	for _, id := range s.order {
+		msg := s.messages[id]
+		if user == "" || msg.Sender == user {
+			result = append(result, msg)
+		}
+	}
+	return result, nil
+}
+
+// Close does nothing; the in-memory store holds no resources
+func (s *MessageStore) Close() error {
+	return nil
+}
+
+// Len returns the number of stored messages
+func (s *MessageStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.order)
+}
+
+// EnforceRetention evicts every message outside the retention policy and
+// returns how many were removed. AddMessage does this automatically, but
+// checks age at most once per second.
+func (s *MessageStore) EnforceRetention() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.enforceRetention(true)
+}
+
+// enforceRetention evicts by count, and by age when forced or when the last
+// age sweep is old enough. Caller must hold the write lock.
+func (s *MessageStore) enforceRetention(force bool) int {
+	evicted := 0
+
+	if max := s.retention.MaxCount; max > 0 && len(s.order) > max {
+		excess := len(s.order) - max
+		for _, id := range s.order[:excess] {
+			s.remove(id)
+		}
+		s.order = append(s.order[:0:0], s.order[excess:]...)
+		evicted += excess
+	}
+
+	if s.retention.MaxAge > 0 {
+		now := s.now()
+		if force || now.Sub(s.lastSweep) >= ageSweepInterval {
+			s.lastSweep = now
+			cutoff := now.Add(-s.retention.MaxAge).Unix()
+			kept := s.order[:0]
+			for _, id := range s.order {
+				if s.messages[id].Timestamp < cutoff {
+					s.remove(id)
+					evicted++
+				} else {
+					kept = append(kept, id)
+				}
+			}
+			s.order = kept
+		}
+	}
+
+	return evicted
+}
+
+// remove deletes a message and its index entries, leaving order to the caller
+func (s *MessageStore) remove(id uint64) {
+	s.index.remove(id, s.messages[id].Content)
+	delete(s.messages, id)
+}
+
+// position returns the index in order of the first ID greater than id
+func (s *MessageStore) position(id uint64) int {
+	return sort.Search(len(s.order), func(i int) bool { return s.order[i] > id })
 }
@@ -0,0 +1,124 @@
+package message
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for cursors not produced by Query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page size limits for Query
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+)
+
+// Query selects messages from a MessageStore
+// Empty fields do not filter. Since is inclusive, Until is exclusive; both are Unix seconds.
+// Contains is a case-insensitive substring match, Search requires every word to appear.
+
+type Query struct {
+	Sender    string
+	Recipient string
+	Since     int64
+	Until     int64
+	Contains  string
+	Search    string
+	Cursor    string // NextCursor of the previous page
+	Limit     int    // Page size, DefaultPageSize when zero
+}
+
+// Page is one page of query results in insertion order
+// NextCursor is empty on the last page
+
+type Page struct {
+	Messages   []Message
+	NextCursor string
+}
+
+// encodeCursor makes an opaque cursor resuming after id
+func encodeCursor(id uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(id, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
+
+// matches applies every filter except Search, which is answered by the index
+func (q *Query) matches(msg Message, contains string) bool {
+	switch {
+	case q.Sender != "" && msg.Sender != q.Sender:
+		return false
+	case q.Recipient != "" && msg.Recipient != q.Recipient:
+		return false
+	case q.Since != 0 && msg.Timestamp < q.Since:
+		return false
+	case q.Until != 0 && msg.Timestamp >= q.Until:
+		return false
+	case contains != "" && !strings.Contains(strings.ToLower(msg.Content), contains):
+		return false
+	}
+	return true
+}
+
+// Query returns one page of messages matching q
+func (s *MessageStore) Query(q Query) (Page, error) {
+	var after uint64
+	if q.Cursor != "" {
+		id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		after = id
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	contains := strings.ToLower(q.Contains)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Candidates are ascending IDs after the cursor
+	var candidates []uint64
+	if q.Search != "" {
+		candidates = s.index.search(q.Search)
+		start := sort.Search(len(candidates), func(i int) bool { return candidates[i] > after })
+		candidates = candidates[start:]
+	} else {
+		candidates = s.order[s.position(after):]
+	}
+
+	page := Page{Messages: make([]Message, 0, min(limit, len(candidates)))}
+	for _, id := range candidates {
+		msg := s.messages[id]
+		if !q.matches(msg, contains) {
+			continue
+		}
+		if len(page.Messages) == limit {
+			// There is at least one more match
+			page.NextCursor = encodeCursor(page.Messages[limit-1].ID)
+			break
+		}
+		page.Messages = append(page.Messages, msg)
+	}
+	return page, nil
+}
@@ -0,0 +1,181 @@
+package message
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func contents(msgs []Message) []string {
+	result := make([]string, len(msgs))
+	for i, msg := range msgs {
+		result[i] = msg.Content
+	}
+	return result
+}
+
+func newChatStore(t *testing.T) *MessageStore {
+	t.Helper()
+	store := NewMessageStore()
+	for _, msg := range []Message{
+		{Sender: "alice", Recipient: "bob", Content: "Lunch at noon?", Timestamp: 100},
+		{Sender: "bob", Recipient: "alice", Content: "Sure, noon works", Timestamp: 110},
+		{Sender: "carol", Content: "Deploy finished: all green", Timestamp: 120},
+		{Sender: "alice", Content: "Great deploy!", Timestamp: 130},
+		{Sender: "bob", Recipient: "carol", Content: "Can you review my PR?", Timestamp: 140},
+	} {
+		if err := store.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+	return store
+}
+
+func TestQueryFilters(t *testing.T) {
+	store := newChatStore(t)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all", Query{}, []string{"Lunch at noon?", "Sure, noon works", "Deploy finished: all green", "Great deploy!", "Can you review my PR?"}},
+		{"sender", Query{Sender: "alice"}, []string{"Lunch at noon?", "Great deploy!"}},
+		{"recipient", Query{Recipient: "carol"}, []string{"Can you review my PR?"}},
+		{"time range", Query{Since: 110, Until: 130}, []string{"Sure, noon works", "Deploy finished: all green"}},
+		{"substring", Query{Contains: "NOON"}, []string{"Lunch at noon?", "Sure, noon works"}},
+		{"substring inside word", Query{Contains: "eplo"}, []string{"Deploy finished: all green", "Great deploy!"}},
+		{"search", Query{Search: "deploy"}, []string{"Deploy finished: all green", "Great deploy!"}},
+		{"search all words", Query{Search: "deploy green"}, []string{"Deploy finished: all green"}},
+		{"search whole words only", Query{Search: "eplo"}, []string{}},
+		{"search and sender", Query{Search: "noon", Sender: "bob"}, []string{"Sure, noon works"}},
+		{"search punctuation only", Query{Search: "?!"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := store.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if got := contents(page.Messages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Got %q, want %q", got, tt.want)
+			}
+			if page.NextCursor != "" {
+				t.Errorf("Expected no next cursor, got %q", page.NextCursor)
+			}
+		})
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	store := NewMessageStore()
+	for i := 0; i < 25; i++ {
+		sender := "alice"
+		if i%2 == 1 {
+			sender = "bob"
+		}
+		store.AddMessage(Message{Sender: sender, Content: fmt.Sprintf("message %d", i), Timestamp: int64(i)})
+	}
+
+	var got []string
+	query := Query{Sender: "alice", Limit: 5}
+	pages := 0
+	for {
+		page, err := store.Query(query)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		pages++
+		got = append(got, contents(page.Messages)...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	// 13 messages from alice: pages of 5, 5 and 3
+	if pages != 3 || len(got) != 13 || got[0] != "message 0" || got[12] != "message 24" {
+		t.Errorf("Unexpected pagination: %d pages, %q", pages, got)
+	}
+
+	// Exactly one full page leaves no cursor behind
+	page, _ := store.Query(Query{Sender: "bob", Limit: 12})
+	if len(page.Messages) != 12 || page.NextCursor != "" {
+		t.Errorf("Expected 12 messages and no cursor, got %d and %q", len(page.Messages), page.NextCursor)
+	}
+
+	if _, err := store.Query(Query{Cursor: "not a cursor!"}); err != ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestQueryPaginationWithSearch(t *testing.T) {
+	store := NewMessageStore()
+	for i := 0; i < 10; i++ {
+		store.AddMessage(Message{Sender: "alice", Content: fmt.Sprintf("build %d passed", i)})
+		store.AddMessage(Message{Sender: "alice", Content: "unrelated"})
+	}
+
+	page, _ := store.Query(Query{Search: "build passed", Limit: 4})
+	next, _ := store.Query(Query{Search: "build passed", Limit: 4, Cursor: page.NextCursor})
+	if got := contents(next.Messages); !reflect.DeepEqual(got, []string{"build 4 passed", "build 5 passed", "build 6 passed", "build 7 passed"}) {
+		t.Errorf("Unexpected second page %q", got)
+	}
+}
+
+func TestRetentionByCount(t *testing.T) {
+	store := NewMessageStoreWithRetention(Retention{MaxCount: 3})
+	for i := 0; i < 5; i++ {
+		store.AddMessage(Message{Sender: "alice", Content: fmt.Sprintf("word%d", i)})
+	}
+
+	msgs, _ := store.GetMessages("")
+	if got := contents(msgs); !reflect.DeepEqual(got, []string{"word2", "word3", "word4"}) {
+		t.Errorf("Expected the newest 3 messages, got %q", got)
+	}
+	// Evicted messages leave the index too
+	if page, _ := store.Query(Query{Search: "word0"}); len(page.Messages) != 0 {
+		t.Errorf("Evicted message still searchable: %+v", page.Messages)
+	}
+	if len(store.index.postings) != 3 {
+		t.Errorf("Expected 3 indexed tokens, got %d", len(store.index.postings))
+	}
+}
+
+func TestRetentionByAge(t *testing.T) {
+	now := time.Unix(10_000, 0)
+	store := NewMessageStoreWithRetention(Retention{MaxAge: time.Hour})
+	store.now = func() time.Time { return now }
+
+	store.AddMessage(Message{Sender: "alice", Content: "ancient", Timestamp: now.Add(-2 * time.Hour).Unix()})
+	store.AddMessage(Message{Sender: "alice", Content: "recent", Timestamp: now.Add(-time.Minute).Unix()})
+	if store.Len() != 1 {
+		t.Fatalf("Expected the expired message to be evicted on add, have %d", store.Len())
+	}
+
+	// Age checks on add are throttled; EnforceRetention always runs them
+	now = now.Add(500 * time.Millisecond)
+	store.AddMessage(Message{Sender: "alice", Content: "backfilled", Timestamp: now.Add(-3 * time.Hour).Unix()})
+	if store.Len() != 2 {
+		t.Fatalf("Expected throttled age check to keep both messages, have %d", store.Len())
+	}
+	if n := store.EnforceRetention(); n != 1 {
+		t.Errorf("Expected 1 eviction, got %d", n)
+	}
+	msgs, _ := store.GetMessages("")
+	if got := contents(msgs); !reflect.DeepEqual(got, []string{"recent"}) {
+		t.Errorf("Unexpected messages after retention: %q", got)
+	}
+}
+
+func TestAddMessageAssignsIDs(t *testing.T) {
+	store := newChatStore(t)
+	msgs, _ := store.GetMessages("")
+	for i, msg := range msgs {
+		if msg.ID != uint64(i+1) {
+			t.Errorf("Message %d has ID %d", i, msg.ID)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package message
+
+// Store is a queryable collection of chat messages
+// MessageStore keeps messages in memory, LogStore also persists them to disk
+
+type Store interface {
+	AddMessage(msg Message) error
+	GetMessages(user string) ([]Message, error)
+	Query(q Query) (Page, error)
+	Len() int
+	Close() error
+}
+
+var (
+	_ Store = (*MessageStore)(nil)
+	_ Store = (*LogStore)(nil)
+)
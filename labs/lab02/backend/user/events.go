@@ -0,0 +1,131 @@
+package user
+
+import "sync"
+
+// listener is one registered callback; exactly one field is set
+type listener struct {
+	id      int
+	added   func(User)
+	updated func(old, updated User)
+	removed func(User)
+}
+
+// listeners holds callbacks registered with OnUserAdded, OnUserUpdated and
+// OnUserRemoved. Changes queue their event while the manager's lock is
+// held, so the queue is in the order the changes happened, and events are
+// dispatched one at a time in that order. The goroutine that made a change
+// runs the queue after releasing the lock, unless another goroutine is
+// already running it; then that goroutine runs the new event too. Within
+// an event, callbacks run in registration order. Callbacks may call back
+// into the manager; events for changes they make run after them.
+type listeners struct {
+	mutex  sync.RWMutex
+	nextID int
+	all    []listener
+
+	queueMutex sync.Mutex
+	queue      []func([]listener)
+	draining   bool // A goroutine is running the queue
+}
+
+// register adds l and returns a function removing it
+func (ls *listeners) register(l listener) func() {
+	ls.mutex.Lock()
+	ls.nextID++
+	l.id = ls.nextID
+	ls.all = append(ls.all, l)
+	ls.mutex.Unlock()
+
+	return func() {
+		ls.mutex.Lock()
+		defer ls.mutex.Unlock()
+		for i, existing := range ls.all {
+			if existing.id == l.id {
+				ls.all = append(ls.all[:i:i], ls.all[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// snapshot copies the listeners so callbacks run without holding the lock
+func (ls *listeners) snapshot() []listener {
+	ls.mutex.RLock()
+	defer ls.mutex.RUnlock()
+	return append([]listener(nil), ls.all...)
+}
+
+// enqueue adds an event to the queue. Caller must hold the manager's lock.
+func (ls *listeners) enqueue(event func([]listener)) {
+	ls.queueMutex.Lock()
+	ls.queue = append(ls.queue, event)
+	ls.queueMutex.Unlock()
+}
+
+// drain dispatches queued events until the queue is empty, unless another
+// goroutine is already doing so
+func (ls *listeners) drain() {
+	ls.queueMutex.Lock()
+	if ls.draining {
+		ls.queueMutex.Unlock()
+		return
+	}
+	ls.draining = true
+	for len(ls.queue) > 0 {
+		event := ls.queue[0]
+		ls.queue = ls.queue[1:]
+		ls.queueMutex.Unlock()
+		event(ls.snapshot())
+		ls.queueMutex.Lock()
+	}
+	ls.draining = false
+	ls.queueMutex.Unlock()
+}
+
+func (ls *listeners) queueAdded(u User) {
+	ls.enqueue(func(all []listener) {
+		for _, l := range all {
+			if l.added != nil {
+				l.added(u)
+			}
+		}
+	})
+}
+
+func (ls *listeners) queueUpdated(old, updated User) {
+	ls.enqueue(func(all []listener) {
+		for _, l := range all {
+			if l.updated != nil {
+				l.updated(old, updated)
+			}
+		}
+	})
+}
+
+func (ls *listeners) queueRemoved(u User) {
+	ls.enqueue(func(all []listener) {
+		for _, l := range all {
+			if l.removed != nil {
+				l.removed(u)
+			}
+		}
+	})
+}
+
+// OnUserAdded registers fn to run after a user is added. The returned
+// function unregisters it.
+func (m *UserManager) OnUserAdded(fn func(User)) (unsubscribe func()) {
+	return m.listeners.register(listener{added: fn})
+}
+
+// OnUserUpdated registers fn to run after a user changes, including
+// deactivation and reactivation. The returned function unregisters it.
+func (m *UserManager) OnUserUpdated(fn func(old, updated User)) (unsubscribe func()) {
+	return m.listeners.register(listener{updated: fn})
+}
+
+// OnUserRemoved registers fn to run after a user is removed. The returned
+// function unregisters it.
+func (m *UserManager) OnUserRemoved(fn func(User)) (unsubscribe func()) {
+	return m.listeners.register(listener{removed: fn})
+}
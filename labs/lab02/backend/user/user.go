@@ -3,65 +3,321 @@ package user
 import (
 	"context"
 	"errors"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+)
+
+// Errors returned by Validate and UserManager
+var (
+	ErrNotFound        = errors.New("user not found")
+	ErrAlreadyExists   = errors.New("user already exists")
+	ErrVersionConflict = errors.New("user was modified concurrently")
+	ErrInvalidID       = errors.New("invalid id")
+	ErrInvalidName     = errors.New("invalid name")
+	ErrInvalidEmail    = errors.New("invalid email")
+	ErrInvalidAvatar   = errors.New("invalid avatar url")
+	ErrInvalidStatus   = errors.New("invalid status")
+	ErrInvalidTimezone = errors.New("invalid timezone")
+)
+
+// Status is a user's presence
+type Status string
+
+const (
+	StatusOnline  Status = "online"
+	StatusAway    Status = "away"
+	StatusBusy    Status = "busy"
+	StatusOffline Status = "offline"
 )
 
 // User represents a chat user
-// TODO: Add more fields if needed
+// Name, Email and ID are required; profile fields are optional
+// Version, CreatedAt, UpdatedAt and DeactivatedAt are maintained by UserManager
 
 type User struct {
 	Name  string
 	Email string
 	ID    string
+
+	DisplayName string
+	AvatarURL   string
+	Status      Status
+	Timezone    string // IANA name such as "Europe/Berlin"
+
+	Version       uint64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeactivatedAt time.Time // Zero while the user is active
+}
+
+// Active reports whether the user has not been deactivated
+func (u *User) Active() bool {
+	return u.DeactivatedAt.IsZero()
 }
 
 // Validate checks if the user data is valid
 func (u *User) Validate() error {
-	// TODO: Validate name, email, id
+	if strings.TrimSpace(u.ID) == "" {
+		return ErrInvalidID
+	}
+	if strings.TrimSpace(u.Name) == "" {
+		return ErrInvalidName
+	}
+	if addr, err := mail.ParseAddress(u.Email); err != nil || addr.Address != u.Email {
+		return ErrInvalidEmail
+	}
+	if u.AvatarURL != "" {
+		parsed, err := url.Parse(u.AvatarURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return ErrInvalidAvatar
+		}
+	}
+	switch u.Status {
+	case "", StatusOnline, StatusAway, StatusBusy, StatusOffline:
+	default:
+		return ErrInvalidStatus
+	}
+	if u.Timezone != "" {
+		if _, err := time.LoadLocation(u.Timezone); err != nil {
+			return ErrInvalidTimezone
+		}
+	}
 	return nil
 }
 
 // UserManager manages users
-// Contains a map of users, a mutex, and a context
+// Contains a map of users, a mutex, a context and event listeners
 
 type UserManager struct {
-	ctx   context.Context
-	users map[string]User // userID -> User
-	mutex sync.RWMutex    // Protects users map
-	// TODO: Add more fields if needed
+	ctx       context.Context
+	users     map[string]User // userID -> User
+	mutex     sync.RWMutex    // Protects users map
+	listeners listeners
+	now       func() time.Time
 }
 
 // NewUserManager creates a new UserManager
 func NewUserManager() *UserManager {
-	// TODO: Initialize UserManager fields
-	return &UserManager{
-		users: make(map[string]User),
-	}
+	return NewUserManagerWithContext(context.Background())
 }
 
-// NewUserManagerWithContext creates a new UserManager with context
+// NewUserManagerWithContext creates a new UserManager with context.
+// Once ctx is done every modifying call returns its error.
 func NewUserManagerWithContext(ctx context.Context) *UserManager {
-	// TODO: Initialize UserManager with context
 	return &UserManager{
 		ctx:   ctx,
 		users: make(map[string]User),
+		now:   time.Now,
 	}
 }
 
-// AddUser adds a user
+// AddUser validates and adds a new user at version 1
 func (m *UserManager) AddUser(u User) error {
-	// TODO: Add user to map, check context
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	if _, exists := m.users[u.ID]; exists {
+		m.mutex.Unlock()
+		return ErrAlreadyExists
+	}
+	now := m.now()
+	u.Version = 1
+	u.CreatedAt = now
+	u.UpdatedAt = now
+	u.DeactivatedAt = time.Time{}
+	m.users[u.ID] = u
+	m.listeners.queueAdded(u)
+	m.mutex.Unlock()
+
+	m.listeners.drain()
 	return nil
 }
 
-// RemoveUser removes a user
+// UpdateUser replaces a user's fields. u.Version must match the stored
+// version, otherwise ErrVersionConflict is returned and nothing changes; a
+// zero version never matches. The updated user, with its new version, is
+// returned.
+func (m *UserManager) UpdateUser(u User) (User, error) {
+	if err := m.ctx.Err(); err != nil {
+		return User{}, err
+	}
+	if err := u.Validate(); err != nil {
+		return User{}, err
+	}
+	if u.Version == 0 {
+		// Zero tells modify to skip the check, which only internal changes may do
+		return User{}, ErrVersionConflict
+	}
+
+	return m.modify(u.ID, u.Version, func(stored *User) {
+		u.Version = stored.Version
+		u.CreatedAt = stored.CreatedAt
+		u.DeactivatedAt = stored.DeactivatedAt
+		*stored = u
+	})
+}
+
+// DeactivateUser hides a user from listings without deleting them
+func (m *UserManager) DeactivateUser(id string) (User, error) {
+	if err := m.ctx.Err(); err != nil {
+		return User{}, err
+	}
+	now := m.now()
+	return m.modify(id, 0, func(stored *User) {
+		if stored.Active() {
+			stored.DeactivatedAt = now
+		}
+	})
+}
+
+// ReactivateUser undoes DeactivateUser
+func (m *UserManager) ReactivateUser(id string) (User, error) {
+	if err := m.ctx.Err(); err != nil {
+		return User{}, err
+	}
+	return m.modify(id, 0, func(stored *User) {
+		stored.DeactivatedAt = time.Time{}
+	})
+}
+
+// modify applies change to a stored user, checking version unless it is
+// zero, then bumps the version and notifies listeners
+func (m *UserManager) modify(id string, version uint64, change func(*User)) (User, error) {
+	m.mutex.Lock()
+	stored, ok := m.users[id]
+	if !ok {
+		m.mutex.Unlock()
+		return User{}, ErrNotFound
+	}
+	if version != 0 && version != stored.Version {
+		m.mutex.Unlock()
+		return User{}, ErrVersionConflict
+	}
+
+	old := stored
+	change(&stored)
+	stored.Version = old.Version + 1
+	stored.UpdatedAt = m.now()
+	m.users[id] = stored
+	m.listeners.queueUpdated(old, stored)
+	m.mutex.Unlock()
+
+	m.listeners.drain()
+	return stored, nil
+}
+
+// RemoveUser permanently deletes a user
 func (m *UserManager) RemoveUser(id string) error {
-	// TODO: Remove user from map
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	u, ok := m.users[id]
+	if !ok {
+		m.mutex.Unlock()
+		return ErrNotFound
+	}
+	delete(m.users, id)
+	m.listeners.queueRemoved(u)
+	m.mutex.Unlock()
+
+	m.listeners.drain()
 	return nil
 }
 
-// GetUser retrieves a user by id
+// GetUser retrieves a user by id, including deactivated users
 func (m *UserManager) GetUser(id string) (User, error) {
-	// TODO: Get user from map
-	return User{}, errors.New("not found")
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+// SortField selects the ordering of ListUsers
+type SortField string
+
+const (
+	SortByID        SortField = "id"
+	SortByName      SortField = "name"
+	SortByCreatedAt SortField = "created_at"
+	SortByUpdatedAt SortField = "updated_at"
+)
+
+// ListOptions filters and orders ListUsers
+// Empty fields do not filter; Search matches name, display name or email case-insensitively
+
+type ListOptions struct {
+	Status          Status
+	Timezone        string
+	Search          string
+	IncludeInactive bool
+	SortBy          SortField // SortByID when empty
+	Descending      bool
+	Offset, Limit   int // Limit 0 returns every match
+}
+
+// ListUsers returns the users matching opts
+func (m *UserManager) ListUsers(opts ListOptions) []User {
+	search := strings.ToLower(opts.Search)
+
+	m.mutex.RLock()
+	result := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		switch {
+		case !opts.IncludeInactive && !u.Active():
+		case opts.Status != "" && u.Status != opts.Status:
+		case opts.Timezone != "" && u.Timezone != opts.Timezone:
+		case search != "" && !strings.Contains(strings.ToLower(u.Name), search) &&
+			!strings.Contains(strings.ToLower(u.DisplayName), search) &&
+			!strings.Contains(strings.ToLower(u.Email), search):
+		default:
+			result = append(result, u)
+		}
+	}
+	m.mutex.RUnlock()
+
+	less := func(a, b User) bool { return a.ID < b.ID }
+	switch opts.SortBy {
+	case SortByName:
+		less = func(a, b User) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case SortByCreatedAt:
+		less = func(a, b User) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case SortByUpdatedAt:
+		less = func(a, b User) bool { return a.UpdatedAt.Before(b.UpdatedAt) }
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if opts.Descending {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.ID < b.ID // Stable order for ties
+	})
+
+	if opts.Offset > 0 {
+		result = result[min(opts.Offset, len(result)):]
+	}
+	if opts.Limit > 0 && len(result) > opts.Limit {
+		result = result[:opts.Limit]
+	}
+	return result
 }
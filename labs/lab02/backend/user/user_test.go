@@ -2,7 +2,12 @@ package user
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestUserValidation(t *testing.T) {
@@ -56,3 +61,257 @@ func TestUserContextCancellation(t *testing.T) {
 		t.Error("expected error after context cancel, got nil")
 	}
 }
+
+func TestUserProfileValidation(t *testing.T) {
+	base := User{Name: "Alice", Email: "alice@example.com", ID: "alice"}
+	tests := []struct {
+		name   string
+		modify func(u *User)
+		want   error
+	}{
+		{"full profile", func(u *User) {
+			u.DisplayName, u.AvatarURL, u.Status, u.Timezone = "Al", "https://cdn.example.com/a.png", StatusAway, "UTC"
+		}, nil},
+		{"relative avatar", func(u *User) { u.AvatarURL = "/a.png" }, ErrInvalidAvatar},
+		{"javascript avatar", func(u *User) { u.AvatarURL = "javascript:alert(1)" }, ErrInvalidAvatar},
+		{"unknown status", func(u *User) { u.Status = "sleeping" }, ErrInvalidStatus},
+		{"unknown timezone", func(u *User) { u.Timezone = "Mars/Olympus_Mons" }, ErrInvalidTimezone},
+		{"display name in email", func(u *User) { u.Email = "Alice <alice@example.com>" }, ErrInvalidEmail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := base
+			tt.modify(&u)
+			if err := u.Validate(); err != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestUpdateUserVersioning(t *testing.T) {
+	mgr := NewUserManager()
+	if err := mgr.AddUser(User{Name: "Bob", Email: "bob@example.com", ID: "bob"}); err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	if err := mgr.AddUser(User{Name: "Bob", Email: "bob@example.com", ID: "bob"}); err != ErrAlreadyExists {
+		t.Errorf("expected ErrAlreadyExists, got %v", err)
+	}
+
+	first, _ := mgr.GetUser("bob")
+	if first.Version != 1 {
+		t.Fatalf("expected version 1, got %d", first.Version)
+	}
+
+	edit := first
+	edit.Status = StatusBusy
+	updated, err := mgr.UpdateUser(edit)
+	if err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if updated.Version != 2 || updated.Status != StatusBusy || !updated.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("unexpected updated user: %+v", updated)
+	}
+
+	// A second writer still holding version 1 loses
+	stale := first
+	stale.DisplayName = "Bobby"
+	if _, err := mgr.UpdateUser(stale); err != ErrVersionConflict {
+		t.Errorf("expected ErrVersionConflict, got %v", err)
+	}
+	if current, _ := mgr.GetUser("bob"); current.DisplayName != "" || current.Version != 2 {
+		t.Errorf("conflicting update must not change the user: %+v", current)
+	}
+
+	// A user built from scratch has no version and cannot overwrite
+	blind := updated
+	blind.Version = 0
+	blind.DisplayName = "Bobby"
+	if _, err := mgr.UpdateUser(blind); err != ErrVersionConflict {
+		t.Errorf("expected ErrVersionConflict for version 0, got %v", err)
+	}
+	if current, _ := mgr.GetUser("bob"); current.DisplayName != "" || current.Version != 2 {
+		t.Errorf("versionless update must not change the user: %+v", current)
+	}
+
+	if _, err := mgr.UpdateUser(User{Name: "Ghost", Email: "ghost@example.com", ID: "ghost", Version: 1}); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	mgr := NewUserManager()
+	now := time.Unix(1000, 0)
+	mgr.now = func() time.Time { now = now.Add(time.Second); return now }
+
+	for _, u := range []User{
+		{ID: "c", Name: "carol", Email: "carol@example.com", Status: StatusOnline, Timezone: "UTC"},
+		{ID: "a", Name: "Alice", Email: "alice@example.com", Status: StatusAway},
+		{ID: "b", Name: "bob", Email: "bob@corp.example", Status: StatusOnline, DisplayName: "Builder Bob"},
+		{ID: "d", Name: "Dave", Email: "dave@example.com", Status: StatusOnline},
+	} {
+		if err := mgr.AddUser(u); err != nil {
+			t.Fatalf("AddUser failed: %v", err)
+		}
+	}
+	if _, err := mgr.DeactivateUser("d"); err != nil {
+		t.Fatalf("DeactivateUser failed: %v", err)
+	}
+
+	ids := func(users []User) string {
+		var b strings.Builder
+		for _, u := range users {
+			b.WriteString(u.ID)
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want string
+	}{
+		{"default", ListOptions{}, "abc"},
+		{"include inactive", ListOptions{IncludeInactive: true}, "abcd"},
+		{"status", ListOptions{Status: StatusOnline}, "bc"},
+		{"timezone", ListOptions{Timezone: "UTC"}, "c"},
+		{"search display name", ListOptions{Search: "builder"}, "b"},
+		{"search email", ListOptions{Search: "EXAMPLE.COM"}, "ac"},
+		{"by name", ListOptions{SortBy: SortByName}, "abc"},
+		{"by created desc", ListOptions{SortBy: SortByCreatedAt, Descending: true}, "bac"},
+		{"page", ListOptions{Offset: 1, Limit: 1}, "b"},
+		{"offset past end", ListOptions{Offset: 10}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(mgr.ListUsers(tt.opts)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeactivateUser(t *testing.T) {
+	mgr := NewUserManager()
+	mgr.AddUser(User{Name: "Eve", Email: "eve@example.com", ID: "eve"})
+
+	u, err := mgr.DeactivateUser("eve")
+	if err != nil || u.Active() || u.Version != 2 {
+		t.Fatalf("unexpected deactivation result: %+v, %v", u, err)
+	}
+	// Deactivated users are still retrievable and updatable
+	if got, err := mgr.GetUser("eve"); err != nil || got.Active() {
+		t.Errorf("expected inactive user from GetUser, got %+v, %v", got, err)
+	}
+	u.DisplayName = "Eve (away)"
+	if u, err = mgr.UpdateUser(u); err != nil || u.Active() {
+		t.Errorf("update must keep deactivation: %+v, %v", u, err)
+	}
+
+	if u, err = mgr.ReactivateUser("eve"); err != nil || !u.Active() {
+		t.Errorf("expected active user after reactivation: %+v, %v", u, err)
+	}
+	if _, err := mgr.DeactivateUser("nobody"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestUserEvents(t *testing.T) {
+	mgr := NewUserManager()
+	var events []string
+	var mutex sync.Mutex
+	record := func(event string) {
+		mutex.Lock()
+		events = append(events, event)
+		mutex.Unlock()
+	}
+
+	unsubscribe := mgr.OnUserAdded(func(u User) { record("added " + u.ID) })
+	mgr.OnUserUpdated(func(old, updated User) {
+		record(fmt.Sprintf("updated %s v%d->v%d", updated.ID, old.Version, updated.Version))
+	})
+	mgr.OnUserRemoved(func(u User) {
+		record("removed " + u.ID)
+		// Listeners run outside the lock and may use the manager
+		if _, err := mgr.GetUser(u.ID); err != ErrNotFound {
+			t.Errorf("expected removed user to be gone, got %v", err)
+		}
+	})
+
+	mgr.AddUser(User{Name: "Fay", Email: "fay@example.com", ID: "fay"})
+	mgr.DeactivateUser("fay")
+	mgr.RemoveUser("fay")
+	unsubscribe()
+	mgr.AddUser(User{Name: "Gus", Email: "gus@example.com", ID: "gus"})
+
+	want := []string{"added fay", "updated fay v1->v2", "removed fay"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+}
+
+func TestUpdateUserConcurrent(t *testing.T) {
+	mgr := NewUserManager()
+	mgr.AddUser(User{Name: "Hal", Email: "hal@example.com", ID: "hal"})
+	start, _ := mgr.GetUser("hal")
+
+	// Every writer starts from the same version; exactly one may win
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	wins := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u := start
+			u.DisplayName = fmt.Sprintf("Hal %d", i)
+			if _, err := mgr.UpdateUser(u); err == nil {
+				mutex.Lock()
+				wins++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Errorf("expected exactly one successful update, got %d", wins)
+	}
+}
+
+func TestUserEventsInVersionOrder(t *testing.T) {
+	mgr := NewUserManager()
+	mgr.AddUser(User{Name: "Ivy", Email: "ivy@example.com", ID: "ivy"})
+
+	var versions []uint64
+	mgr.OnUserUpdated(func(old, updated User) {
+		// Slow listeners give later changes a chance to overtake
+		time.Sleep(time.Millisecond)
+		if updated.Version != old.Version+1 {
+			t.Errorf("event skips from v%d to v%d", old.Version, updated.Version)
+		}
+		versions = append(versions, updated.Version)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				mgr.DeactivateUser("ivy")
+			} else {
+				mgr.ReactivateUser("ivy")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(versions) != 20 {
+		t.Fatalf("expected 20 events, got %d", len(versions))
+	}
+	for i, version := range versions {
+		if version != uint64(i+2) {
+			t.Fatalf("events out of version order: %v", versions)
+		}
+	}
+}
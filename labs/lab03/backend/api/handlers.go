@@ -1,153 +1,833 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lab03-backend/auth"
+	"lab03-backend/models"
+	"lab03-backend/status"
 	"lab03-backend/storage"
+	"log"
 	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/idempotency"
 )
 
-// Handler holds the storage instance
+// DefaultHeartbeat is how often an idle event stream sends a comment to keep
+// proxies from closing it
+const DefaultHeartbeat = 15 * time.Second
+
+// maxRequestBody is the largest JSON request body parseJSON reads
+const maxRequestBody = 1 << 20
+
+// Handler holds the storage instance, the feed of its changes and the token
+// service authenticating writes
 type Handler struct {
-	// TODO: Add storage field of type *storage.MemoryStorage
+	storage     storage.MessageRepository
+	feed        *storage.Feed
+	tokens      *auth.TokenService
+	heartbeat   time.Duration
+	localImages bool
+	replays     *idempotency.Store // Responses to requests with an Idempotency-Key
+}
+
+// NewHandler creates a new handler instance without authentication; every
+// request that needs a caller is answered with 401
+func NewHandler(storage storage.MessageRepository) *Handler {
+	return NewHandlerWithAuth(storage, nil)
+}
+
+// NewHandlerWithAuth creates a handler accepting bearer tokens issued by tokens
+func NewHandlerWithAuth(repo storage.MessageRepository, tokens *auth.TokenService) *Handler {
+	feed := storage.NewFeed(storage.DefaultFeedHistory)
+	return &Handler{
+		storage:   storage.NewNotifier(repo, feed),
+		feed:      feed,
+		tokens:    tokens,
+		heartbeat: DefaultHeartbeat,
+		replays:   idempotency.NewStore(idempotency.DefaultTTL),
+	}
+}
+
+// UseLocalImages makes status responses link to the cats served by
+// GET /api/status/{code}/image instead of http.cat
+func (h *Handler) UseLocalImages() {
+	h.localImages = true
 }
 
-// NewHandler creates a new handler instance
-func NewHandler(storage *storage.MemoryStorage) *Handler {
-	// TODO: Return a new Handler instance with provided storage
-	return nil
+// Close ends every open event stream. The storage is left open.
+func (h *Handler) Close() {
+	h.feed.Close()
 }
 
 // SetupRoutes configures all API routes
 func (h *Handler) SetupRoutes() *mux.Router {
-	// TODO: Create a new mux router
-	// TODO: Add CORS middleware
-	// TODO: Create API v1 subrouter with prefix "/api"
-	// TODO: Add the following routes:
-	// GET /messages -> h.GetMessages
-	// POST /messages -> h.CreateMessage
-	// PUT /messages/{id} -> h.UpdateMessage
-	// DELETE /messages/{id} -> h.DeleteMessage
-	// GET /status/{code} -> h.GetHTTPStatus
-	// GET /health -> h.HealthCheck
-	// TODO: Return the router
-	return nil
+	router := mux.NewRouter()
+	router.Use(corsMiddleware)
+
+	api := router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/messages", h.GetMessages).Methods(http.MethodGet)
+	// Only the small JSON writes take an Idempotency-Key. Imports can be
+	// larger than the replay store buffers, and re-running one is already
+	// safe with ids=preserve.
+	api.Handle("/messages", h.replays.Middleware(h.authenticated(h.CreateMessage))).Methods(http.MethodPost)
+	api.HandleFunc("/messages/stream", h.StreamMessages).Methods(http.MethodGet)
+	api.HandleFunc("/messages/export", h.ExportMessages).Methods(http.MethodGet)
+	api.HandleFunc("/messages/import", h.authenticated(h.ImportMessages)).Methods(http.MethodPost)
+	api.HandleFunc("/messages/{id}", h.GetMessage).Methods(http.MethodGet)
+	api.HandleFunc("/messages/{id}", h.authenticated(h.UpdateMessage)).Methods(http.MethodPut)
+	api.HandleFunc("/messages/{id}", h.authenticated(h.DeleteMessage)).Methods(http.MethodDelete)
+	api.HandleFunc("/messages/{id}/history", h.GetMessageHistory).Methods(http.MethodGet)
+	api.HandleFunc("/messages/{id}/replies", h.GetMessageReplies).Methods(http.MethodGet)
+	api.Handle("/messages/{id}/reactions", h.replays.Middleware(h.authenticated(h.AddReaction))).Methods(http.MethodPost)
+	api.HandleFunc("/messages/{id}/reactions", h.authenticated(h.RemoveReaction)).Methods(http.MethodDelete)
+	api.HandleFunc("/status", h.ListHTTPStatuses).Methods(http.MethodGet)
+	api.HandleFunc("/status/{code}", h.GetHTTPStatus).Methods(http.MethodGet)
+	api.HandleFunc("/status/{code}/image", h.GetHTTPStatusImage).Methods(http.MethodGet)
+	api.HandleFunc("/health", h.HealthCheck).Methods(http.MethodGet)
+	// Preflight requests are answered by corsMiddleware, but mux only runs
+	// middleware for matched routes
+	api.PathPrefix("/").Methods(http.MethodOptions).HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	return router
 }
 
 // GetMessages handles GET /api/messages
+// Query parameters: limit, cursor or after_id, username, since and until
+// (RFC 3339), q (content search), top_level (omit replies) and sort
+// ("timestamp" or "-timestamp")
 func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement GetMessages handler
-	// Get all messages from storage
-	// Create successful API response
-	// Write JSON response with status 200
-	// Handle any errors appropriately
+	query, err := parseMessageQuery(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.writePage(w, r, query)
+}
+
+// GetMessageReplies handles GET /api/messages/{id}/replies, taking the same
+// query parameters as GET /api/messages
+func (h *Handler) GetMessageReplies(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.messageID(w, r)
+	if !ok {
+		return
+	}
+	query, err := parseMessageQuery(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := h.storage.GetByID(id); err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+	query.ParentID = id
+	h.writePage(w, r, query)
+}
+
+// writePage writes one page of query results with a link to the next
+func (h *Handler) writePage(w http.ResponseWriter, r *http.Request, query storage.Query) {
+	page, err := h.storage.Query(query)
+	switch {
+	case errors.Is(err, storage.ErrInvalidCursor):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, storage.ErrMessageNotFound), errors.Is(err, storage.ErrInvalidID):
+		h.writeError(w, http.StatusBadRequest, "after_id: "+err.Error())
+		return
+	case err != nil:
+		h.writeStorageError(w, err)
+		return
+	}
+
+	meta := &models.PageMeta{Limit: query.Limit, NextCursor: page.NextCursor}
+	if page.NextCursor != "" {
+		params := r.URL.Query()
+		params.Del("after_id")
+		params.Set("cursor", page.NextCursor)
+		meta.Next = r.URL.Path + "?" + params.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", meta.Next))
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: page.Messages, Meta: meta})
+}
+
+// parseMessageQuery converts GET /api/messages parameters into a storage.Query
+func parseMessageQuery(params url.Values) (storage.Query, error) {
+	query := storage.Query{
+		Username: params.Get("username"),
+		Search:   params.Get("q"),
+		Cursor:   params.Get("cursor"),
+		Limit:    storage.DefaultPageSize,
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > storage.MaxPageSize {
+			return query, fmt.Errorf("limit must be a number between 1 and %d", storage.MaxPageSize)
+		}
+		query.Limit = limit
+	}
+	if v := params.Get("after_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return query, errors.New("after_id must be a positive number")
+		}
+		query.AfterID = id
+	}
+	if v := params.Get("top_level"); v != "" {
+		topLevel, err := strconv.ParseBool(v)
+		if err != nil {
+			return query, errors.New("top_level must be true or false")
+		}
+		query.TopLevel = topLevel
+	}
+	for name, dst := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if v := params.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = t
+		}
+	}
+	switch params.Get("sort") {
+	case "", "timestamp":
+	case "-timestamp":
+		query.Descending = true
+	default:
+		return query, errors.New(`sort must be "timestamp" or "-timestamp"`)
+	}
+	return query, nil
+}
+
+// StreamMessages handles GET /api/messages/stream, sending created, updated
+// and deleted events as Server-Sent Events. A client reconnecting with
+// Last-Event-ID first receives the events it missed; if those are gone it gets
+// a "reset" event and should reload the message list.
+func (h *Handler) StreamMessages(w http.ResponseWriter, r *http.Request) {
+	var lastEventID uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Last-Event-ID must be an event id")
+			return
+		}
+		lastEventID = id
+	}
+
+	backlog, events, cancel, err := h.feed.Subscribe(lastEventID)
+	if errors.Is(err, storage.ErrFeedClosed) {
+		h.writeError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	defer cancel()
+
+	// The stream outlives the server's write timeout
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if errors.Is(err, storage.ErrEventsExpired) {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range backlog {
+		writeEvent(w, event)
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				// Shutting down, or this client fell behind; it reconnects
+				// with Last-Event-ID
+				return
+			}
+			writeEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes one SSE event; deleted events carry only the id
+func writeEvent(w http.ResponseWriter, event storage.Event) {
+	var data interface{} = event.Message
+	if event.Type == storage.EventDeleted {
+		data = map[string]int{"id": event.Message.ID}
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("encode event: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload)
 }
 
 // CreateMessage handles POST /api/messages
+// The author is the authenticated caller; a username in the body is ignored.
+// A parent_id makes the message a reply to that message.
 func (h *Handler) CreateMessage(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement CreateMessage handler
-	// Parse JSON request body into CreateMessageRequest
-	// Validate the request
-	// Create message in storage
-	// Create successful API response
-	// Write JSON response with status 201
-	// Handle validation and storage errors appropriately
+	var req models.CreateMessageRequest
+	if err := h.parseJSON(w, r, &req); err != nil {
+		h.writeRequestError(w, err)
+		return
+	}
+	caller, _ := auth.FromContext(r.Context())
+	req.Username = caller.Username
+	if err := req.Validate(); err != nil {
+		h.writeRequestError(w, err)
+		return
+	}
+
+	var msg *models.Message
+	var err error
+	if req.ParentID != 0 {
+		msg, err = h.storage.Reply(req.ParentID, req.Username, req.Content)
+	} else {
+		msg, err = h.storage.Create(req.Username, req.Content)
+	}
+	if err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(msg))
+	h.writeJSON(w, http.StatusCreated, models.APIResponse{Success: true, Data: msg})
+}
+
+// GetMessage handles GET /api/messages/{id}
+// The ETag header carries the version to send back in If-Match when updating.
+func (h *Handler) GetMessage(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.messageID(w, r)
+	if !ok {
+		return
+	}
+	msg, err := h.storage.GetByID(id)
+	if err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(msg))
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: msg})
+}
+
+// GetMessageHistory handles GET /api/messages/{id}/history
+func (h *Handler) GetMessageHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.messageID(w, r)
+	if !ok {
+		return
+	}
+	history, err := h.storage.History(id)
+	if err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: history})
+}
+
+// AddReaction handles POST /api/messages/{id}/reactions with {"emoji": "👍"}
+// Each caller may react once with each emoji; repeating one gets 409.
+func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.messageID(w, r)
+	if !ok {
+		return
+	}
+	var req models.ReactionRequest
+	if err := h.parseJSON(w, r, &req); err != nil {
+		h.writeRequestError(w, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeRequestError(w, err)
+		return
+	}
+
+	caller, _ := auth.FromContext(r.Context())
+	msg, err := h.storage.AddReaction(id, caller.Username, req.Emoji)
+	if err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, models.APIResponse{Success: true, Data: msg})
+}
+
+// RemoveReaction handles DELETE /api/messages/{id}/reactions?emoji=👍,
+// removing the caller's reaction
+func (h *Handler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.messageID(w, r)
+	if !ok {
+		return
+	}
+	req := models.ReactionRequest{Emoji: r.URL.Query().Get("emoji")}
+	if err := req.Validate(); err != nil {
+		h.writeRequestError(w, err)
+		return
+	}
+
+	caller, _ := auth.FromContext(r.Context())
+	msg, err := h.storage.RemoveReaction(id, caller.Username, req.Emoji)
+	if err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: msg})
 }
 
 // UpdateMessage handles PUT /api/messages/{id}
+// Only the author or an admin may edit. If-Match must hold the ETag of the
+// version being edited, or "*"; a stale ETag gets 412 Precondition Failed.
 func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement UpdateMessage handler
-	// Extract ID from URL path variables
-	// Parse JSON request body into UpdateMessageRequest
-	// Validate the request
-	// Update message in storage
-	// Create successful API response
-	// Write JSON response with status 200
-	// Handle validation, parsing, and storage errors appropriately
+	id, ok := h.messageID(w, r)
+	if !ok || !h.authorize(w, r, id) {
+		return
+	}
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		h.writeError(w, http.StatusPreconditionRequired, "If-Match header is required")
+		return
+	}
+
+	var req models.UpdateMessageRequest
+	if err := h.parseJSON(w, r, &req); err != nil {
+		h.writeRequestError(w, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeRequestError(w, err)
+		return
+	}
+
+	version, err := h.matchingVersion(id, ifMatch)
+	if err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+	msg, err := h.storage.UpdateIfVersion(id, version, req.Content)
+	if err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(msg))
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: msg})
 }
 
 // DeleteMessage handles DELETE /api/messages/{id}
+// Only the author or an admin may delete.
 func (h *Handler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement DeleteMessage handler
-	// Extract ID from URL path variables
-	// Delete message from storage
-	// Write response with status 204 (No Content)
-	// Handle parsing and storage errors appropriately
+	id, ok := h.messageID(w, r)
+	if !ok || !h.authorize(w, r, id) {
+		return
+	}
+	if err := h.storage.Delete(id); err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // GetHTTPStatus handles GET /api/status/{code}
 func (h *Handler) GetHTTPStatus(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement GetHTTPStatus handler
-	// Extract status code from URL path variables
-	// Validate status code (must be between 100-599)
-	// Create HTTPStatusResponse with:
-	//   - StatusCode: parsed code
-	//   - ImageURL: "https://http.cat/{code}"
-	//   - Description: HTTP status description
-	// Create successful API response
-	// Write JSON response with status 200
-	// Handle parsing and validation errors appropriately
+	code, ok := h.statusCode(w, r)
+	if !ok {
+		return
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    h.statusResponse(status.Describe(code)),
+	})
+}
+
+// ListHTTPStatuses handles GET /api/status, listing the registered status
+// codes. Query parameters: class ("client_error" or "4xx"), cacheable,
+// retryable and q (search in names and descriptions).
+func (h *Handler) ListHTTPStatuses(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	filter := status.Filter{Search: params.Get("q")}
+	if v := params.Get("class"); v != "" {
+		class, ok := status.ParseClass(v)
+		if !ok {
+			h.writeError(w, http.StatusBadRequest, `class must be a status class such as "client_error" or "4xx"`)
+			return
+		}
+		filter.Class = class
+	}
+	for name, dst := range map[string]**bool{"cacheable": &filter.Cacheable, "retryable": &filter.Retryable} {
+		if v := params.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, name+" must be true or false")
+				return
+			}
+			*dst = &b
+		}
+	}
+
+	statuses := status.List(filter)
+	responses := make([]models.HTTPStatusResponse, len(statuses))
+	for i, s := range statuses {
+		responses[i] = h.statusResponse(s)
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: responses})
+}
+
+// GetHTTPStatusImage handles GET /api/status/{code}/image, drawing the cat
+// locally
+func (h *Handler) GetHTTPStatusImage(w http.ResponseWriter, r *http.Request) {
+	code, ok := h.statusCode(w, r)
+	if !ok {
+		return
+	}
+	image, err := status.Image(code)
+	if err != nil {
+		log.Printf("draw status image: %v", err)
+		h.writeError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	w.Header().Set("Content-Type", status.ImageContentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(image)
+}
+
+// statusCode parses the {code} path variable, writing a 400 response unless
+// it is between 100 and 599
+func (h *Handler) statusCode(w http.ResponseWriter, r *http.Request) (int, bool) {
+	code, err := strconv.Atoi(mux.Vars(r)["code"])
+	if err != nil || code < 100 || code > 599 {
+		h.writeError(w, http.StatusBadRequest, "status code must be a number between 100 and 599")
+		return 0, false
+	}
+	return code, true
+}
+
+// statusResponse describes s, linking to its cat image
+func (h *Handler) statusResponse(s status.Status) models.HTTPStatusResponse {
+	imageURL := fmt.Sprintf("https://http.cat/%d", s.Code)
+	if h.localImages {
+		imageURL = fmt.Sprintf("/api/status/%d/image", s.Code)
+	}
+	return models.HTTPStatusResponse{
+		StatusCode:  s.Code,
+		Name:        s.Name,
+		Class:       string(s.Class),
+		RFC:         s.RFC,
+		ImageURL:    imageURL,
+		Description: s.Description,
+		Cacheable:   s.Cacheable,
+		Retryable:   s.Retryable,
+	}
 }
 
 // HealthCheck handles GET /api/health
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement HealthCheck handler
-	// Create a simple health check response with:
-	//   - status: "ok"
-	//   - message: "API is running"
-	//   - timestamp: current time
-	//   - total_messages: count from storage
-	// Write JSON response with status 200
+	total, err := h.storage.Total()
+	if err != nil {
+		log.Printf("health check: %v", err)
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status":    "unavailable",
+			"message":   "storage is not reachable",
+			"timestamp": time.Now(),
+		})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "ok",
+		"message":        "API is running",
+		"timestamp":      time.Now(),
+		"total_messages": total,
+	})
+}
+
+// authenticated wraps a handler that needs a caller. Requests without a
+// valid bearer token get 401; otherwise the caller's auth.Identity is added to
+// the request context.
+func (h *Handler) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.tokens == nil {
+			h.writeError(w, http.StatusUnauthorized, "authentication is not configured")
+			return
+		}
+		caller, err := h.tokens.Verify(auth.BearerToken(r.Header.Get("Authorization")))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			h.writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next(w, r.WithContext(auth.WithIdentity(r.Context(), caller)))
+	}
+}
+
+// authorize writes 403 unless the caller is the author of message id or an
+// admin, and 404 when there is no such message
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, id int) bool {
+	caller, _ := auth.FromContext(r.Context())
+	msg, err := h.storage.GetByID(id)
+	if err != nil {
+		h.writeStorageError(w, err)
+		return false
+	}
+	if msg.Username != caller.Username && !caller.IsAdmin() {
+		h.writeError(w, http.StatusForbidden, "only the author or an admin may change this message")
+		return false
+	}
+	return true
+}
+
+// messageID parses the {id} path variable, writing a 400 response when it is
+// not a number
+func (h *Handler) messageID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, storage.ErrInvalidID.Error())
+		return 0, false
+	}
+	return id, true
+}
+
+// etag is the entity tag of a message version
+func etag(msg *models.Message) string {
+	return `"` + strconv.Itoa(msg.Version) + `"`
+}
+
+// matchingVersion turns an If-Match header into the version to pass to
+// UpdateIfVersion: 0 for "*", the listed version when there is one, and
+// otherwise whichever listed version is current. Weak and malformed tags
+// never match.
+func (h *Handler) matchingVersion(id int, ifMatch string) (int, error) {
+	var versions []int
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return 0, nil
+		}
+		unquoted, err := strconv.Unquote(tag)
+		if err != nil {
+			continue
+		}
+		if version, err := strconv.Atoi(unquoted); err == nil && version > 0 {
+			versions = append(versions, version)
+		}
+	}
+
+	switch len(versions) {
+	case 0:
+		// Nothing can match, but a missing message is still a 404
+		if _, err := h.storage.GetByID(id); err != nil {
+			return 0, err
+		}
+		return 0, storage.ErrVersionConflict
+	case 1:
+		return versions[0], nil
+	}
+	msg, err := h.storage.GetByID(id)
+	if err != nil {
+		return 0, err
+	}
+	if slices.Contains(versions, msg.Version) {
+		return msg.Version, nil
+	}
+	return 0, storage.ErrVersionConflict
+}
+
+// writeStorageError maps repository errors to HTTP statuses
+func (h *Handler) writeStorageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrVersionConflict):
+		h.writeError(w, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, storage.ErrDuplicateReaction):
+		h.writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, storage.ErrMessageNotFound), errors.Is(err, storage.ErrReactionNotFound):
+		h.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, storage.ErrInvalidID):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Printf("storage error: %v", err)
+		h.writeError(w, http.StatusInternalServerError, "internal server error")
+	}
 }
 
 // Helper function to write JSON responses
 func (h *Handler) writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	// TODO: Implement writeJSON helper
-	// Set Content-Type header to "application/json"
-	// Set status code
-	// Encode data as JSON and write to response
-	// Log any encoding errors
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("encode response: %v", err)
+	}
 }
 
 // Helper function to write error responses
 func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
-	// TODO: Implement writeError helper
-	// Create APIResponse with Success: false and Error: message
-	// Use writeJSON to send the error response
+	h.writeJSON(w, status, models.APIResponse{Success: false, Error: message})
+}
+
+// writeRequestError answers 422 with the field errors of an invalid request,
+// 413 for a body over maxRequestBody and 400 for a body that is not JSON
+func (h *Handler) writeRequestError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		h.writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must be at most %d bytes", maxBytesErr.Limit))
+		return
+	}
+	if fields, ok := models.AsValidationErrors(err); ok {
+		h.writeJSON(w, http.StatusUnprocessableEntity, models.APIResponse{
+			Success: false,
+			Error:   "validation failed",
+			Errors:  fields,
+		})
+		return
+	}
+	h.writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 }
 
 // Helper function to parse JSON request body
-func (h *Handler) parseJSON(r *http.Request, dst interface{}) error {
-	// TODO: Implement parseJSON helper
-	// Create JSON decoder from request body
-	// Decode into destination interface
-	// Return any decoding errors
-	return nil
-}
-
-// Helper function to get HTTP status description
-func getHTTPStatusDescription(code int) string {
-	// TODO: Implement getHTTPStatusDescription
-	// Return appropriate description for common HTTP status codes
-	// Use a switch statement or map to handle:
-	// 200: "OK", 201: "Created", 204: "No Content"
-	// 400: "Bad Request", 401: "Unauthorized", 404: "Not Found"
-	// 500: "Internal Server Error", etc.
-	// Return "Unknown Status" for unrecognized codes
-	return "Unknown Status"
+// Unknown fields, in nested objects too, and values of the wrong type are
+// reported as models.ValidationErrors. Bodies over maxRequestBody fail with
+// an *http.MaxBytesError.
+func (h *Handler) parseJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		return err
+	}
+	return decodeStrict(body, dst)
+}
+
+// decodeStrict decodes the JSON value in data into dst, the way parseJSON does
+func decodeStrict(data []byte, dst interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(dst)
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		return models.ValidationErrors{
+			models.NewFieldError(typeErr.Field, models.CodeInvalidType, typeErr.Field+" must be "+jsonType(typeErr.Type)),
+		}
+	case err != nil && strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder stops at the first one; list them all
+		if fields := unknownFields(data, reflect.TypeOf(dst).Elem()); len(fields) > 0 {
+			return fields
+		}
+	}
+	return err
+}
+
+// unknownFields reports every key of the JSON value body that has no
+// matching field in type t. Keys of nested objects are reported by their
+// path, such as "reactions[0].name".
+func unknownFields(body []byte, t reflect.Type) models.ValidationErrors {
+	var errs models.ValidationErrors
+	collectUnknownFields(body, t, "", &errs)
+	slices.SortFunc(errs, func(a, b models.FieldError) int { return strings.Compare(a.Field, b.Field) })
+	return errs
+}
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// collectUnknownFields adds the unknown keys of the JSON value body, found
+// at path, to errs
+func collectUnknownFields(body []byte, t reflect.Type, path string, errs *models.ValidationErrors) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// Types decoding themselves, such as time.Time, have no fields to check
+	if reflect.PointerTo(t).Implements(jsonUnmarshaler) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(body, &object) != nil {
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			// Matching is case-insensitive, as in encoding/json
+			fields[strings.ToLower(name)] = field.Type
+		}
+		for key, value := range object {
+			name := key
+			if path != "" {
+				name = path + "." + key
+			}
+			fieldType, ok := fields[strings.ToLower(key)]
+			if !ok {
+				*errs = append(*errs, models.NewFieldError(name, models.CodeUnknownField, fmt.Sprintf("unknown field %q", name)))
+				continue
+			}
+			collectUnknownFields(value, fieldType, name, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if json.Unmarshal(body, &items) != nil {
+			return
+		}
+		for i, item := range items {
+			collectUnknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// jsonType describes the JSON value expected for a Go type
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "a " + t.String()
 }
 
 // CORS middleware
 func corsMiddleware(next http.Handler) http.Handler {
-	// TODO: Implement CORS middleware
-	// Set the following headers:
-	// Access-Control-Allow-Origin: *
-	// Access-Control-Allow-Methods: GET, POST, PUT, DELETE, OPTIONS
-	// Access-Control-Allow-Headers: Content-Type, Authorization
-	// Handle OPTIONS preflight requests
-	// Call next handler for non-OPTIONS requests
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Implement CORS logic here
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, Last-Event-ID, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "Link, ETag, Idempotent-Replayed")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
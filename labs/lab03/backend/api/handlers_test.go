@@ -1,18 +1,36 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"io"
+	"lab03-backend/auth"
 	"lab03-backend/models"
 	"lab03-backend/storage"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
+// testTokens signs the tokens used by the handler tests
+var testTokens, _ = auth.NewTokenService("test secret")
+
 func setupTestHandler() *Handler {
 	storage := storage.NewMemoryStorage()
-	return NewHandler(storage)
+	return NewHandlerWithAuth(storage, testTokens)
+}
+
+// bearer returns an Authorization header value for username
+func bearer(username string, role auth.Role) string {
+	token, err := testTokens.Issue(auth.Identity{Username: username, Role: role})
+	if err != nil {
+		panic(err)
+	}
+	return "Bearer " + token
 }
 
 func TestGetMessages(t *testing.T) {
@@ -57,6 +75,7 @@ func TestCreateMessage(t *testing.T) {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", bearer("testuser", auth.RoleUser))
 
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
@@ -89,6 +108,7 @@ func TestUpdateMessage(t *testing.T) {
 	jsonData, _ := json.Marshal(createReq)
 	createHttpReq, _ := http.NewRequest("POST", "/api/messages", bytes.NewBuffer(jsonData))
 	createHttpReq.Header.Set("Content-Type", "application/json")
+	createHttpReq.Header.Set("Authorization", bearer("testuser", auth.RoleUser))
 
 	createRr := httptest.NewRecorder()
 	router.ServeHTTP(createRr, createHttpReq)
@@ -104,6 +124,8 @@ func TestUpdateMessage(t *testing.T) {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", createRr.Header().Get("ETag"))
+	req.Header.Set("Authorization", bearer("testuser", auth.RoleUser))
 
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
@@ -126,6 +148,7 @@ func TestDeleteMessage(t *testing.T) {
 	jsonData, _ := json.Marshal(createReq)
 	createHttpReq, _ := http.NewRequest("POST", "/api/messages", bytes.NewBuffer(jsonData))
 	createHttpReq.Header.Set("Content-Type", "application/json")
+	createHttpReq.Header.Set("Authorization", bearer("testuser", auth.RoleUser))
 
 	createRr := httptest.NewRecorder()
 	router.ServeHTTP(createRr, createHttpReq)
@@ -135,6 +158,7 @@ func TestDeleteMessage(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Authorization", bearer("testuser", auth.RoleUser))
 
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
@@ -187,6 +211,49 @@ func TestGetHTTPStatus(t *testing.T) {
 	}
 }
 
+func TestHTTPStatusCatalog(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	get := func(path string, data interface{}) int {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		json.NewDecoder(rr.Body).Decode(&struct {
+			Data interface{} `json:"data"`
+		}{data})
+		return rr.Code
+	}
+
+	var tooMany models.HTTPStatusResponse
+	get("/api/status/429", &tooMany)
+	if tooMany.Name != "Too Many Requests" || tooMany.Class != "client_error" || !tooMany.Retryable ||
+		tooMany.RFC != "RFC 6585" || tooMany.ImageURL != "https://http.cat/429" {
+		t.Errorf("Unexpected status %+v", tooMany)
+	}
+
+	var list []models.HTTPStatusResponse
+	if code := get("/api/status?class=5xx&retryable=true", &list); code != http.StatusOK || len(list) != 3 || list[0].StatusCode != 502 {
+		t.Errorf("Unexpected list %v %+v", code, list)
+	}
+	for _, query := range []string{"class=9xx", "cacheable=maybe"} {
+		if code := get("/api/status?"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: expected %v, got %v", query, http.StatusBadRequest, code)
+		}
+	}
+
+	handler.UseLocalImages()
+	var local models.HTTPStatusResponse
+	get("/api/status/404", &local)
+	if local.ImageURL != "/api/status/404/image" {
+		t.Fatalf("Expected a local image URL, got %q", local.ImageURL)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", local.ImageURL, nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(rr.Body.String(), "Not Found") {
+		t.Errorf("Unexpected image response %v %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
 func TestHealthCheck(t *testing.T) {
 	handler := setupTestHandler()
 	router := handler.SetupRoutes()
@@ -209,3 +276,531 @@ func TestHealthCheck(t *testing.T) {
 		t.Errorf("Expected Content-Type application/json, got %s", contentType)
 	}
 }
+
+func TestGetMessagesPagination(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	for _, content := range []string{"one", "two", "three"} {
+		handler.storage.Create("testuser", content)
+	}
+
+	var contents []string
+	next := "/api/messages?limit=2&sort=-timestamp"
+	for pages := 0; next != ""; pages++ {
+		if pages > 2 {
+			t.Fatal("Too many pages")
+		}
+		req := httptest.NewRequest("GET", next, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var response struct {
+			Data []models.Message `json:"data"`
+			Meta models.PageMeta  `json:"meta"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Could not decode response: %v", err)
+		}
+		for _, msg := range response.Data {
+			contents = append(contents, msg.Content)
+		}
+
+		next = response.Meta.Next
+		if link := rr.Header().Get("Link"); next != "" && link != "<"+next+`>; rel="next"` {
+			t.Errorf("Unexpected Link header %q for next page %q", link, next)
+		}
+	}
+
+	if len(contents) != 3 || contents[0] != "three" || contents[2] != "one" {
+		t.Errorf("Expected newest first, got %q", contents)
+	}
+}
+
+func TestGetMessagesInvalidQuery(t *testing.T) {
+	router := setupTestHandler().SetupRoutes()
+
+	for _, query := range []string{"limit=0", "limit=abc", "after_id=-1", "after_id=5", "since=yesterday", "sort=name", "cursor=bogus"} {
+		req := httptest.NewRequest("GET", "/api/messages?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %v, got %v", query, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+func TestUpdateMessagePreconditions(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("testuser", "original")
+
+	put := func(ifMatch, content string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.UpdateMessageRequest{Content: content})
+		req := httptest.NewRequest("PUT", "/api/messages/1", bytes.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		req.Header.Set("Authorization", bearer("testuser", auth.RoleUser))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := put("", "no precondition"); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("Expected %v without If-Match, got %v", http.StatusPreconditionRequired, rr.Code)
+	}
+	rr := put(`"1"`, "first edit")
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+		t.Fatalf("Expected 200 with ETag \"2\", got %v %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	for _, stale := range []string{`"1"`, `W/"2"`, `"1", "3"`, "garbage"} {
+		if rr := put(stale, "lost update"); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("If-Match %s: expected %v, got %v", stale, http.StatusPreconditionFailed, rr.Code)
+		}
+	}
+	if rr := put(`"1", "2"`, "second edit"); rr.Code != http.StatusOK {
+		t.Errorf("Expected a list containing the current ETag to match, got %v", rr.Code)
+	}
+	if rr := put("*", "third edit"); rr.Code != http.StatusOK {
+		t.Errorf("Expected * to match, got %v", rr.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/messages/1", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"4"` {
+		t.Errorf("Expected GET to return ETag \"4\", got %v %q", rr.Code, rr.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest("GET", "/api/messages/1/history", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var response struct {
+		Data []models.Revision `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&response)
+	if len(response.Data) != 4 || response.Data[0].Content != "original" || response.Data[3].Content != "third edit" {
+		t.Errorf("Unexpected history: %+v", response.Data)
+	}
+
+	if rr := put(`"1"`, "gone"); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected %v, got %v", http.StatusPreconditionFailed, rr.Code)
+	}
+	handler.storage.Delete(1)
+	if rr := put(`"4"`, "gone"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected %v for a deleted message, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestMessageOwnership(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	send := func(method, path, authorization string, body interface{}) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("If-Match", "*")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	errorOf := func(rr *httptest.ResponseRecorder) models.APIResponse {
+		var response models.APIResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return response
+	}
+
+	// The author comes from the token, not the body
+	rr := send("POST", "/api/messages", bearer("alice", auth.RoleUser), models.CreateMessageRequest{Username: "mallory", Content: "hi"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected %v, got %v: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+	if msg, _ := handler.storage.GetByID(1); msg.Username != "alice" {
+		t.Errorf("Expected the author to be alice, got %q", msg.Username)
+	}
+
+	for _, authorization := range []string{"", "Bearer not-a-token", "Basic YWxpY2U6c2VjcmV0"} {
+		rr := send("POST", "/api/messages", authorization, models.CreateMessageRequest{Content: "anonymous"})
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%q: expected %v with WWW-Authenticate, got %v", authorization, http.StatusUnauthorized, rr.Code)
+		}
+	}
+
+	rr = send("PUT", "/api/messages/1", bearer("bob", auth.RoleUser), models.UpdateMessageRequest{Content: "hijacked"})
+	if response := errorOf(rr); rr.Code != http.StatusForbidden || response.Success || response.Error == "" {
+		t.Errorf("Expected a 403 APIResponse error for another user's edit, got %v %+v", rr.Code, response)
+	}
+	if rr := send("DELETE", "/api/messages/1", bearer("bob", auth.RoleUser), nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected %v for another user's delete, got %v", http.StatusForbidden, rr.Code)
+	}
+	if rr := send("PUT", "/api/messages/1", bearer("alice", auth.RoleUser), models.UpdateMessageRequest{Content: "edited"}); rr.Code != http.StatusOK {
+		t.Errorf("Expected the author to edit, got %v", rr.Code)
+	}
+	if rr := send("PUT", "/api/messages/1", bearer("root", auth.RoleAdmin), models.UpdateMessageRequest{Content: "moderated"}); rr.Code != http.StatusOK {
+		t.Errorf("Expected an admin to edit, got %v", rr.Code)
+	}
+	if rr := send("DELETE", "/api/messages/1", bearer("root", auth.RoleAdmin), nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected an admin to delete, got %v", rr.Code)
+	}
+
+	// Without a token service nobody can write
+	router = NewHandler(storage.NewMemoryStorage()).SetupRoutes()
+	if rr := send("POST", "/api/messages", bearer("alice", auth.RoleUser), models.CreateMessageRequest{Content: "hi"}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %v without a token service, got %v", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	router := setupTestHandler().SetupRoutes()
+
+	tests := []struct {
+		name, body string
+		status     int
+		want       []string // field and code of each error
+	}{
+		{"all fields", `{"content": "   ", "parent_id": -1}`, http.StatusUnprocessableEntity,
+			[]string{"content required", "parent_id invalid"}},
+		{"too long", `{"content": "` + strings.Repeat("x", models.MaxContentLength+1) + `"}`, http.StatusUnprocessableEntity,
+			[]string{"content too_long"}},
+		{"unknown fields", `{"content": "hi", "colour": "red", "Author": "bob"}`, http.StatusUnprocessableEntity,
+			[]string{"Author unknown_field", "colour unknown_field"}},
+		{"wrong type", `{"content": 42}`, http.StatusUnprocessableEntity,
+			[]string{"content invalid_type"}},
+		{"not JSON", `{"content": `, http.StatusBadRequest, nil},
+		{"too large", `{"content": "` + strings.Repeat("x", maxRequestBody) + `"}`, http.StatusRequestEntityTooLarge, nil},
+		{"known fields in any case", `{"Content": "hi"}`, http.StatusCreated, nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/messages", strings.NewReader(tt.body))
+		req.Header.Set("Authorization", bearer("alice", auth.RoleUser))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected %v, got %v: %s", tt.name, tt.status, rr.Code, rr.Body)
+			continue
+		}
+
+		var response models.APIResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		var got []string
+		for _, e := range response.Errors {
+			got = append(got, e.Field+" "+e.Code)
+			if e.Message == "" {
+				t.Errorf("%s: error for %s has no message", tt.name, e.Field)
+			}
+		}
+		if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+			t.Errorf("%s: got errors %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeStrict_NestedUnknownFields(t *testing.T) {
+	data := `{"id": 1, "content": "hi", "edited_at": "2025-07-01T12:00:00Z",
+		"reactions": [{"emoji": "👍", "count": 1}, {"emoji": "🎉", "count": 2, "by": "bob"}]}`
+	var msg models.Message
+	err := decodeStrict([]byte(data), &msg)
+
+	fields, ok := models.AsValidationErrors(err)
+	if !ok || len(fields) != 1 || fields[0].Field != "reactions[1].by" || fields[0].Code != models.CodeUnknownField {
+		t.Errorf("Expected an unknown field error for reactions[1].by, got %v", err)
+	}
+}
+
+func TestCreateMessageIdempotency(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	post := func(key, content string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.CreateMessageRequest{Content: content})
+		req := httptest.NewRequest("POST", "/api/messages", bytes.NewReader(body))
+		req.Header.Set("Authorization", bearer("alice", auth.RoleUser))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := post("retry-1", "hello")
+	retry := post("retry-1", "hello")
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the first response to be replayed, got %v %s", retry.Code, retry.Body)
+	}
+	if n, _ := handler.storage.Total(); n != 1 {
+		t.Errorf("Expected one message, have %d", n)
+	}
+	if rr := post("retry-1", "changed"); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected %v for a reused key, got %v", http.StatusUnprocessableEntity, rr.Code)
+	}
+	post("retry-2", "hello")
+	if n, _ := handler.storage.Total(); n != 2 {
+		t.Errorf("Expected a new key to create a message, have %d", n)
+	}
+}
+
+func TestMessageReplies(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	parent, _ := handler.storage.Create("alice", "question")
+
+	post := func(parentID int) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.CreateMessageRequest{Content: "answer", ParentID: parentID})
+		req := httptest.NewRequest("POST", "/api/messages", bytes.NewReader(body))
+		req.Header.Set("Authorization", bearer("bob", auth.RoleUser))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	get := func(path string) (int, []models.Message) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		var response struct {
+			Data []models.Message `json:"data"`
+		}
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr.Code, response.Data
+	}
+
+	if rr := post(parent.ID); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected %v, got %v: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+	if rr := post(999); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected %v for a missing parent, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr := post(-1); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected %v for a negative parent_id, got %v", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	code, replies := get("/api/messages/1/replies")
+	if code != http.StatusOK || len(replies) != 1 || replies[0].ParentID != parent.ID || replies[0].Username != "bob" {
+		t.Errorf("Unexpected replies: %v %+v", code, replies)
+	}
+	if _, messages := get("/api/messages"); len(messages) != 2 || messages[0].ReplyCount != 1 {
+		t.Errorf("Expected the list to include the reply count, got %+v", messages)
+	}
+	if _, messages := get("/api/messages?top_level=true"); len(messages) != 1 || messages[0].ID != parent.ID {
+		t.Errorf("Expected only the parent, got %+v", messages)
+	}
+	if code, _ := get("/api/messages/999/replies"); code != http.StatusNotFound {
+		t.Errorf("Expected %v for replies to a missing message, got %v", http.StatusNotFound, code)
+	}
+	if code, _ := get("/api/messages?top_level=maybe"); code != http.StatusBadRequest {
+		t.Errorf("Expected %v for an invalid top_level, got %v", http.StatusBadRequest, code)
+	}
+}
+
+func TestMessageReactions(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("alice", "hello")
+
+	send := func(method, path, user string, body interface{}) (*httptest.ResponseRecorder, *models.Message) {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		if user != "" {
+			req.Header.Set("Authorization", bearer(user, auth.RoleUser))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response struct {
+			Data *models.Message `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response.Data
+	}
+	thumbsUp := models.ReactionRequest{Emoji: "👍"}
+
+	send("POST", "/api/messages/1/reactions", "alice", thumbsUp)
+	rr, msg := send("POST", "/api/messages/1/reactions", "bob", thumbsUp)
+	if rr.Code != http.StatusCreated || len(msg.Reactions) != 1 || msg.Reactions[0] != (models.ReactionCount{Emoji: "👍", Count: 2}) {
+		t.Errorf("Unexpected response %v %+v", rr.Code, msg)
+	}
+
+	tests := []struct {
+		method, path, user string
+		body               interface{}
+		want               int
+	}{
+		{"POST", "/api/messages/1/reactions", "bob", thumbsUp, http.StatusConflict},
+		{"POST", "/api/messages/1/reactions", "", thumbsUp, http.StatusUnauthorized},
+		{"POST", "/api/messages/1/reactions", "bob", models.ReactionRequest{Emoji: "yes"}, http.StatusUnprocessableEntity},
+		{"POST", "/api/messages/999/reactions", "bob", thumbsUp, http.StatusNotFound},
+		{"DELETE", "/api/messages/1/reactions?emoji=%F0%9F%8E%89", "bob", nil, http.StatusNotFound},
+		{"DELETE", "/api/messages/1/reactions", "bob", nil, http.StatusUnprocessableEntity},
+		{"DELETE", "/api/messages/1/reactions?emoji=%F0%9F%91%8D", "bob", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rr, _ := send(tt.method, tt.path, tt.user, tt.body); rr.Code != tt.want {
+			t.Errorf("%s %s as %q: expected %v, got %v", tt.method, tt.path, tt.user, tt.want, rr.Code)
+		}
+	}
+
+	_, msg = send("GET", "/api/messages/1", "", nil)
+	if len(msg.Reactions) != 1 || msg.Reactions[0].Count != 1 {
+		t.Errorf("Expected alice's reaction to remain, got %+v", msg.Reactions)
+	}
+}
+
+// sseEvent is one parsed Server-Sent Event; comments are reported as event ":"
+type sseEvent struct {
+	id, event, data string
+}
+
+// readEvents parses an SSE stream into events until the body ends
+func readEvents(body io.Reader, events chan<- sseEvent) {
+	defer close(events)
+	scanner := bufio.NewScanner(body)
+	var current sseEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if current != (sseEvent{}) {
+				events <- current
+			}
+			current = sseEvent{}
+		case strings.HasPrefix(line, ":"):
+			current.event = ":"
+		default:
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				current.id = value
+			case "event":
+				current.event = value
+			case "data":
+				current.data = value
+			}
+		}
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("Stream ended")
+		}
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return sseEvent{}
+}
+
+func TestStreamMessages(t *testing.T) {
+	handler := setupTestHandler()
+	handler.heartbeat = 50 * time.Millisecond
+	done := make(chan struct{}, 2)
+	router := handler.SetupRoutes()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+		if r.URL.Path == "/api/messages/stream" {
+			done <- struct{}{}
+		}
+	}))
+	defer server.Close()
+
+	connect := func(lastEventID string) (*http.Response, <-chan sseEvent, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/messages/stream", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events := make(chan sseEvent, 16)
+		go readEvents(resp.Body, events)
+		return resp, events, cancel
+	}
+
+	resp, events, disconnect := connect("")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %q", ct)
+	}
+
+	msg, _ := handler.storage.Create("alice", "hello")
+	handler.storage.Update(msg.ID, "edited")
+	handler.storage.Delete(msg.ID)
+
+	want := []sseEvent{
+		{"1", "created", `"content":"hello"`},
+		{"2", "updated", `"content":"edited"`},
+		{"3", "deleted", `{"id":1}`},
+	}
+	for _, w := range want {
+		got := nextEvent(t, events)
+		if got.id != w.id || got.event != w.event || !strings.Contains(got.data, w.data) {
+			t.Errorf("Got event %+v, want %+v", got, w)
+		}
+	}
+	if got := nextEvent(t, events); got.event != ":" {
+		t.Errorf("Expected a heartbeat, got %+v", got)
+	}
+
+	// The handler returns once the client goes away
+	disconnect()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream handler did not return after the client disconnected")
+	}
+
+	// Resuming replays what was missed
+	_, events, disconnect = connect("1")
+	defer disconnect()
+	if got := nextEvent(t, events); got.id != "2" || got.event != "updated" {
+		t.Errorf("Expected to resume at event 2, got %+v", got)
+	}
+	if got := nextEvent(t, events); got.id != "3" {
+		t.Errorf("Expected event 3, got %+v", got)
+	}
+
+	// Closing the handler ends open streams
+	handler.Close()
+	for range events {
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream handler did not return after Close")
+	}
+}
+
+func TestStreamMessagesResetAndBadID(t *testing.T) {
+	router := setupTestHandler().SetupRoutes()
+
+	req := httptest.NewRequest("GET", "/api/messages/stream", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v for a malformed Last-Event-ID, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	// An id from before a restart asks the client to reload
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req = httptest.NewRequest("GET", "/api/messages/stream", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "42")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if !strings.HasPrefix(rr.Body.String(), "event: reset\n") {
+		t.Errorf("Expected a reset event, got %q", rr.Body.String())
+	}
+}
@@ -0,0 +1,407 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lab03-backend/auth"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export and import formats
+const (
+	formatJSONL = "jsonl" // One JSON message per line
+	formatCSV   = "csv"   // A header row naming csvColumns
+)
+
+// contentTypes maps each format to the media type it is sent as
+var contentTypes = map[string]string{
+	formatJSONL: "application/x-ndjson",
+	formatCSV:   "text/csv",
+}
+
+// csvColumns are written by export. Import needs username and content; the
+// other columns are optional and may come in any order.
+var csvColumns = []string{"id", "username", "content", "timestamp", "version", "edited_at", "parent_id"}
+
+// ExportMessages handles GET /api/messages/export, streaming every message
+// oldest first. ?format=csv or Accept: text/csv selects CSV; the default is
+// JSON Lines.
+func (h *Handler) ExportMessages(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatJSONL
+		if strings.Contains(r.Header.Get("Accept"), contentTypes[formatCSV]) {
+			format = formatCSV
+		}
+	}
+	if _, ok := contentTypes[format]; !ok {
+		h.writeError(w, http.StatusBadRequest, `format must be "jsonl" or "csv"`)
+		return
+	}
+
+	query := storage.Query{Limit: storage.MaxPageSize}
+	page, err := h.storage.Query(query)
+	if err != nil {
+		h.writeStorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="messages.`+format+`"`)
+	write := newMessageWriter(format, w)
+	controller := http.NewResponseController(w)
+	for {
+		for _, msg := range page.Messages {
+			if err := write(msg); err != nil {
+				return
+			}
+		}
+		controller.Flush()
+		if page.NextCursor == "" {
+			return
+		}
+
+		query.Cursor = page.NextCursor
+		if page, err = h.storage.Query(query); err != nil {
+			// Too late for an error status; the client sees a truncated file
+			log.Printf("export: %v", err)
+			return
+		}
+	}
+}
+
+// newMessageWriter returns a function writing one message in format to w
+func newMessageWriter(format string, w io.Writer) func(*models.Message) error {
+	if format == formatJSONL {
+		encoder := json.NewEncoder(w)
+		return func(msg *models.Message) error {
+			return encoder.Encode(msg)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	header := true
+	return func(msg *models.Message) error {
+		if header {
+			writer.Write(csvColumns)
+			header = false
+		}
+		editedAt := ""
+		if msg.EditedAt != nil {
+			editedAt = msg.EditedAt.Format(time.RFC3339Nano)
+		}
+		parentID := ""
+		if msg.ParentID != 0 {
+			parentID = strconv.Itoa(msg.ParentID)
+		}
+		writer.Write([]string{
+			strconv.Itoa(msg.ID), msg.Username, msg.Content, msg.Timestamp.Format(time.RFC3339Nano),
+			strconv.Itoa(msg.Version), editedAt, parentID,
+		})
+		writer.Flush()
+		return writer.Error()
+	}
+}
+
+// ImportMessages handles POST /api/messages/import for admins. The body is
+// JSON Lines or CSV as written by export, chosen by ?format= or the
+// Content-Type. Each line is validated and imported on its own, and the
+// response reports every line.
+//
+// Query parameters: dry_run=true validates without storing anything, and
+// ids=preserve keeps the IDs in the file instead of assigning new ones
+// (ids=renumber, the default). When renumbering, a parent_id must refer to a
+// message earlier in the file and is rewritten to its new ID.
+func (h *Handler) ImportMessages(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	if !caller.IsAdmin() {
+		h.writeError(w, http.StatusForbidden, "only an admin may import messages")
+		return
+	}
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format == "" {
+		format = formatJSONL
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == contentTypes[formatCSV] {
+			format = formatCSV
+		}
+	}
+	var report models.ImportReport
+	var err error
+	if v := params.Get("dry_run"); v != "" {
+		if report.DryRun, err = strconv.ParseBool(v); err != nil {
+			h.writeError(w, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+	switch params.Get("ids") {
+	case "", "renumber":
+	case "preserve":
+		report.PreserveIDs = true
+	default:
+		h.writeError(w, http.StatusBadRequest, `ids must be "preserve" or "renumber"`)
+		return
+	}
+
+	var next func() (int, models.Message, error)
+	switch format {
+	case formatJSONL:
+		next = jsonlRecords(r.Body)
+	case formatCSV:
+		if next, err = csvRecords(r.Body); err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		h.writeError(w, http.StatusBadRequest, `format must be "jsonl" or "csv"`)
+		return
+	}
+
+	importer := &importer{storage: h.storage, report: &report, newIDs: make(map[int]int)}
+	for {
+		line, msg, err := next()
+		if err == io.EOF {
+			break
+		}
+		importer.add(line, msg, err)
+	}
+	report.Results = importer.results
+	if report.Results == nil {
+		report.Results = []models.ImportResult{}
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: report})
+}
+
+// importer imports records one at a time, filling in the report
+type importer struct {
+	storage storage.MessageRepository
+	report  *models.ImportReport
+	results []models.ImportResult
+	// newIDs maps the IDs in the file to the stored IDs, 0 in a dry run
+	newIDs map[int]int
+}
+
+// add imports one record; err holds the problems found while parsing it
+func (im *importer) add(line int, msg models.Message, err error) {
+	result := models.ImportResult{Line: line, SourceID: msg.ID, Status: models.ImportInvalid}
+	defer func() {
+		if result.Status == models.ImportInvalid {
+			im.report.Invalid++
+		} else {
+			im.report.Imported++
+		}
+		im.results = append(im.results, result)
+	}()
+
+	if err != nil {
+		result.Errors = lineErrors(err)
+		return
+	}
+	if result.Errors = validateRecord(msg); result.Errors != nil {
+		return
+	}
+
+	sourceID := msg.ID
+	_, seen := im.newIDs[sourceID]
+	if im.report.PreserveIDs {
+		if sourceID != 0 && im.report.DryRun {
+			if _, err := im.storage.GetByID(sourceID); seen || err == nil {
+				result.Errors = duplicateID(sourceID)
+				return
+			}
+		}
+	} else {
+		msg.ID = 0
+		if sourceID != 0 && seen {
+			result.Errors = duplicateID(sourceID)
+			return
+		}
+		if msg.ParentID != 0 {
+			parentID, ok := im.newIDs[msg.ParentID]
+			if !ok {
+				result.Errors = models.ValidationErrors{models.NewFieldError("parent_id", models.CodeInvalid,
+					fmt.Sprintf("parent_id %d is not an earlier message in the import", msg.ParentID))}
+				return
+			}
+			msg.ParentID = parentID
+		}
+	}
+
+	if im.report.DryRun {
+		result.Status = models.ImportValid
+		if im.report.PreserveIDs {
+			result.ID = sourceID
+		}
+	} else {
+		imported, err := im.storage.Import(msg)
+		if errors.Is(err, storage.ErrDuplicateID) {
+			result.Errors = duplicateID(sourceID)
+			return
+		}
+		if err != nil {
+			log.Printf("import line %d: %v", line, err)
+			result.Errors = models.ValidationErrors{models.NewFieldError("", models.CodeInvalid, "the message could not be stored")}
+			return
+		}
+		result.Status = models.ImportImported
+		result.ID = imported.ID
+	}
+	if sourceID != 0 {
+		im.newIDs[sourceID] = result.ID
+	}
+}
+
+// validateRecord applies the rules for new messages to an imported one
+func validateRecord(msg models.Message) models.ValidationErrors {
+	var errs models.ValidationErrors
+	req := models.CreateMessageRequest{Username: msg.Username, Content: msg.Content, ParentID: msg.ParentID}
+	if fields, ok := models.AsValidationErrors(req.Validate()); ok {
+		errs = append(errs, fields...)
+	}
+	if msg.ID < 0 {
+		errs = append(errs, models.NewFieldError("id", models.CodeInvalid, "id must not be negative"))
+	}
+	if msg.Version < 0 {
+		errs = append(errs, models.NewFieldError("version", models.CodeInvalid, "version must not be negative"))
+	}
+	return errs
+}
+
+func duplicateID(id int) models.ValidationErrors {
+	return models.ValidationErrors{models.NewFieldError("id", models.CodeDuplicate, fmt.Sprintf("message %d already exists", id))}
+}
+
+// lineErrors describes why a line could not be parsed
+func lineErrors(err error) models.ValidationErrors {
+	if fields, ok := models.AsValidationErrors(err); ok {
+		return fields
+	}
+	return models.ValidationErrors{models.NewFieldError("", models.CodeMalformed, err.Error())}
+}
+
+// jsonlRecords reads one message per non-blank line of body. It returns the
+// line number, the message and its parse error, or io.EOF at the end.
+func jsonlRecords(body io.Reader) func() (int, models.Message, error) {
+	reader := bufio.NewReader(body)
+	line := 0
+	return func() (int, models.Message, error) {
+		for {
+			data, err := reader.ReadBytes('\n')
+			if len(data) == 0 && err != nil {
+				if err != io.EOF {
+					log.Printf("import: %v", err)
+				}
+				return 0, models.Message{}, io.EOF
+			}
+			line++
+			if data = bytes.TrimSpace(data); len(data) == 0 {
+				continue
+			}
+			var msg models.Message
+			err = decodeStrict(data, &msg)
+			return line, msg, err
+		}
+	}
+}
+
+// csvRecords reads the header row of body and returns a function reading
+// the following rows like jsonlRecords. It fails for a header without the
+// required columns or with unknown ones.
+func csvRecords(body io.Reader) (func() (int, models.Message, error), error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(csvColumns, name) {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, required := range []string{"username", "content"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV column %q is required", required)
+		}
+	}
+
+	return func() (int, models.Message, error) {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return 0, models.Message{}, io.EOF
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				line = parseErr.StartLine
+				err = parseErr.Err
+			}
+			return line, models.Message{}, err
+		}
+		if len(record) != len(header) {
+			return line, models.Message{}, fmt.Errorf("expected %d fields, got %d", len(header), len(record))
+		}
+		msg, err := parseCSVRecord(record, columns)
+		return line, msg, err
+	}, nil
+}
+
+// parseCSVRecord converts one CSV row into a message
+func parseCSVRecord(record []string, columns map[string]int) (models.Message, error) {
+	value := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+	msg := models.Message{Username: value("username"), Content: value("content")}
+	var errs models.ValidationErrors
+
+	for name, dst := range map[string]*int{"id": &msg.ID, "version": &msg.Version, "parent_id": &msg.ParentID} {
+		if v := value(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, models.NewFieldError(name, models.CodeInvalidType, name+" must be an integer"))
+			}
+			*dst = n
+		}
+	}
+	if v := value("timestamp"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			errs = append(errs, models.NewFieldError("timestamp", models.CodeInvalidType, "timestamp must be an RFC 3339 timestamp"))
+		}
+		msg.Timestamp = t
+	}
+	if v := value("edited_at"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			errs = append(errs, models.NewFieldError("edited_at", models.CodeInvalidType, "edited_at must be an RFC 3339 timestamp"))
+		}
+		msg.EditedAt = &t
+	}
+
+	if errs != nil {
+		slices.SortFunc(errs, func(a, b models.FieldError) int { return strings.Compare(a.Field, b.Field) })
+		return msg, errs
+	}
+	return msg, nil
+}
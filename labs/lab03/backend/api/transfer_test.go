@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"lab03-backend/auth"
+	"lab03-backend/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// importMessages posts body to the import endpoint as an admin
+func importMessages(t *testing.T, router http.Handler, query, contentType, body string) (int, models.ImportReport) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/messages/import?"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", bearer("root", auth.RoleAdmin))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var response struct {
+		Data models.ImportReport `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&response)
+	return rr.Code, response.Data
+}
+
+func exportMessages(router http.Handler, format string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/messages/export?format="+format, nil))
+	return rr
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{"jsonl", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			source := setupTestHandler()
+			parent, _ := source.storage.Create("alice", "question, with \"quotes\"\nand a newline")
+			source.storage.Update(parent.ID, "edited, question")
+			source.storage.Reply(parent.ID, "bob", "answer")
+			source.storage.Delete(parent.ID + 1) // Leave a gap in the IDs
+			source.storage.Reply(parent.ID, "bob", "second answer")
+
+			rr := exportMessages(source.SetupRoutes(), format)
+			if rr.Code != http.StatusOK || rr.Header().Get("Content-Disposition") != `attachment; filename="messages.`+format+`"` {
+				t.Fatalf("Unexpected export response %v %v", rr.Code, rr.Header())
+			}
+
+			target := setupTestHandler()
+			target.storage.Create("carol", "already here")
+			code, report := importMessages(t, target.SetupRoutes(), "", rr.Header().Get("Content-Type"), rr.Body.String())
+			if code != http.StatusOK || report.Imported != 2 || report.Invalid != 0 {
+				t.Fatalf("Unexpected report %v %+v", code, report)
+			}
+
+			// Renumbered after the existing message, with the reply pointing
+			// to the new ID of its parent
+			imported, _ := target.storage.GetByID(2)
+			reply, _ := target.storage.GetByID(3)
+			if imported.Content != "edited, question" || imported.Version != 2 || imported.EditedAt == nil || imported.ReplyCount != 1 {
+				t.Errorf("Unexpected imported parent %+v", imported)
+			}
+			if reply.ParentID != 2 || reply.Content != "second answer" || report.Results[1].SourceID != 3 {
+				t.Errorf("Unexpected imported reply %+v, report %+v", reply, report)
+			}
+			original, _ := source.storage.GetByID(parent.ID)
+			if !imported.Timestamp.Equal(original.Timestamp) {
+				t.Errorf("Timestamp changed from %v to %v", original.Timestamp, imported.Timestamp)
+			}
+		})
+	}
+}
+
+func TestImportOptions(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("alice", "first")
+
+	body := strings.Join([]string{
+		`{"id": 1, "username": "bob", "content": "taken"}`,
+		`{"id": 5, "username": "bob", "content": "kept"}`,
+		``,
+		`{"id": 6, "username": "bad name", "content": ""}`,
+		`{"id": 7, "username": "bob", "content": "reply", "parent_id": 5}`,
+		`not json`,
+		`{"id": 8, "username": "bob", "content": "hi", "colour": "red"}`,
+	}, "\n")
+
+	code, report := importMessages(t, router, "ids=preserve&dry_run=true", "application/x-ndjson", body)
+	if code != http.StatusOK || !report.DryRun || report.Imported != 2 || report.Invalid != 4 {
+		t.Fatalf("Unexpected dry run report %v %+v", code, report)
+	}
+	if n, _ := handler.storage.Total(); n != 1 {
+		t.Errorf("Dry run stored messages, total %d", n)
+	}
+
+	want := []struct {
+		line   int
+		status string
+		codes  string
+	}{
+		{1, models.ImportInvalid, "id duplicate"},
+		{2, models.ImportImported, ""},
+		{4, models.ImportInvalid, "username invalid, content required"},
+		{5, models.ImportImported, ""},
+		{6, models.ImportInvalid, " malformed"},
+		{7, models.ImportInvalid, "colour unknown_field"},
+	}
+	_, report = importMessages(t, router, "ids=preserve", "application/x-ndjson", body)
+	if len(report.Results) != len(want) {
+		t.Fatalf("Unexpected results %+v", report.Results)
+	}
+	for i, w := range want {
+		result := report.Results[i]
+		var codes []string
+		for _, e := range result.Errors {
+			codes = append(codes, e.Field+" "+e.Code)
+		}
+		if result.Line != w.line || result.Status != w.status || strings.Join(codes, ", ") != w.codes {
+			t.Errorf("Result %d is %+v, want %+v", i, result, w)
+		}
+	}
+	if msg, err := handler.storage.GetByID(7); err != nil || msg.ParentID != 5 {
+		t.Errorf("Expected message 7 to keep its ID and parent, got %+v, %v", msg, err)
+	}
+
+	// Renumbering needs parents earlier in the file
+	_, report = importMessages(t, router, "", "application/x-ndjson", `{"id": 3, "username": "bob", "content": "reply", "parent_id": 99}`)
+	if report.Invalid != 1 || report.Results[0].Errors[0].Field != "parent_id" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
+
+func TestImportIgnoresIdempotencyKey(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	// Larger than the replay store buffers
+	line := `{"username": "bob", "content": "` + strings.Repeat("x", 900) + `"}` + "\n"
+	body := strings.Repeat(line, 2000)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/api/messages/import", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.Header.Set("Authorization", bearer("root", auth.RoleAdmin))
+		req.Header.Set("Idempotency-Key", "import-1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("Import %d: unexpected response %v %v", i+1, rr.Code, rr.Header())
+		}
+	}
+	if n, _ := handler.storage.Total(); n != 4000 {
+		t.Errorf("Expected both imports to run, have %d messages", n)
+	}
+}
+
+func TestImportRejectedRequests(t *testing.T) {
+	router := setupTestHandler().SetupRoutes()
+
+	tests := []struct {
+		name, query, contentType, body, authorization string
+		want                                          int
+	}{
+		{"not an admin", "", "application/x-ndjson", "", bearer("alice", auth.RoleUser), http.StatusForbidden},
+		{"anonymous", "", "application/x-ndjson", "", "", http.StatusUnauthorized},
+		{"unknown column", "", "text/csv", "username,content,mood\n", bearer("root", auth.RoleAdmin), http.StatusBadRequest},
+		{"missing column", "", "text/csv", "id,username\n", bearer("root", auth.RoleAdmin), http.StatusBadRequest},
+		{"bad ids", "ids=keep", "application/x-ndjson", "", bearer("root", auth.RoleAdmin), http.StatusBadRequest},
+		{"bad format", "format=xml", "application/x-ndjson", "", bearer("root", auth.RoleAdmin), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/messages/import?"+tt.query, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, rr.Code)
+		}
+	}
+
+	if rr := exportMessages(router, "xml"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v for an unknown export format, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestImportCSVErrors(t *testing.T) {
+	router := setupTestHandler().SetupRoutes()
+	body := "content,username,id,timestamp\n" +
+		"hello,alice,x,yesterday\n" +
+		"\"multi\nline\",bob,,\n" +
+		"too,few\n"
+	_, report := importMessages(t, router, "", "text/csv", body)
+	if report.Imported != 1 || report.Invalid != 2 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if r := report.Results[0]; r.Line != 2 || len(r.Errors) != 2 || r.Errors[0].Field != "id" || r.Errors[1].Field != "timestamp" {
+		t.Errorf("Unexpected first result %+v", r)
+	}
+	if r := report.Results[2]; r.Line != 5 || r.Errors[0].Code != models.CodeMalformed {
+		t.Errorf("Unexpected last result %+v", r)
+	}
+}
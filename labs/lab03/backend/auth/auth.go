@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Errors returned by TokenService
+var (
+	ErrEmptySecret  = errors.New("token secret cannot be empty")
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrInvalidRole  = errors.New("invalid role")
+)
+
+// Role grants permissions beyond owning one's messages
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin" // May edit and delete any message
+)
+
+// DefaultTokenTTL is how long issued tokens stay valid
+const DefaultTokenTTL = 24 * time.Hour
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Username string
+	Role     Role
+}
+
+// IsAdmin reports whether the caller has the admin role
+func (i Identity) IsAdmin() bool {
+	return i.Role == RoleAdmin
+}
+
+// claims are the JWT claims; the username is the subject
+type claims struct {
+	Role Role `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenService issues and verifies HS256 signed bearer tokens
+type TokenService struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewTokenService creates a TokenService signing with secret
+func NewTokenService(secret string) (*TokenService, error) {
+	if secret == "" {
+		return nil, ErrEmptySecret
+	}
+	return &TokenService{secret: []byte(secret), ttl: DefaultTokenTTL, now: time.Now}, nil
+}
+
+// Issue creates a token for id that expires after DefaultTokenTTL
+func (s *TokenService) Issue(id Identity) (string, error) {
+	if strings.TrimSpace(id.Username) == "" {
+		return "", fmt.Errorf("%w: username is required", ErrInvalidToken)
+	}
+	switch id.Role {
+	case "":
+		id.Role = RoleUser
+	case RoleUser, RoleAdmin:
+	default:
+		return "", ErrInvalidRole
+	}
+
+	now := s.now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		Role: id.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   id.Username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.ttl)),
+		},
+	})
+	return token.SignedString(s.secret)
+}
+
+// Verify checks a token's signature and expiry and returns its identity
+func (s *TokenService) Verify(token string) (Identity, error) {
+	if token == "" {
+		return Identity{}, ErrMissingToken
+	}
+
+	var c claims
+	// Expiry is checked below against s.now rather than by the parser
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}, SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(token, &c, func(*jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}); err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if c.ExpiresAt == nil || !s.now().Before(c.ExpiresAt.Time) {
+		return Identity{}, fmt.Errorf("%w: token is expired", ErrInvalidToken)
+	}
+	if c.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: token has no subject", ErrInvalidToken)
+	}
+	if c.Role != RoleUser && c.Role != RoleAdmin {
+		return Identity{}, ErrInvalidRole
+	}
+	return Identity{Username: c.Subject, Role: c.Role}, nil
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer <token>"
+// header value, returning "" when there is none
+func BearerToken(header string) string {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a context carrying id
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity stored by WithIdentity
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestTokenRoundTrip(t *testing.T) {
+	service, err := NewTokenService("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := service.Issue(Identity{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	id, err := service.Verify(token)
+	if err != nil || id != (Identity{Username: "alice", Role: RoleUser}) {
+		t.Errorf("Got %+v, %v; want alice with the user role", id, err)
+	}
+
+	admin, _ := service.Issue(Identity{Username: "root", Role: RoleAdmin})
+	if id, _ := service.Verify(admin); !id.IsAdmin() {
+		t.Errorf("Expected an admin identity, got %+v", id)
+	}
+}
+
+func TestTokenRejected(t *testing.T) {
+	service, _ := NewTokenService("secret")
+	other, _ := NewTokenService("other secret")
+	token, _ := service.Issue(Identity{Username: "alice"})
+	forged, _ := other.Issue(Identity{Username: "alice", Role: RoleAdmin})
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims{
+		Role:             RoleAdmin,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "alice", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	for name, candidate := range map[string]string{"other secret": forged, "alg none": unsigned, "garbage": "a.b.c"} {
+		if _, err := service.Verify(candidate); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+	if _, err := service.Verify(""); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("Expected ErrMissingToken, got %v", err)
+	}
+
+	service.now = func() time.Time { return time.Now().Add(DefaultTokenTTL + time.Minute) }
+	if _, err := service.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an expired token to be rejected, got %v", err)
+	}
+}
+
+func TestIssueValidation(t *testing.T) {
+	if _, err := NewTokenService(""); !errors.Is(err, ErrEmptySecret) {
+		t.Errorf("Expected ErrEmptySecret, got %v", err)
+	}
+	service, _ := NewTokenService("secret")
+	if _, err := service.Issue(Identity{Username: " "}); err == nil {
+		t.Error("Expected an error for an empty username")
+	}
+	if _, err := service.Issue(Identity{Username: "alice", Role: "owner"}); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("Expected ErrInvalidRole, got %v", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := map[string]string{
+		"Bearer abc":   "abc",
+		"bearer  abc ": "abc",
+		"Basic abc":    "",
+		"abc":          "",
+		"":             "",
+	}
+	for header, want := range tests {
+		if got := BearerToken(header); got != want {
+			t.Errorf("BearerToken(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -1,5 +1,17 @@
 module lab03-backend
 
-go 1.24
+go 1.24.3
 
-require github.com/gorilla/mux v1.8.0
+require (
+	github.com/gorilla/mux v1.8.0
+	github.com/lib/pq v1.12.3
+	github.com/mattn/go-sqlite3 v1.14.22
+)
+
+require github.com/golang-jwt/jwt/v4 v4.5.0
+
+// The idempotency store lives in the course backend so its Docker build
+// context stays self-contained
+require github.com/timur-harin/sum25-go-flutter-course/backend v0.0.0
+
+replace github.com/timur-harin/sum25-go-flutter-course/backend => ../../../backend
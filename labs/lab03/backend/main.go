@@ -1,21 +1,120 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"lab03-backend/api"
+	"lab03-backend/auth"
+	"lab03-backend/storage"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// storageConfig reads the storage backend from the environment:
+// STORAGE_DRIVER is "memory" (default), "sqlite" or "postgres" and
+// STORAGE_DSN is the SQLite file or the Postgres connection string
+func storageConfig() storage.Config {
+	config := storage.DefaultConfig()
+	if driver := os.Getenv("STORAGE_DRIVER"); driver != "" {
+		config.Driver = driver
+	}
+	config.DSN = os.Getenv("STORAGE_DSN")
+	return config
+}
+
+// tokenService signs with AUTH_SECRET. Without it a random secret is used, so
+// tokens only work until the server restarts and cannot be issued by "token".
+func tokenService() *auth.TokenService {
+	secret := os.Getenv("AUTH_SECRET")
+	if secret == "" {
+		log.Println("AUTH_SECRET is not set; using a random secret, so no tokens can be issued for this server")
+		random := make([]byte, 32)
+		rand.Read(random)
+		secret = hex.EncodeToString(random)
+	}
+	tokens, err := auth.NewTokenService(secret)
+	if err != nil {
+		log.Fatalf("Failed to create token service: %v", err)
+	}
+	return tokens
+}
+
+// issueToken implements "main token -user NAME [-role admin]", printing a
+// bearer token signed with AUTH_SECRET
+func issueToken(args []string) {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	user := flags.String("user", "", "username the token authenticates")
+	role := flags.String("role", string(auth.RoleUser), `"user" or "admin"`)
+	flags.Parse(args)
+
+	secret := os.Getenv("AUTH_SECRET")
+	if secret == "" {
+		log.Fatal("AUTH_SECRET must be set to issue tokens")
+	}
+	tokens, err := auth.NewTokenService(secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, err := tokens.Issue(auth.Identity{Username: *user, Role: auth.Role(*role)})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(token)
+}
+
 func main() {
-	// TODO: Create a new memory storage instance
-	// TODO: Create a new API handler with the storage
-	// TODO: Setup routes using the handler
-	// TODO: Configure server with:
-	//   - Address: ":8080"
-	//   - Handler: the router
-	//   - ReadTimeout: 15 seconds
-	//   - WriteTimeout: 15 seconds
-	//   - IdleTimeout: 60 seconds
-	// TODO: Add logging to show server is starting
-	// TODO: Start the server and handle any errors
-
-	log.Println("TODO: Implement main function")
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		issueToken(os.Args[2:])
+		return
+	}
+
+	config := storageConfig()
+	repo, err := storage.Open(config)
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", config.Driver, err)
+	}
+	defer repo.Close()
+
+	handler := api.NewHandlerWithAuth(repo, tokenService())
+	// STATUS_IMAGES=local serves the embedded cats instead of linking http.cat
+	if os.Getenv("STATUS_IMAGES") == "local" {
+		handler.UseLocalImages()
+	}
+	server := &http.Server{
+		Addr:         ":8080",
+		Handler:      handler.SetupRoutes(),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	// Open event streams would otherwise keep Shutdown waiting
+	server.RegisterOnShutdown(handler.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		log.Println("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("Starting server on %s with %s storage", server.Addr, config.Driver)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("Server failed: %v", err)
+	}
+	<-stopped
 }
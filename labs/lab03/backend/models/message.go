@@ -1,57 +1,228 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits enforced by Validate
+const (
+	MaxContentLength  = 2000 // In characters
+	MaxUsernameLength = 32
+	// maxEmojiRunes bounds emoji sequences; family and flag sequences joined
+	// with U+200D are the longest in use
+	maxEmojiRunes = 16
+)
+
+// Validation errors
+var (
+	ErrUsernameRequired = errors.New("username is required")
+	ErrUsernameTooLong  = fmt.Errorf("username must be at most %d characters", MaxUsernameLength)
+	ErrUsernameInvalid  = errors.New("username may only contain letters, digits, '.', '_' and '-'")
+	ErrContentRequired  = errors.New("content is required")
+	ErrContentTooLong   = fmt.Errorf("content must be at most %d characters", MaxContentLength)
+	ErrInvalidParentID  = errors.New("parent_id must be a message id")
+	ErrInvalidEmoji     = errors.New("emoji must be a single emoji")
+)
+
 // Message represents a chat message
+// Version starts at 1 and increases with every edit; EditedAt is nil until
+// the first edit. ParentID is 0 unless the message is a reply. ReplyCount and
+// Reactions are computed by the storage when the message is read.
 type Message struct {
-	// TODO: Add ID field of type int with json tag "id"
-	// TODO: Add Username field of type string with json tag "username"
-	// TODO: Add Content field of type string with json tag "content"
-	// TODO: Add Timestamp field of type time.Time with json tag "timestamp"
+	ID         int             `json:"id"`
+	Username   string          `json:"username"`
+	Content    string          `json:"content"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Version    int             `json:"version"`
+	EditedAt   *time.Time      `json:"edited_at,omitempty"`
+	ParentID   int             `json:"parent_id,omitempty"`
+	ReplyCount int             `json:"reply_count"`
+	Reactions  []ReactionCount `json:"reactions,omitempty"` // Most used first
+}
+
+// ReactionCount is how many users reacted to a message with Emoji
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// Revision is one version of a message's content
+type Revision struct {
+	Version   int       `json:"version"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"` // When this version was written
 }
 
 // CreateMessageRequest represents the request to create a new message
+// A non-zero ParentID makes the message a reply to that message
 type CreateMessageRequest struct {
-	// TODO: Add Username field of type string with json tag "username" and validation tag "required"
-	// TODO: Add Content field of type string with json tag "content" and validation tag "required"
+	Username string `json:"username" validate:"required"`
+	Content  string `json:"content" validate:"required"`
+	ParentID int    `json:"parent_id,omitempty"`
 }
 
 // UpdateMessageRequest represents the request to update a message
 type UpdateMessageRequest struct {
-	// TODO: Add Content field of type string with json tag "content" and validation tag "required"
+	Content string `json:"content" validate:"required"`
+}
+
+// ReactionRequest represents the request to add or remove a reaction
+type ReactionRequest struct {
+	Emoji string `json:"emoji" validate:"required"`
 }
 
 // HTTPStatusResponse represents the response for HTTP status code endpoint
+// Name is the reason phrase; RFC is empty for unassigned codes.
 type HTTPStatusResponse struct {
-	// TODO: Add StatusCode field of type int with json tag "status_code"
-	// TODO: Add ImageURL field of type string with json tag "image_url"
-	// TODO: Add Description field of type string with json tag "description"
+	StatusCode  int    `json:"status_code"`
+	Name        string `json:"name"`
+	Class       string `json:"class"`
+	RFC         string `json:"rfc,omitempty"`
+	ImageURL    string `json:"image_url"`
+	Description string `json:"description"`
+	Cacheable   bool   `json:"cacheable"`
+	Retryable   bool   `json:"retryable"`
 }
 
 // APIResponse represents a generic API response
 type APIResponse struct {
-	// TODO: Add Success field of type bool with json tag "success"
-	// TODO: Add Data field of type interface{} with json tag "data,omitempty"
-	// TODO: Add Error field of type string with json tag "error,omitempty"
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Meta    *PageMeta   `json:"meta,omitempty"`
+	// Errors lists the invalid fields of a 422 response
+	Errors ValidationErrors `json:"errors,omitempty"`
+}
+
+// PageMeta describes a page of a paginated listing
+// NextCursor and Next are empty on the last page
+type PageMeta struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Next       string `json:"next,omitempty"` // URL of the next page, also sent as a Link header
+}
+
+// Import statuses of a line
+const (
+	ImportImported = "imported"
+	ImportValid    = "valid" // Would be imported, in a dry run
+	ImportInvalid  = "invalid"
+)
+
+// ImportResult is the outcome of importing one line
+// SourceID is the ID in the imported file; ID is the stored ID, or 0 when
+// nothing was stored.
+type ImportResult struct {
+	Line     int              `json:"line"`
+	SourceID int              `json:"source_id,omitempty"`
+	ID       int              `json:"id,omitempty"`
+	Status   string           `json:"status"`
+	Errors   ValidationErrors `json:"errors,omitempty"`
+}
+
+// ImportReport summarises an import; lines are imported independently, so
+// invalid lines do not stop the others
+type ImportReport struct {
+	DryRun      bool           `json:"dry_run"`
+	PreserveIDs bool           `json:"preserve_ids"`
+	Imported    int            `json:"imported"` // Lines imported, or that would be in a dry run
+	Invalid     int            `json:"invalid"`
+	Results     []ImportResult `json:"results"`
 }
 
 // NewMessage creates a new message with the current timestamp
 func NewMessage(id int, username, content string) *Message {
-	// TODO: Return a new Message instance with provided parameters and current timestamp
-	return nil
+	return &Message{
+		ID:        id,
+		Username:  username,
+		Content:   content,
+		Timestamp: time.Now(),
+		Version:   1,
+	}
 }
 
-// Validate checks if the create message request is valid
+// Validate checks if the create message request is valid, returning
+// ValidationErrors listing every invalid field
 func (r *CreateMessageRequest) Validate() error {
-	// TODO: Implement validation logic
-	// Check if Username is not empty
-	// Check if Content is not empty
-	// Return appropriate error messages
-	return nil
+	var v validator
+	validateUsername(&v, r.Username)
+	validateContent(&v, r.Content)
+	v.check(r.ParentID >= 0, "parent_id", CodeInvalid, ErrInvalidParentID)
+	return v.err()
 }
 
-// Validate checks if the update message request is valid
+// Validate checks if the update message request is valid, returning
+// ValidationErrors listing every invalid field
 func (r *UpdateMessageRequest) Validate() error {
-	// TODO: Implement validation logic
-	// Check if Content is not empty
-	// Return appropriate error messages
-	return nil
+	var v validator
+	validateContent(&v, r.Content)
+	return v.err()
+}
+
+// Validate checks that Emoji is one emoji, possibly with modifiers, variation
+// selectors or zero-width joiners
+func (r *ReactionRequest) Validate() error {
+	var v validator
+	if r.Emoji == "" {
+		v.check(false, "emoji", CodeRequired, ErrInvalidEmoji)
+	} else {
+		v.check(isEmoji(r.Emoji), "emoji", CodeInvalid, ErrInvalidEmoji)
+	}
+	return v.err()
+}
+
+func validateUsername(v *validator, username string) {
+	if strings.TrimSpace(username) == "" {
+		v.check(false, "username", CodeRequired, ErrUsernameRequired)
+		return
+	}
+	v.check(utf8.RuneCountInString(username) <= MaxUsernameLength, "username", CodeTooLong, ErrUsernameTooLong)
+	v.check(!strings.ContainsFunc(username, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune("._-", c)
+	}), "username", CodeInvalid, ErrUsernameInvalid)
+}
+
+func validateContent(v *validator, content string) {
+	v.check(strings.TrimSpace(content) != "", "content", CodeRequired, ErrContentRequired)
+	v.check(utf8.RuneCountInString(content) <= MaxContentLength, "content", CodeTooLong, ErrContentTooLong)
+}
+
+// isEmoji reports whether s is one emoji
+func isEmoji(s string) bool {
+	runes := []rune(s)
+	if len(runes) == 0 || len(runes) > maxEmojiRunes {
+		return false
+	}
+	// Symbols start a new emoji unless joined to the previous one, or
+	// completing a flag of two regional indicators
+	emojis := 0
+	joined, flagStarted := false, false
+	for i, c := range runes {
+		switch {
+		case unicode.Is(unicode.So, c):
+			regional := c >= '\U0001F1E6' && c <= '\U0001F1FF'
+			if !joined && !(regional && flagStarted) {
+				emojis++
+			}
+			flagStarted = regional && !flagStarted
+			joined = false
+			continue
+		case c == '\u200d':
+			joined = true
+			continue
+		case unicode.In(c, unicode.Sk, unicode.Mn, unicode.Me):
+			// Skin tones, variation selectors and keycaps
+		case strings.ContainsRune("0123456789#*", c) && i+1 < len(runes) && strings.ContainsRune("\ufe0f\u20e3", runes[i+1]):
+			// Keycap base, as in "1\ufe0f\u20e3"
+		default:
+			return false
+		}
+		flagStarted = false
+	}
+	return emojis == 1 || (emojis == 0 && strings.ContainsRune(s, '\u20e3'))
 }
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -123,3 +125,92 @@ func TestUpdateMessageRequestValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestReactionRequestValidation(t *testing.T) {
+	tests := []struct {
+		emoji     string
+		shouldErr bool
+	}{
+		{"👍", false},
+		{"👍🏽", false},
+		{"❤️", false},
+		{"👩‍👩‍👧", false},
+		{"1️⃣", false},
+		{"🇳🇱", false},
+		{"🇳🇱🇧🇪", true},
+		{"", true},
+		{"a", true},
+		{"1", true},
+		{"1👍", true},
+		{"👍👎", true},
+		{"👍 ", true},
+		{"<script>", true},
+	}
+
+	for _, tt := range tests {
+		err := (&ReactionRequest{Emoji: tt.emoji}).Validate()
+		if tt.shouldErr && err == nil {
+			t.Errorf("%q: expected validation error, got nil", tt.emoji)
+		}
+		if !tt.shouldErr && err != nil {
+			t.Errorf("%q: expected no validation error, got: %v", tt.emoji, err)
+		}
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	req := CreateMessageRequest{
+		Username: "bad name!",
+		Content:  strings.Repeat("x", MaxContentLength+1),
+		ParentID: -1,
+	}
+	err := req.Validate()
+	fields, ok := AsValidationErrors(err)
+	if !ok {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+
+	var got []string
+	for _, f := range fields {
+		got = append(got, f.Field+" "+f.Code)
+	}
+	want := []string{"username invalid", "content too_long", "parent_id invalid"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Got field errors %q, want %q", got, want)
+	}
+	for _, sentinel := range []error{ErrUsernameInvalid, ErrContentTooLong, ErrInvalidParentID} {
+		if !errors.Is(err, sentinel) {
+			t.Errorf("Expected errors.Is(err, %v)", sentinel)
+		}
+	}
+	if errors.Is(err, ErrUsernameRequired) {
+		t.Error("Did not expect ErrUsernameRequired")
+	}
+}
+
+func TestUsernameRules(t *testing.T) {
+	tests := []struct {
+		username string
+		code     string
+	}{
+		{"alice", ""},
+		{"Jöns_Jacob-1.0", ""},
+		{"   ", CodeRequired},
+		{strings.Repeat("a", MaxUsernameLength), ""},
+		{strings.Repeat("a", MaxUsernameLength+1), CodeTooLong},
+		{"alice smith", CodeInvalid},
+		{"<script>", CodeInvalid},
+	}
+
+	for _, tt := range tests {
+		err := (&CreateMessageRequest{Username: tt.username, Content: "hi"}).Validate()
+		fields, _ := AsValidationErrors(err)
+		code := ""
+		if len(fields) > 0 {
+			code = fields[0].Code
+		}
+		if code != tt.code {
+			t.Errorf("%q: got code %q, want %q (%v)", tt.username, code, tt.code, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// Codes identifying why a field is invalid
+const (
+	CodeRequired     = "required"
+	CodeTooLong      = "too_long"
+	CodeInvalid      = "invalid"
+	CodeUnknownField = "unknown_field"
+	CodeInvalidType  = "invalid_type"
+	CodeDuplicate    = "duplicate"
+	CodeMalformed    = "malformed" // The record could not be parsed; Field is empty
+)
+
+// FieldError describes one invalid field of a request; Message names the
+// field, so it can be shown on its own
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	err     error
+}
+
+// Error implements the error interface
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the sentinel error the field failed with, if any
+func (e FieldError) Unwrap() error {
+	return e.err
+}
+
+// ValidationErrors is every problem found in a request. Validate methods
+// return it so clients can fix all fields at once; errors.Is matches the
+// sentinel errors of the individual fields.
+type ValidationErrors []FieldError
+
+// Error implements the error interface
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the field errors
+func (v ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(v))
+	for i, e := range v {
+		errs[i] = e
+	}
+	return errs
+}
+
+// validator collects the field errors of one request
+type validator struct {
+	errs ValidationErrors
+}
+
+// check records err for field unless ok
+func (v *validator) check(ok bool, field, code string, err error) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: field, Code: code, Message: err.Error(), err: err})
+	}
+}
+
+// err returns the collected errors, or nil when there are none
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// AsValidationErrors returns the field errors in err, if it holds any
+func AsValidationErrors(err error) (ValidationErrors, bool) {
+	var v ValidationErrors
+	if errors.As(err, &v) {
+		return v, true
+	}
+	var e FieldError
+	if errors.As(err, &e) {
+		return ValidationErrors{e}, true
+	}
+	return nil, false
+}
+
+// NewFieldError creates a FieldError for a problem found outside Validate,
+// such as while decoding the request
+func NewFieldError(field, code, message string) FieldError {
+	return FieldError{Field: field, Code: code, Message: message}
+}
@@ -0,0 +1,116 @@
+// Package status describes the HTTP status codes in the IANA registry
+package status
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Class groups status codes by their first digit
+type Class string
+
+const (
+	Informational Class = "informational"
+	Success       Class = "success"
+	Redirection   Class = "redirection"
+	ClientError   Class = "client_error"
+	ServerError   Class = "server_error"
+)
+
+// ClassOf returns the class of code, or "" outside 100-599
+func ClassOf(code int) Class {
+	switch code / 100 {
+	case 1:
+		return Informational
+	case 2:
+		return Success
+	case 3:
+		return Redirection
+	case 4:
+		return ClientError
+	case 5:
+		return ServerError
+	}
+	return ""
+}
+
+// classes are in order of their first digit
+var classes = []Class{Informational, Success, Redirection, ClientError, ServerError}
+
+// ParseClass accepts a Class name or its digit form, such as "4xx"
+func ParseClass(s string) (Class, bool) {
+	s = strings.ToLower(s)
+	for i, c := range classes {
+		if s == string(c) || s == strconv.Itoa(i+1)+"xx" {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// Status describes one status code
+// Cacheable codes may be stored by caches without explicit freshness
+// information (RFC 9110, Section 15.1). Retryable codes report a condition
+// that may clear, so repeating the same request later can succeed.
+type Status struct {
+	Code        int
+	Name        string // Reason phrase
+	Class       Class
+	RFC         string // Defining specification
+	Description string
+	Cacheable   bool
+	Retryable   bool
+}
+
+// UnknownName is the name of codes missing from the registry
+const UnknownName = "Unknown Status"
+
+// Lookup returns the registered status code, or false for unassigned codes
+func Lookup(code int) (Status, bool) {
+	i := sort.Search(len(catalog), func(i int) bool { return catalog[i].Code >= code })
+	if i < len(catalog) && catalog[i].Code == code {
+		return catalog[i], true
+	}
+	return Status{}, false
+}
+
+// Describe returns the registered status code, or a generic description of
+// an unassigned one
+func Describe(code int) Status {
+	if s, ok := Lookup(code); ok {
+		return s
+	}
+	return Status{
+		Code:        code,
+		Name:        UnknownName,
+		Class:       ClassOf(code),
+		Description: "This code is not registered with IANA.",
+	}
+}
+
+// Filter selects status codes; empty fields do not filter
+type Filter struct {
+	Class     Class
+	Cacheable *bool
+	Retryable *bool
+	Search    string // Case-insensitive match on the name and description
+}
+
+// List returns the registered status codes matching f, in code order
+func List(f Filter) []Status {
+	search := strings.ToLower(f.Search)
+	result := []Status{}
+	for _, s := range catalog {
+		switch {
+		case f.Class != "" && s.Class != f.Class:
+		case f.Cacheable != nil && s.Cacheable != *f.Cacheable:
+		case f.Retryable != nil && s.Retryable != *f.Retryable:
+		case search != "" && !strings.Contains(strings.ToLower(s.Name), search) &&
+			!strings.Contains(strings.ToLower(s.Description), search):
+		default:
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package status
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestCatalogIsSorted(t *testing.T) {
+	for i, s := range catalog {
+		if i > 0 && s.Code <= catalog[i-1].Code {
+			t.Errorf("%d follows %d", s.Code, catalog[i-1].Code)
+		}
+		if s.Name == "" || s.RFC == "" || s.Description == "" || s.Class != ClassOf(s.Code) {
+			t.Errorf("Incomplete entry %+v", s)
+		}
+	}
+}
+
+func TestCatalogCoversStandardLibrary(t *testing.T) {
+	for code := 100; code < 600; code++ {
+		if http.StatusText(code) == "" {
+			continue
+		}
+		if _, ok := Lookup(code); !ok {
+			t.Errorf("%d (%s) is missing", code, http.StatusText(code))
+		}
+	}
+}
+
+func TestLookupAndDescribe(t *testing.T) {
+	if s, ok := Lookup(404); !ok || s.Name != "Not Found" || s.Class != ClientError || !s.Cacheable || s.Retryable {
+		t.Errorf("Lookup(404) = %+v, %v", s, ok)
+	}
+	if s, ok := Lookup(503); !ok || !s.Retryable || s.RFC != "RFC 9110, Section 15.6.4" {
+		t.Errorf("Lookup(503) = %+v, %v", s, ok)
+	}
+	if _, ok := Lookup(299); ok {
+		t.Error("Expected 299 to be unassigned")
+	}
+	if s := Describe(299); s.Name != UnknownName || s.Class != Success || s.RFC != "" {
+		t.Errorf("Describe(299) = %+v", s)
+	}
+}
+
+func TestList(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []int
+	}{
+		{"retryable server errors", Filter{Class: ServerError, Retryable: &yes}, []int{502, 503, 504}},
+		{"search", Filter{Search: "TEAPOT"}, []int{418}},
+		{"cacheable redirects", Filter{Class: Redirection, Cacheable: &yes}, []int{300, 301, 308}},
+		{"nothing", Filter{Class: Informational, Retryable: &yes}, nil},
+	}
+	for _, tt := range tests {
+		var got []int
+		for _, s := range List(tt.filter) {
+			got = append(got, s.Code)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+	if all, notCacheable := List(Filter{}), List(Filter{Cacheable: &no}); len(all) != len(catalog) || len(notCacheable) >= len(all) {
+		t.Errorf("Unexpected sizes %d and %d", len(all), len(notCacheable))
+	}
+}
+
+func TestParseClass(t *testing.T) {
+	for input, want := range map[string]Class{"4xx": ClientError, "client_error": ClientError, "1XX": Informational, "6xx": "", "ok": ""} {
+		if got, ok := ParseClass(input); got != want || ok != (want != "") {
+			t.Errorf("ParseClass(%q) = %q, %v", input, got, ok)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	image, err := Image(404)
+	if err != nil {
+		t.Fatalf("Image failed: %v", err)
+	}
+	if !bytes.HasPrefix(image, []byte("<svg")) || !bytes.Contains(image, []byte(">404<")) || !bytes.Contains(image, []byte(">Not Found<")) {
+		t.Errorf("Unexpected image %s", image)
+	}
+}
@@ -0,0 +1,47 @@
+package status
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+)
+
+// ImageContentType is the media type of the images made by Image
+const ImageContentType = "image/svg+xml"
+
+//go:embed images/cat.svg
+var catSVG string
+
+// catTemplate is the embedded cat, so images can be served without
+// depending on http.cat
+var catTemplate = template.Must(template.New("cat").Parse(catSVG))
+
+// backgrounds colours the images by class
+var backgrounds = map[Class]string{
+	Informational: "#dfe9f5",
+	Success:       "#dff5e1",
+	Redirection:   "#f5f0df",
+	ClientError:   "#f5e6df",
+	ServerError:   "#f5dfe4",
+}
+
+// Image draws the cat for a status code as an SVG image
+func Image(code int) ([]byte, error) {
+	s := Describe(code)
+	var buf bytes.Buffer
+	err := catTemplate.Execute(&buf, struct {
+		Code       int
+		Name       string
+		Background string
+		Happy      bool
+	}{
+		Code:       s.Code,
+		Name:       s.Name,
+		Background: backgrounds[s.Class],
+		Happy:      s.Class == Informational || s.Class == Success || s.Class == Redirection,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,147 @@
+package status
+
+// rfc9110 cites a section of RFC 9110, HTTP Semantics
+func rfc9110(section string) string {
+	return "RFC 9110, Section " + section
+}
+
+// catalog is the IANA HTTP Status Code Registry, sorted by code.
+// Codes without a specification section are cited by RFC only.
+var catalog = []Status{
+	{Code: 100, Name: "Continue", RFC: rfc9110("15.2.1"),
+		Description: "The initial part of the request was received; the client should send the rest."},
+	{Code: 101, Name: "Switching Protocols", RFC: rfc9110("15.2.2"),
+		Description: "The server is switching to the protocol named in the Upgrade header."},
+	{Code: 102, Name: "Processing", RFC: "RFC 2518",
+		Description: "The server accepted the request but has not finished it yet."},
+	{Code: 103, Name: "Early Hints", RFC: "RFC 8297",
+		Description: "Headers the final response is likely to have, sent so the client can preload resources."},
+
+	{Code: 200, Name: "OK", RFC: rfc9110("15.3.1"), Cacheable: true,
+		Description: "The request succeeded."},
+	{Code: 201, Name: "Created", RFC: rfc9110("15.3.2"),
+		Description: "The request succeeded and created a new resource."},
+	{Code: 202, Name: "Accepted", RFC: rfc9110("15.3.3"),
+		Description: "The request was accepted for processing, which has not completed."},
+	{Code: 203, Name: "Non-Authoritative Information", RFC: rfc9110("15.3.4"), Cacheable: true,
+		Description: "The request succeeded, but a transforming proxy modified the content."},
+	{Code: 204, Name: "No Content", RFC: rfc9110("15.3.5"), Cacheable: true,
+		Description: "The request succeeded and there is no content to send."},
+	{Code: 205, Name: "Reset Content", RFC: rfc9110("15.3.6"),
+		Description: "The request succeeded; the client should reset the form that sent it."},
+	{Code: 206, Name: "Partial Content", RFC: rfc9110("15.3.7"), Cacheable: true,
+		Description: "The response holds the ranges of the content that were requested."},
+	{Code: 207, Name: "Multi-Status", RFC: "RFC 4918",
+		Description: "The response holds a separate status for each of several resources."},
+	{Code: 208, Name: "Already Reported", RFC: "RFC 5842",
+		Description: "The members of this binding were already listed earlier in the response."},
+	{Code: 226, Name: "IM Used", RFC: "RFC 3229",
+		Description: "The response is the result of instance manipulations applied to the resource."},
+
+	{Code: 300, Name: "Multiple Choices", RFC: rfc9110("15.4.1"), Cacheable: true,
+		Description: "The resource has several representations; the client should pick one."},
+	{Code: 301, Name: "Moved Permanently", RFC: rfc9110("15.4.2"), Cacheable: true,
+		Description: "The resource has a new permanent URI, given in the Location header."},
+	{Code: 302, Name: "Found", RFC: rfc9110("15.4.3"),
+		Description: "The resource is temporarily at the URI given in the Location header."},
+	{Code: 303, Name: "See Other", RFC: rfc9110("15.4.4"),
+		Description: "The result is at another URI, which should be retrieved with GET."},
+	{Code: 304, Name: "Not Modified", RFC: rfc9110("15.4.5"),
+		Description: "The client's cached copy is still valid."},
+	{Code: 305, Name: "Use Proxy", RFC: rfc9110("15.4.6"),
+		Description: "Deprecated; the resource had to be accessed through a proxy."},
+	{Code: 306, Name: "(Unused)", RFC: rfc9110("15.4.7"),
+		Description: "Used in an earlier draft and now reserved."},
+	{Code: 307, Name: "Temporary Redirect", RFC: rfc9110("15.4.8"),
+		Description: "The resource is temporarily at another URI; repeat the request unchanged there."},
+	{Code: 308, Name: "Permanent Redirect", RFC: rfc9110("15.4.9"), Cacheable: true,
+		Description: "The resource has a new permanent URI; repeat the request unchanged there."},
+
+	{Code: 400, Name: "Bad Request", RFC: rfc9110("15.5.1"),
+		Description: "The server cannot process the request because it is malformed."},
+	{Code: 401, Name: "Unauthorized", RFC: rfc9110("15.5.2"),
+		Description: "The request lacks valid authentication credentials."},
+	{Code: 402, Name: "Payment Required", RFC: rfc9110("15.5.3"),
+		Description: "Reserved for future use."},
+	{Code: 403, Name: "Forbidden", RFC: rfc9110("15.5.4"),
+		Description: "The server understood the request but refuses to fulfil it."},
+	{Code: 404, Name: "Not Found", RFC: rfc9110("15.5.5"), Cacheable: true,
+		Description: "The server did not find the target resource."},
+	{Code: 405, Name: "Method Not Allowed", RFC: rfc9110("15.5.6"), Cacheable: true,
+		Description: "The resource does not support the request method."},
+	{Code: 406, Name: "Not Acceptable", RFC: rfc9110("15.5.7"),
+		Description: "No representation matches the request's Accept headers."},
+	{Code: 407, Name: "Proxy Authentication Required", RFC: rfc9110("15.5.8"),
+		Description: "The client must authenticate with the proxy."},
+	{Code: 408, Name: "Request Timeout", RFC: rfc9110("15.5.9"), Retryable: true,
+		Description: "The server did not receive a complete request in time."},
+	{Code: 409, Name: "Conflict", RFC: rfc9110("15.5.10"),
+		Description: "The request conflicts with the current state of the resource."},
+	{Code: 410, Name: "Gone", RFC: rfc9110("15.5.11"), Cacheable: true,
+		Description: "The resource is permanently gone."},
+	{Code: 411, Name: "Length Required", RFC: rfc9110("15.5.12"),
+		Description: "The request needs a Content-Length header."},
+	{Code: 412, Name: "Precondition Failed", RFC: rfc9110("15.5.13"),
+		Description: "A condition in the request headers, such as If-Match, was false."},
+	{Code: 413, Name: "Content Too Large", RFC: rfc9110("15.5.14"),
+		Description: "The request content is larger than the server will process."},
+	{Code: 414, Name: "URI Too Long", RFC: rfc9110("15.5.15"), Cacheable: true,
+		Description: "The target URI is longer than the server will interpret."},
+	{Code: 415, Name: "Unsupported Media Type", RFC: rfc9110("15.5.16"),
+		Description: "The content is in a format the resource does not support."},
+	{Code: 416, Name: "Range Not Satisfiable", RFC: rfc9110("15.5.17"),
+		Description: "None of the requested ranges overlap the content."},
+	{Code: 417, Name: "Expectation Failed", RFC: rfc9110("15.5.18"),
+		Description: "The server cannot meet the request's Expect header."},
+	{Code: 418, Name: "(Unused)", RFC: rfc9110("15.5.19"),
+		Description: "Reserved because of its use as \"I'm a teapot\" in RFC 2324."},
+	{Code: 421, Name: "Misdirected Request", RFC: rfc9110("15.5.20"),
+		Description: "The request reached a server that cannot answer for the target URI."},
+	{Code: 422, Name: "Unprocessable Content", RFC: rfc9110("15.5.21"),
+		Description: "The request is well-formed but its instructions are invalid."},
+	{Code: 423, Name: "Locked", RFC: "RFC 4918",
+		Description: "The resource is locked."},
+	{Code: 424, Name: "Failed Dependency", RFC: "RFC 4918",
+		Description: "The request failed because a request it depended on failed."},
+	{Code: 425, Name: "Too Early", RFC: "RFC 8470", Retryable: true,
+		Description: "The server will not process a request that might be replayed."},
+	{Code: 426, Name: "Upgrade Required", RFC: rfc9110("15.5.22"),
+		Description: "The client must switch to the protocol named in the Upgrade header."},
+	{Code: 428, Name: "Precondition Required", RFC: "RFC 6585",
+		Description: "The request must be conditional, for example with If-Match."},
+	{Code: 429, Name: "Too Many Requests", RFC: "RFC 6585", Retryable: true,
+		Description: "The client sent too many requests; Retry-After says when to try again."},
+	{Code: 431, Name: "Request Header Fields Too Large", RFC: "RFC 6585",
+		Description: "The request headers are too large."},
+	{Code: 451, Name: "Unavailable For Legal Reasons", RFC: "RFC 7725",
+		Description: "The resource cannot be served because of a legal demand."},
+
+	{Code: 500, Name: "Internal Server Error", RFC: rfc9110("15.6.1"),
+		Description: "The server hit an unexpected condition."},
+	{Code: 501, Name: "Not Implemented", RFC: rfc9110("15.6.2"), Cacheable: true,
+		Description: "The server does not support the functionality the request needs."},
+	{Code: 502, Name: "Bad Gateway", RFC: rfc9110("15.6.3"), Retryable: true,
+		Description: "A gateway received an invalid response from the upstream server."},
+	{Code: 503, Name: "Service Unavailable", RFC: rfc9110("15.6.4"), Retryable: true,
+		Description: "The server is temporarily overloaded or down for maintenance."},
+	{Code: 504, Name: "Gateway Timeout", RFC: rfc9110("15.6.5"), Retryable: true,
+		Description: "A gateway did not get a response from the upstream server in time."},
+	{Code: 505, Name: "HTTP Version Not Supported", RFC: rfc9110("15.6.6"),
+		Description: "The server does not support the request's HTTP version."},
+	{Code: 506, Name: "Variant Also Negotiates", RFC: "RFC 2295",
+		Description: "The server's content negotiation is misconfigured."},
+	{Code: 507, Name: "Insufficient Storage", RFC: "RFC 4918",
+		Description: "The server cannot store what is needed to complete the request."},
+	{Code: 508, Name: "Loop Detected", RFC: "RFC 5842",
+		Description: "The server found an infinite loop while processing the request."},
+	{Code: 510, Name: "Not Extended (OBSOLETED)", RFC: "RFC 2774",
+		Description: "Obsolete; further extensions to the request were required."},
+	{Code: 511, Name: "Network Authentication Required", RFC: "RFC 6585",
+		Description: "The client must authenticate to gain network access, as with captive portals."},
+}
+
+func init() {
+	for i := range catalog {
+		catalog[i].Class = ClassOf(catalog[i].Code)
+	}
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"errors"
+	"lab03-backend/models"
+	"sync"
+)
+
+// Errors returned by Feed.Subscribe
+var (
+	ErrFeedClosed    = errors.New("event feed is closed")
+	ErrEventsExpired = errors.New("requested events are no longer available")
+)
+
+// EventType says what happened to a message
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted" // Message holds only the ID
+)
+
+// Event is one mutation, numbered in publishing order starting at 1
+type Event struct {
+	ID      uint64
+	Type    EventType
+	Message models.Message
+}
+
+// Feed size defaults
+const (
+	DefaultFeedHistory = 1000 // Events kept for resuming subscribers
+	subscriberBuffer   = 64   // Events a subscriber may fall behind before being dropped
+)
+
+// Feed keeps the latest events and fans new ones out to subscribers.
+// A subscriber that falls too far behind has its channel closed; it can
+// resubscribe from the last event it saw.
+type Feed struct {
+	mutex       sync.Mutex
+	history     []Event // Oldest first, at most capacity events
+	capacity    int
+	lastID      uint64
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+// NewFeed creates a Feed remembering up to capacity events
+func NewFeed(capacity int) *Feed {
+	if capacity <= 0 {
+		capacity = DefaultFeedHistory
+	}
+	return &Feed{
+		capacity:    capacity,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish records an event and delivers it to every subscriber
+func (f *Feed) Publish(eventType EventType, msg models.Message) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return
+	}
+
+	f.lastID++
+	event := Event{ID: f.lastID, Type: eventType, Message: msg}
+	if len(f.history) == f.capacity {
+		f.history = append(f.history[:0], f.history[1:]...)
+	}
+	f.history = append(f.history, event)
+
+	for ch := range f.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the events after lastEventID that are still in the
+// history, followed on the channel by every later event. Pass 0 to receive
+// only new events. ErrEventsExpired means events after lastEventID were
+// already discarded; the subscription is still valid but the caller should
+// reload its state. The channel is closed by cancel, by Close, or when the
+// subscriber falls too far behind.
+func (f *Feed) Subscribe(lastEventID uint64) (backlog []Event, events <-chan Event, cancel func(), err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return nil, nil, nil, ErrFeedClosed
+	}
+
+	switch {
+	case lastEventID > f.lastID:
+		// Numbered by an earlier feed, as before a server restart
+		err = ErrEventsExpired
+	case lastEventID > 0 && lastEventID < f.lastID:
+		oldest := f.lastID - uint64(len(f.history)) + 1
+		if lastEventID+1 < oldest {
+			err = ErrEventsExpired
+		}
+		start := 0
+		if lastEventID >= oldest {
+			start = int(lastEventID - oldest + 1)
+		}
+		backlog = append(backlog, f.history[start:]...)
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	f.subscribers[ch] = struct{}{}
+	cancel = func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if _, ok := f.subscribers[ch]; ok {
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel, err
+}
+
+// Close ends every subscription; later events are discarded
+func (f *Feed) Close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	for ch := range f.subscribers {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Notifier is a MessageRepository that publishes every successful mutation
+// of the wrapped repository to a Feed. Mutations through it run one at a
+// time, each publishing its events before the next starts, so events come
+// in the order of the changes they describe.
+type Notifier struct {
+	MessageRepository
+	feed  *Feed
+	mutex sync.Mutex // Held from a mutation until its events are published
+}
+
+// NewNotifier wraps repo so its mutations are published to feed
+func NewNotifier(repo MessageRepository, feed *Feed) *Notifier {
+	return &Notifier{MessageRepository: repo, feed: feed}
+}
+
+// Feed returns the feed mutations are published to
+func (n *Notifier) Feed() *Feed {
+	return n.feed
+}
+
+// Create adds a message and publishes EventCreated
+func (n *Notifier) Create(username, content string) (*models.Message, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	msg, err := n.MessageRepository.Create(username, content)
+	if err == nil {
+		n.feed.Publish(EventCreated, *msg)
+	}
+	return msg, err
+}
+
+// Reply adds a reply and publishes EventCreated, then EventUpdated for the
+// parent, whose reply count changed
+func (n *Notifier) Reply(parentID int, username, content string) (*models.Message, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	msg, err := n.MessageRepository.Reply(parentID, username, content)
+	if err == nil {
+		n.feed.Publish(EventCreated, *msg)
+		if parent, err := n.MessageRepository.GetByID(parentID); err == nil {
+			n.feed.Publish(EventUpdated, *parent)
+		}
+	}
+	return msg, err
+}
+
+// Import stores a message and publishes EventCreated
+func (n *Notifier) Import(msg models.Message) (*models.Message, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	imported, err := n.MessageRepository.Import(msg)
+	if err == nil {
+		n.feed.Publish(EventCreated, *imported)
+	}
+	return imported, err
+}
+
+// Update modifies a message and publishes EventUpdated
+func (n *Notifier) Update(id int, content string) (*models.Message, error) {
+	return n.UpdateIfVersion(id, 0, content)
+}
+
+// UpdateIfVersion modifies a message and publishes EventUpdated
+func (n *Notifier) UpdateIfVersion(id, version int, content string) (*models.Message, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	msg, err := n.MessageRepository.UpdateIfVersion(id, version, content)
+	if err == nil {
+		n.feed.Publish(EventUpdated, *msg)
+	}
+	return msg, err
+}
+
+// Delete removes a message and publishes EventDeleted
+func (n *Notifier) Delete(id int) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	err := n.MessageRepository.Delete(id)
+	if err == nil {
+		n.feed.Publish(EventDeleted, models.Message{ID: id})
+	}
+	return err
+}
+
+// AddReaction adds a reaction and publishes EventUpdated
+func (n *Notifier) AddReaction(id int, username, emoji string) (*models.Message, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	msg, err := n.MessageRepository.AddReaction(id, username, emoji)
+	if err == nil {
+		n.feed.Publish(EventUpdated, *msg)
+	}
+	return msg, err
+}
+
+// RemoveReaction removes a reaction and publishes EventUpdated
+func (n *Notifier) RemoveReaction(id int, username, emoji string) (*models.Message, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	msg, err := n.MessageRepository.RemoveReaction(id, username, emoji)
+	if err == nil {
+		n.feed.Publish(EventUpdated, *msg)
+	}
+	return msg, err
+}
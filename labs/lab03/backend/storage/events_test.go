@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"lab03-backend/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func eventIDs(events []Event) []uint64 {
+	var ids []uint64
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestFeed_Resume(t *testing.T) {
+	feed := NewFeed(3)
+	for i := 1; i <= 5; i++ {
+		feed.Publish(EventCreated, models.Message{ID: i})
+	}
+
+	tests := []struct {
+		lastEventID uint64
+		want        []uint64
+		expired     bool
+	}{
+		{0, nil, false},
+		{5, nil, false},
+		{4, []uint64{5}, false},
+		{2, []uint64{3, 4, 5}, false},
+		{1, []uint64{3, 4, 5}, true}, // Event 2 was discarded
+		{9, nil, true},               // From a previous feed
+	}
+	for _, tt := range tests {
+		backlog, _, cancel, err := feed.Subscribe(tt.lastEventID)
+		cancel()
+		if got := eventIDs(backlog); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Subscribe(%d) backlog = %v, want %v", tt.lastEventID, got, tt.want)
+		}
+		if errors.Is(err, ErrEventsExpired) != tt.expired {
+			t.Errorf("Subscribe(%d) error = %v, expired %v", tt.lastEventID, err, tt.expired)
+		}
+	}
+}
+
+func TestFeed_Delivery(t *testing.T) {
+	feed := NewFeed(10)
+	_, events, cancel, _ := feed.Subscribe(0)
+	_, slow, _, _ := feed.Subscribe(0)
+
+	feed.Publish(EventCreated, models.Message{ID: 1, Content: "hi"})
+	if e := <-events; e.ID != 1 || e.Type != EventCreated || e.Message.Content != "hi" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+
+	// A subscriber that stops reading is dropped instead of blocking others
+	for i := 0; i < subscriberBuffer+1; i++ {
+		feed.Publish(EventUpdated, models.Message{ID: 1})
+		<-events
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("Expected the slow subscriber to get %d events before being dropped, got %d", subscriberBuffer, n)
+	}
+
+	cancel()
+	cancel() // Safe to call twice
+	if _, ok := <-events; ok {
+		t.Error("Expected the channel to be closed by cancel")
+	}
+
+	_, events, _, _ = feed.Subscribe(0)
+	feed.Close()
+	if _, ok := <-events; ok {
+		t.Error("Expected the channel to be closed by Close")
+	}
+	if _, _, _, err := feed.Subscribe(0); !errors.Is(err, ErrFeedClosed) {
+		t.Errorf("Expected ErrFeedClosed, got %v", err)
+	}
+	feed.Publish(EventDeleted, models.Message{ID: 1}) // Ignored
+}
+
+func TestNotifier(t *testing.T) {
+	feed := NewFeed(10)
+	repo := NewNotifier(NewMemoryStorage(), feed)
+
+	msg, _ := repo.Create("alice", "hello")
+	repo.Update(msg.ID, "edited")
+	repo.UpdateIfVersion(msg.ID, 1, "stale") // Fails, no event
+	repo.Delete(999)                         // Fails, no event
+	repo.Delete(msg.ID)
+
+	backlog, _, cancel, _ := feed.Subscribe(0)
+	cancel()
+	if len(backlog) != 0 {
+		t.Errorf("Expected no backlog for a new subscriber, got %v", backlog)
+	}
+	backlog, _, cancel, _ = feed.Subscribe(1)
+	cancel()
+	if len(backlog) != 2 || backlog[0].Type != EventUpdated || backlog[0].Message.Content != "edited" ||
+		backlog[1].Type != EventDeleted || backlog[1].Message.ID != msg.ID {
+		t.Errorf("Unexpected events %+v", backlog)
+	}
+}
+
+func TestNotifier_RepliesAndReactions(t *testing.T) {
+	feed := NewFeed(10)
+	repo := NewNotifier(NewMemoryStorage(), feed)
+	parent, _ := repo.Create("alice", "question")
+	repo.Reply(parent.ID, "bob", "answer")
+	repo.AddReaction(parent.ID, "bob", "👍")
+	repo.AddReaction(parent.ID, "bob", "👍") // Fails, no event
+	repo.RemoveReaction(parent.ID, "bob", "👍")
+
+	backlog, _, cancel, _ := feed.Subscribe(1)
+	cancel()
+	var got []string
+	for _, e := range backlog {
+		got = append(got, fmt.Sprintf("%s %d", e.Type, e.Message.ID))
+	}
+	want := []string{"created 2", "updated 1", "updated 1", "updated 1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Events are %q, want %q", got, want)
+	}
+	if backlog[1].Message.ReplyCount != 1 || len(backlog[2].Message.Reactions) != 1 || backlog[3].Message.Reactions != nil {
+		t.Errorf("Unexpected events %+v", backlog)
+	}
+}
+
+// slowRepository is slow to return from its first UpdateIfVersion, as a
+// caller descheduled between writing and publishing would be. written
+// receives after that first write.
+type slowRepository struct {
+	MessageRepository
+	calls   atomic.Int32
+	written chan struct{}
+}
+
+func (r *slowRepository) UpdateIfVersion(id, version int, content string) (*models.Message, error) {
+	msg, err := r.MessageRepository.UpdateIfVersion(id, version, content)
+	if r.calls.Add(1) == 1 {
+		close(r.written)
+		time.Sleep(50 * time.Millisecond)
+	}
+	return msg, err
+}
+
+func TestNotifier_EventsInMutationOrder(t *testing.T) {
+	feed := NewFeed(10)
+	slow := &slowRepository{MessageRepository: NewMemoryStorage(), written: make(chan struct{})}
+	repo := NewNotifier(slow, feed)
+	msg, _ := repo.Create("alice", "hello")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		repo.Update(msg.ID, "first")
+	}()
+	<-slow.written
+	repo.Update(msg.ID, "second")
+	<-done
+
+	backlog, _, cancel, _ := feed.Subscribe(1)
+	cancel()
+	var got []string
+	for _, e := range backlog {
+		got = append(got, fmt.Sprintf("v%d %s", e.Message.Version, e.Message.Content))
+	}
+	want := []string{"v2 first", "v3 second"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Events are %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"errors"
+	"lab03-backend/models"
+	"time"
+)
+
+// ErrDuplicateID is returned by Import for an ID that is already stored
+var ErrDuplicateID = errors.New("message ID already exists")
+
+// prepareImport fills in what an imported message may leave out: version 1
+// and the current time. ReplyCount and Reactions are computed, not imported.
+// It returns the time of the revision holding the message's content.
+func prepareImport(msg *models.Message) (time.Time, error) {
+	if msg.ID < 0 || msg.ParentID < 0 || msg.Version < 0 {
+		return time.Time{}, ErrInvalidID
+	}
+	if msg.Version == 0 {
+		msg.Version = 1
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = now()
+	}
+	if msg.EditedAt != nil {
+		// Not shared with the caller
+		editedAt := *msg.EditedAt
+		msg.EditedAt = &editedAt
+	}
+	msg.ReplyCount = 0
+	msg.Reactions = nil
+
+	if msg.EditedAt != nil {
+		return *msg.EditedAt, nil
+	}
+	return msg.Timestamp, nil
+}
@@ -3,83 +3,324 @@ package storage
 import (
 	"errors"
 	"lab03-backend/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 // MemoryStorage implements in-memory storage for messages
 type MemoryStorage struct {
-	// TODO: Add mutex field for thread safety (sync.RWMutex)
-	// TODO: Add messages field as map[int]*models.Message
-	// TODO: Add nextID field of type int for auto-incrementing IDs
+	mutex       sync.RWMutex
+	messages    map[int]*models.Message
+	revisions   map[int][]models.Revision // Oldest first, including the current content
+	replyCounts map[int]int               // Parent ID -> number of stored replies
+	reactions   map[int]map[reaction]struct{}
+	nextID      int
+}
+
+// reaction is one user's emoji on a message
+type reaction struct {
+	username, emoji string
 }
 
 // NewMemoryStorage creates a new in-memory storage instance
 func NewMemoryStorage() *MemoryStorage {
-	// TODO: Return a new MemoryStorage instance with initialized fields
-	// Initialize messages as empty map
-	// Set nextID to 1
-	return nil
+	return &MemoryStorage{
+		messages:    make(map[int]*models.Message),
+		revisions:   make(map[int][]models.Revision),
+		replyCounts: make(map[int]int),
+		reactions:   make(map[int]map[reaction]struct{}),
+		nextID:      1,
+	}
 }
 
-// GetAll returns all messages
+// GetAll returns all messages ordered by ID
 func (ms *MemoryStorage) GetAll() []*models.Message {
-	// TODO: Implement GetAll method
-	// Use read lock for thread safety
-	// Convert map values to slice
-	// Return slice of all messages
-	return nil
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	messages := make([]*models.Message, 0, len(ms.messages))
+	for _, msg := range ms.messages {
+		messages = append(messages, ms.view(msg))
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages
 }
 
 // GetByID returns a message by its ID
 func (ms *MemoryStorage) GetByID(id int) (*models.Message, error) {
-	// TODO: Implement GetByID method
-	// Use read lock for thread safety
-	// Check if message exists in map
-	// Return message or error if not found
-	return nil, nil
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	msg, ok := ms.messages[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return ms.view(msg), nil
+}
+
+// view copies msg, adding its reply count and reactions. Caller must hold
+// the lock.
+func (ms *MemoryStorage) view(msg *models.Message) *models.Message {
+	copied := *msg
+	copied.ReplyCount = ms.replyCounts[msg.ID]
+	if reactions := ms.reactions[msg.ID]; len(reactions) > 0 {
+		counts := make(map[string]int)
+		for r := range reactions {
+			counts[r.emoji]++
+		}
+		copied.Reactions = reactionCounts(counts)
+	}
+	return &copied
 }
 
 // Create adds a new message to storage
 func (ms *MemoryStorage) Create(username, content string) (*models.Message, error) {
-	// TODO: Implement Create method
-	// Use write lock for thread safety
-	// Get next available ID
-	// Create new message using models.NewMessage
-	// Add message to map
-	// Increment nextID
-	// Return created message
-	return nil, nil
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.create(username, content, 0), nil
+}
+
+// Reply adds a message replying to parentID
+func (ms *MemoryStorage) Reply(parentID int, username, content string) (*models.Message, error) {
+	if parentID <= 0 {
+		return nil, ErrInvalidID
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, ok := ms.messages[parentID]; !ok {
+		return nil, ErrMessageNotFound
+	}
+	ms.replyCounts[parentID]++
+	return ms.create(username, content, parentID), nil
+}
+
+// create stores a new message. Caller must hold the write lock.
+func (ms *MemoryStorage) create(username, content string, parentID int) *models.Message {
+	msg := models.NewMessage(ms.nextID, username, content)
+	msg.ParentID = parentID
+	ms.messages[msg.ID] = msg
+	ms.revisions[msg.ID] = []models.Revision{{Version: msg.Version, Content: content, Timestamp: msg.Timestamp}}
+	ms.nextID++
+	return ms.view(msg)
+}
+
+// Import stores msg with its timestamp, version and parent. A zero ID is
+// replaced with a new one; any other ID is kept, and later IDs are allocated
+// above it. Only the current content is kept as history.
+func (ms *MemoryStorage) Import(msg models.Message) (*models.Message, error) {
+	revised, err := prepareImport(&msg)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if msg.ID == 0 {
+		msg.ID = ms.nextID
+	} else if _, exists := ms.messages[msg.ID]; exists {
+		return nil, ErrDuplicateID
+	} else {
+		// Replies may have been imported before their parent
+		for _, other := range ms.messages {
+			if other.ParentID == msg.ID {
+				ms.replyCounts[msg.ID]++
+			}
+		}
+	}
+	if msg.ID >= ms.nextID {
+		ms.nextID = msg.ID + 1
+	}
+	if _, ok := ms.messages[msg.ParentID]; ok {
+		ms.replyCounts[msg.ParentID]++
+	}
+	ms.messages[msg.ID] = &msg
+	ms.revisions[msg.ID] = []models.Revision{{Version: msg.Version, Content: msg.Content, Timestamp: revised}}
+	return ms.view(&msg), nil
 }
 
 // Update modifies an existing message
 func (ms *MemoryStorage) Update(id int, content string) (*models.Message, error) {
-	// TODO: Implement Update method
-	// Use write lock for thread safety
-	// Check if message exists
-	// Update the content field
-	// Return updated message or error if not found
-	return nil, nil
+	return ms.UpdateIfVersion(id, 0, content)
+}
+
+// UpdateIfVersion modifies a message if its version is still version, or
+// unconditionally when version is 0
+func (ms *MemoryStorage) UpdateIfVersion(id, version int, content string) (*models.Message, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	msg, ok := ms.messages[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if version != 0 && version != msg.Version {
+		return nil, ErrVersionConflict
+	}
+	now := time.Now()
+	msg.Content = content
+	msg.Version++
+	msg.EditedAt = &now
+	ms.revisions[id] = append(ms.revisions[id], models.Revision{Version: msg.Version, Content: content, Timestamp: now})
+	return ms.view(msg), nil
+}
+
+// History returns every revision of a message, oldest first
+func (ms *MemoryStorage) History(id int) ([]models.Revision, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	revisions, ok := ms.revisions[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return append([]models.Revision(nil), revisions...), nil
 }
 
 // Delete removes a message from storage
 func (ms *MemoryStorage) Delete(id int) error {
-	// TODO: Implement Delete method
-	// Use write lock for thread safety
-	// Check if message exists
-	// Delete from map
-	// Return error if message not found
+	if id <= 0 {
+		return ErrInvalidID
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	msg, ok := ms.messages[id]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	if _, ok := ms.messages[msg.ParentID]; ok {
+		ms.replyCounts[msg.ParentID]--
+	}
+	delete(ms.messages, id)
+	delete(ms.revisions, id)
+	delete(ms.replyCounts, id)
+	delete(ms.reactions, id)
 	return nil
 }
 
+// AddReaction records username's emoji reaction to message id
+func (ms *MemoryStorage) AddReaction(id int, username, emoji string) (*models.Message, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	msg, ok := ms.messages[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	key := reaction{username: username, emoji: emoji}
+	if _, exists := ms.reactions[id][key]; exists {
+		return nil, ErrDuplicateReaction
+	}
+	if ms.reactions[id] == nil {
+		ms.reactions[id] = make(map[reaction]struct{})
+	}
+	ms.reactions[id][key] = struct{}{}
+	return ms.view(msg), nil
+}
+
+// RemoveReaction removes username's emoji reaction from message id
+func (ms *MemoryStorage) RemoveReaction(id int, username, emoji string) (*models.Message, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	msg, ok := ms.messages[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	key := reaction{username: username, emoji: emoji}
+	if _, exists := ms.reactions[id][key]; !exists {
+		return nil, ErrReactionNotFound
+	}
+	delete(ms.reactions[id], key)
+	return ms.view(msg), nil
+}
+
 // Count returns the total number of messages
 func (ms *MemoryStorage) Count() int {
-	// TODO: Implement Count method
-	// Use read lock for thread safety
-	// Return length of messages map
-	return 0
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return len(ms.messages)
+}
+
+// Query returns one page of messages matching q
+func (ms *MemoryStorage) Query(q Query) (Page, error) {
+	start, err := q.start(ms.GetByID)
+	if err != nil {
+		return Page{}, err
+	}
+	limit := q.pageSize()
+	search := strings.ToLower(q.Search)
+
+	ms.mutex.RLock()
+	results := make([]*models.Message, 0, min(limit+1, len(ms.messages)))
+	for _, msg := range ms.messages {
+		if !q.matches(msg, search) {
+			continue
+		}
+		if start != nil {
+			p := positionOf(msg)
+			if q.Descending && !p.before(*start) || !q.Descending && !start.before(p) {
+				continue
+			}
+		}
+		results = append(results, ms.view(msg))
+	}
+	ms.mutex.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := positionOf(results[i]), positionOf(results[j])
+		if q.Descending {
+			return b.before(a)
+		}
+		return a.before(b)
+	})
+	return paginate(results[:min(limit+1, len(results))], limit), nil
+}
+
+// List implements MessageRepository; it cannot fail
+func (ms *MemoryStorage) List() ([]*models.Message, error) {
+	return ms.GetAll(), nil
+}
+
+// Total implements MessageRepository; it cannot fail
+func (ms *MemoryStorage) Total() (int, error) {
+	return ms.Count(), nil
+}
+
+// Close does nothing; the in-memory storage holds no resources
+func (ms *MemoryStorage) Close() error {
+	return nil
 }
 
 // Common errors
 var (
 	ErrMessageNotFound = errors.New("message not found")
 	ErrInvalidID       = errors.New("invalid message ID")
+	ErrVersionConflict = errors.New("message was modified by someone else")
 )
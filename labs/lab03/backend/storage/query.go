@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"lab03-backend/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned for cursors not produced by Query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page size limits for Query
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+)
+
+// Query selects messages from a MessageRepository. Results are ordered by
+// timestamp, then ID, so pages stay stable when timestamps collide.
+// Empty fields do not filter. Since is inclusive, Until is exclusive.
+// Search is a case-insensitive substring match on the content.
+type Query struct {
+	ParentID   int  // Only replies to this message
+	TopLevel   bool // Only messages that are not replies
+	Username   string
+	Since      time.Time
+	Until      time.Time
+	Search     string
+	Cursor     string // NextCursor of the previous page
+	AfterID    int    // Start after this message; ignored when Cursor is set
+	Descending bool   // Newest first
+	Limit      int    // Page size, DefaultPageSize when zero
+}
+
+// Page is one page of query results
+// NextCursor is empty on the last page
+type Page struct {
+	Messages   []*models.Message
+	NextCursor string
+}
+
+// position is a place in the (timestamp, ID) ordering
+type position struct {
+	timestamp time.Time
+	id        int
+}
+
+func positionOf(msg *models.Message) position {
+	return position{timestamp: msg.Timestamp, id: msg.ID}
+}
+
+// before reports whether p sorts before other in ascending order
+func (p position) before(other position) bool {
+	if !p.timestamp.Equal(other.timestamp) {
+		return p.timestamp.Before(other.timestamp)
+	}
+	return p.id < other.id
+}
+
+// encodeCursor makes an opaque cursor resuming after p
+func encodeCursor(p position) string {
+	raw := p.timestamp.UTC().Format(time.RFC3339Nano) + "," + strconv.Itoa(p.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (position, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return position{}, ErrInvalidCursor
+	}
+	stamp, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return position{}, ErrInvalidCursor
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, stamp)
+	if err != nil {
+		return position{}, ErrInvalidCursor
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil || n <= 0 {
+		return position{}, ErrInvalidCursor
+	}
+	return position{timestamp: timestamp, id: n}, nil
+}
+
+// start resolves Cursor or AfterID into the position to continue after, or
+// nil to start from the beginning. get looks up AfterID.
+func (q *Query) start(get func(id int) (*models.Message, error)) (*position, error) {
+	if q.Cursor != "" {
+		p, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		return &p, nil
+	}
+	if q.AfterID != 0 {
+		msg, err := get(q.AfterID)
+		if err != nil {
+			return nil, err
+		}
+		p := positionOf(msg)
+		return &p, nil
+	}
+	return nil, nil
+}
+
+// pageSize applies the defaults and limits to Limit
+func (q *Query) pageSize() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultPageSize
+	case q.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return q.Limit
+}
+
+// matches applies the filters, but not the start position
+func (q *Query) matches(msg *models.Message, search string) bool {
+	switch {
+	case q.ParentID != 0 && msg.ParentID != q.ParentID:
+		return false
+	case q.TopLevel && msg.ParentID != 0:
+		return false
+	case q.Username != "" && msg.Username != q.Username:
+		return false
+	case !q.Since.IsZero() && msg.Timestamp.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !msg.Timestamp.Before(q.Until):
+		return false
+	case search != "" && !strings.Contains(strings.ToLower(msg.Content), search):
+		return false
+	}
+	return true
+}
+
+// paginate trims results, which hold up to limit+1 messages in query order,
+// to one page
+func paginate(results []*models.Message, limit int) Page {
+	page := Page{Messages: results}
+	if len(results) > limit {
+		page.Messages = results[:limit]
+		page.NextCursor = encodeCursor(positionOf(results[limit-1]))
+	}
+	return page
+}
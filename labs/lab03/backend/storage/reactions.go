@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"errors"
+	"lab03-backend/models"
+	"sort"
+)
+
+// Errors returned by AddReaction and RemoveReaction
+var (
+	ErrDuplicateReaction = errors.New("reaction already added")
+	ErrReactionNotFound  = errors.New("reaction not found")
+)
+
+// reactionCounts turns emoji -> count into the order shown to clients: most
+// used first, ties by emoji
+func reactionCounts(counts map[string]int) []models.ReactionCount {
+	result := make([]models.ReactionCount, 0, len(counts))
+	for emoji, n := range counts {
+		if n > 0 {
+			result = append(result, models.ReactionCount{Emoji: emoji, Count: n})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Emoji < result[j].Emoji
+	})
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"fmt"
+	"lab03-backend/models"
+)
+
+// MessageRepository is the message storage used by the API handlers.
+// MemoryStorage and SQLStorage implement it; every implementation must pass
+// the conformance tests in repository_test.go.
+type MessageRepository interface {
+	// List returns every message ordered by ID
+	List() ([]*models.Message, error)
+	// Query returns one page of messages ordered by timestamp, then ID
+	Query(q Query) (Page, error)
+	GetByID(id int) (*models.Message, error)
+	Create(username, content string) (*models.Message, error)
+	// Reply creates a message replying to parentID, returning
+	// ErrMessageNotFound when the parent does not exist
+	Reply(parentID int, username, content string) (*models.Message, error)
+	// Import stores a message as given, keeping its ID unless it is 0.
+	// It returns ErrDuplicateID if the ID is taken.
+	Import(msg models.Message) (*models.Message, error)
+	Update(id int, content string) (*models.Message, error)
+	// UpdateIfVersion updates only if the stored version equals version,
+	// returning ErrVersionConflict otherwise; version 0 skips the check
+	UpdateIfVersion(id, version int, content string) (*models.Message, error)
+	// History returns every revision of a message, oldest first
+	History(id int) ([]models.Revision, error)
+	Delete(id int) error
+	// AddReaction records that username reacted with emoji, returning
+	// ErrDuplicateReaction if they already did
+	AddReaction(id int, username, emoji string) (*models.Message, error)
+	// RemoveReaction returns ErrReactionNotFound if there is no such reaction
+	RemoveReaction(id int, username, emoji string) (*models.Message, error)
+	// Total returns the number of stored messages
+	Total() (int, error)
+	Close() error
+}
+
+// Storage drivers accepted by Open
+const (
+	DriverMemory   = "memory"
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Config selects a MessageRepository implementation
+type Config struct {
+	Driver string // DriverMemory when empty
+	DSN    string // File name for SQLite, connection string for Postgres
+}
+
+// DefaultConfig returns a configuration using MemoryStorage
+func DefaultConfig() Config {
+	return Config{Driver: DriverMemory}
+}
+
+// Open creates the repository described by config
+func Open(config Config) (MessageRepository, error) {
+	switch config.Driver {
+	case "", DriverMemory:
+		return NewMemoryStorage(), nil
+	case DriverSQLite:
+		return NewSQLiteStorage(config.DSN)
+	case DriverPostgres:
+		return NewPostgresStorage(config.DSN)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", config.Driver)
+	}
+}
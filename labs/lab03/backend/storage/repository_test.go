@@ -0,0 +1,520 @@
+package storage
+
+import (
+	"errors"
+	"lab03-backend/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// repositories returns a constructor for every backend available in this
+// environment. Postgres runs only when LAB03_POSTGRES_DSN is set.
+func repositories(t *testing.T) map[string]func(t *testing.T) MessageRepository {
+	backends := map[string]func(t *testing.T) MessageRepository{
+		"memory": func(t *testing.T) MessageRepository { return NewMemoryStorage() },
+		"sqlite": func(t *testing.T) MessageRepository {
+			return openTestRepository(t, Config{Driver: DriverSQLite, DSN: filepath.Join(t.TempDir(), "chat.db")})
+		},
+	}
+	if dsn := os.Getenv("LAB03_POSTGRES_DSN"); dsn != "" {
+		backends["postgres"] = func(t *testing.T) MessageRepository {
+			repo := openTestRepository(t, Config{Driver: DriverPostgres, DSN: dsn})
+			if _, err := repo.(*SQLStorage).db.Exec("TRUNCATE messages RESTART IDENTITY CASCADE"); err != nil {
+				t.Fatalf("Reset failed: %v", err)
+			}
+			return repo
+		}
+	}
+	return backends
+}
+
+func openTestRepository(t *testing.T, config Config) MessageRepository {
+	t.Helper()
+	repo, err := Open(config)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// TestRepositoryConformance runs the same checks against every backend
+func TestRepositoryConformance(t *testing.T) {
+	tests := map[string]func(t *testing.T, repo MessageRepository){
+		"CRUD":        testRepositoryCRUD,
+		"Errors":      testRepositoryErrors,
+		"Ordering":    testRepositoryOrdering,
+		"Concurrency": testRepositoryConcurrency,
+		"Query":       testRepositoryQuery,
+		"Versions":    testRepositoryVersions,
+		"Replies":     testRepositoryReplies,
+		"Reactions":   testRepositoryReactions,
+		"Import":      testRepositoryImport,
+	}
+
+	for backend, open := range repositories(t) {
+		t.Run(backend, func(t *testing.T) {
+			for name, test := range tests {
+				t.Run(name, func(t *testing.T) { test(t, open(t)) })
+			}
+		})
+	}
+}
+
+func testRepositoryCRUD(t *testing.T, repo MessageRepository) {
+	created, err := repo.Create("alice", "hello")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if created.ID <= 0 || created.Username != "alice" || created.Content != "hello" || created.Timestamp.IsZero() {
+		t.Fatalf("Unexpected created message: %+v", created)
+	}
+
+	got, err := repo.GetByID(created.ID)
+	if err != nil {
+		t.Fatalf("GetByID failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, created) {
+		t.Errorf("GetByID returned %+v, want %+v", got, created)
+	}
+
+	updated, err := repo.Update(created.ID, "hello again")
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if updated.Content != "hello again" || updated.ID != created.ID || !updated.Timestamp.Equal(created.Timestamp) {
+		t.Errorf("Unexpected updated message: %+v", updated)
+	}
+	if got, _ := repo.GetByID(created.ID); got.Content != "hello again" {
+		t.Errorf("Update was not stored: %+v", got)
+	}
+
+	// Returned messages are copies
+	got.Content = "changed by caller"
+	if again, _ := repo.GetByID(created.ID); again.Content != "hello again" {
+		t.Errorf("Modifying a returned message changed the store: %+v", again)
+	}
+
+	if n, err := repo.Total(); err != nil || n != 1 {
+		t.Errorf("Total = %d, %v; want 1", n, err)
+	}
+	if err := repo.Delete(created.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := repo.GetByID(created.ID); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected ErrMessageNotFound after delete, got %v", err)
+	}
+	if n, err := repo.Total(); err != nil || n != 0 {
+		t.Errorf("Total = %d, %v; want 0", n, err)
+	}
+
+	// IDs are not reused after a delete
+	next, _ := repo.Create("bob", "later")
+	if next.ID <= created.ID {
+		t.Errorf("Expected an ID above %d, got %d", created.ID, next.ID)
+	}
+}
+
+func testRepositoryErrors(t *testing.T, repo MessageRepository) {
+	if _, err := repo.GetByID(999); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("GetByID: expected ErrMessageNotFound, got %v", err)
+	}
+	if _, err := repo.Update(999, "content"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Update: expected ErrMessageNotFound, got %v", err)
+	}
+	if err := repo.Delete(999); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Delete: expected ErrMessageNotFound, got %v", err)
+	}
+
+	for _, id := range []int{0, -1} {
+		if _, err := repo.GetByID(id); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("GetByID(%d): expected ErrInvalidID, got %v", id, err)
+		}
+		if _, err := repo.Update(id, "content"); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("Update(%d): expected ErrInvalidID, got %v", id, err)
+		}
+		if err := repo.Delete(id); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("Delete(%d): expected ErrInvalidID, got %v", id, err)
+		}
+	}
+}
+
+func testRepositoryOrdering(t *testing.T, repo MessageRepository) {
+	empty, err := repo.List()
+	if err != nil || empty == nil || len(empty) != 0 {
+		t.Errorf("Expected an empty non-nil list, got %v, %v", empty, err)
+	}
+
+	var ids []int
+	for _, content := range []string{"one", "two", "three", "four"} {
+		msg, err := repo.Create("alice", content)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	repo.Delete(ids[1])
+
+	messages, err := repo.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var got []string
+	for i, msg := range messages {
+		got = append(got, msg.Content)
+		if i > 0 && msg.ID <= messages[i-1].ID {
+			t.Errorf("List is not ordered by ID: %d after %d", msg.ID, messages[i-1].ID)
+		}
+	}
+	if len(got) != 3 || got[0] != "one" || got[1] != "three" || got[2] != "four" {
+		t.Errorf("List returned %q", got)
+	}
+}
+
+func testRepositoryConcurrency(t *testing.T, repo MessageRepository) {
+	var wg sync.WaitGroup
+	ids := make(chan int, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg, err := repo.Create("user", "content")
+			if err != nil {
+				t.Errorf("Concurrent create failed: %v", err)
+				return
+			}
+			ids <- msg.ID
+			repo.List()
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("ID %d assigned twice", id)
+		}
+		seen[id] = true
+	}
+	if n, _ := repo.Total(); n != 20 {
+		t.Errorf("Expected 20 messages, got %d", n)
+	}
+}
+
+// collect pages through q, failing after too many pages
+func collect(t *testing.T, repo MessageRepository, q Query) []string {
+	t.Helper()
+	var got []string
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("Too many pages")
+		}
+		page, err := repo.Query(q)
+		if err != nil {
+			t.Fatalf("Query(%+v) failed: %v", q, err)
+		}
+		if len(page.Messages) > q.pageSize() {
+			t.Fatalf("Page has %d messages, limit is %d", len(page.Messages), q.pageSize())
+		}
+		for _, msg := range page.Messages {
+			got = append(got, msg.Content)
+		}
+		if page.NextCursor == "" {
+			return got
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func testRepositoryQuery(t *testing.T, repo MessageRepository) {
+	var created []*models.Message
+	for i, content := range []string{"Hello world", "second", "100% done", "hello again", "last_one"} {
+		username := "alice"
+		if i%2 == 1 {
+			username = "bob"
+		}
+		msg, err := repo.Create(username, content)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		created = append(created, msg)
+		time.Sleep(2 * time.Millisecond) // Distinct timestamps for since/until
+	}
+
+	all := []string{"Hello world", "second", "100% done", "hello again", "last_one"}
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all", Query{}, all},
+		{"pages", Query{Limit: 2}, all},
+		{"descending", Query{Limit: 2, Descending: true}, []string{"last_one", "hello again", "100% done", "second", "Hello world"}},
+		{"username", Query{Username: "bob"}, []string{"second", "hello again"}},
+		{"search ignores case", Query{Search: "HELLO", Limit: 1}, []string{"Hello world", "hello again"}},
+		{"search wildcards are literal", Query{Search: "%"}, []string{"100% done"}},
+		{"search underscore is literal", Query{Search: "t_o"}, []string{"last_one"}},
+		{"since is inclusive", Query{Since: created[3].Timestamp}, []string{"hello again", "last_one"}},
+		{"until is exclusive", Query{Until: created[1].Timestamp}, []string{"Hello world"}},
+		{"after_id", Query{AfterID: created[2].ID}, []string{"hello again", "last_one"}},
+		{"after_id descending", Query{AfterID: created[2].ID, Descending: true}, []string{"second", "Hello world"}},
+		{"combined", Query{Username: "alice", Search: "o", Descending: true, Limit: 1}, []string{"last_one", "100% done", "Hello world"}},
+	}
+	for _, tt := range tests {
+		if got := collect(t, repo, tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := repo.Query(Query{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+	if _, err := repo.Query(Query{AfterID: 999}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected ErrMessageNotFound for a missing after_id, got %v", err)
+	}
+}
+
+func testRepositoryVersions(t *testing.T, repo MessageRepository) {
+	created, _ := repo.Create("alice", "first")
+	if created.Version != 1 || created.EditedAt != nil {
+		t.Errorf("Expected version 1 and no edit time, got %+v", created)
+	}
+
+	second, err := repo.UpdateIfVersion(created.ID, 1, "second")
+	if err != nil {
+		t.Fatalf("UpdateIfVersion failed: %v", err)
+	}
+	if second.Version != 2 || second.EditedAt == nil || second.EditedAt.Before(created.Timestamp) {
+		t.Errorf("Expected version 2 with an edit time, got %+v", second)
+	}
+
+	// A writer holding the old version loses
+	if _, err := repo.UpdateIfVersion(created.ID, 1, "stale"); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+	if _, err := repo.UpdateIfVersion(999, 1, "missing"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected ErrMessageNotFound, got %v", err)
+	}
+	// Update and version 0 skip the check
+	if msg, err := repo.Update(created.ID, "third"); err != nil || msg.Version != 3 {
+		t.Errorf("Update: got %+v, %v", msg, err)
+	}
+
+	history, err := repo.History(created.ID)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	var contents []string
+	for i, rev := range history {
+		contents = append(contents, rev.Content)
+		if rev.Version != i+1 {
+			t.Errorf("Revision %d has version %d", i, rev.Version)
+		}
+	}
+	if !reflect.DeepEqual(contents, []string{"first", "second", "third"}) {
+		t.Errorf("History has %q", contents)
+	}
+	if !history[0].Timestamp.Equal(created.Timestamp) || !history[1].Timestamp.Equal(*second.EditedAt) {
+		t.Errorf("Unexpected revision times: %+v", history)
+	}
+
+	repo.Delete(created.ID)
+	if _, err := repo.History(created.ID); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected ErrMessageNotFound for the history of a deleted message, got %v", err)
+	}
+}
+
+func testRepositoryReplies(t *testing.T, repo MessageRepository) {
+	parent, _ := repo.Create("alice", "question")
+	other, _ := repo.Create("bob", "unrelated")
+	first, err := repo.Reply(parent.ID, "bob", "answer")
+	if err != nil {
+		t.Fatalf("Reply failed: %v", err)
+	}
+	if first.ParentID != parent.ID || first.Version != 1 {
+		t.Errorf("Unexpected reply: %+v", first)
+	}
+	repo.Reply(parent.ID, "carol", "another answer")
+	repo.Reply(first.ID, "alice", "nested")
+
+	if got, _ := repo.GetByID(parent.ID); got.ReplyCount != 2 {
+		t.Errorf("Expected 2 replies, got %+v", got)
+	}
+	if got := collect(t, repo, Query{ParentID: parent.ID}); !reflect.DeepEqual(got, []string{"answer", "another answer"}) {
+		t.Errorf("Replies are %q", got)
+	}
+	if got := collect(t, repo, Query{TopLevel: true}); !reflect.DeepEqual(got, []string{"question", "unrelated"}) {
+		t.Errorf("Top-level messages are %q", got)
+	}
+
+	if _, err := repo.Reply(999, "bob", "orphan"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected ErrMessageNotFound for a missing parent, got %v", err)
+	}
+	if _, err := repo.Reply(0, "bob", "orphan"); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Expected ErrInvalidID, got %v", err)
+	}
+
+	// Replies outlive their parent and no longer count towards it
+	repo.Delete(first.ID)
+	if got, _ := repo.GetByID(parent.ID); got.ReplyCount != 1 {
+		t.Errorf("Expected 1 reply after a delete, got %+v", got)
+	}
+	repo.Delete(parent.ID)
+	if got := collect(t, repo, Query{ParentID: parent.ID}); !reflect.DeepEqual(got, []string{"another answer"}) {
+		t.Errorf("Replies after deleting the parent are %q", got)
+	}
+	if got, _ := repo.GetByID(other.ID); got.ReplyCount != 0 {
+		t.Errorf("Expected no replies, got %+v", got)
+	}
+}
+
+func testRepositoryReactions(t *testing.T, repo MessageRepository) {
+	msg, _ := repo.Create("alice", "hello")
+	repo.AddReaction(msg.ID, "alice", "👍")
+	repo.AddReaction(msg.ID, "bob", "🎉")
+	got, err := repo.AddReaction(msg.ID, "bob", "👍")
+	if err != nil {
+		t.Fatalf("AddReaction failed: %v", err)
+	}
+	want := []models.ReactionCount{{Emoji: "👍", Count: 2}, {Emoji: "🎉", Count: 1}}
+	if !reflect.DeepEqual(got.Reactions, want) {
+		t.Errorf("Reactions are %+v, want %+v", got.Reactions, want)
+	}
+	if got, _ := repo.GetByID(msg.ID); !reflect.DeepEqual(got.Reactions, want) {
+		t.Errorf("Stored reactions are %+v, want %+v", got.Reactions, want)
+	}
+	if page, _ := repo.Query(Query{}); !reflect.DeepEqual(page.Messages[0].Reactions, want) {
+		t.Errorf("Queried reactions are %+v, want %+v", page.Messages[0].Reactions, want)
+	}
+
+	if _, err := repo.AddReaction(msg.ID, "bob", "👍"); !errors.Is(err, ErrDuplicateReaction) {
+		t.Errorf("Expected ErrDuplicateReaction, got %v", err)
+	}
+	if _, err := repo.AddReaction(999, "bob", "👍"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected ErrMessageNotFound, got %v", err)
+	}
+
+	got, err = repo.RemoveReaction(msg.ID, "bob", "🎉")
+	if err != nil {
+		t.Fatalf("RemoveReaction failed: %v", err)
+	}
+	if want := []models.ReactionCount{{Emoji: "👍", Count: 2}}; !reflect.DeepEqual(got.Reactions, want) {
+		t.Errorf("Reactions are %+v, want %+v", got.Reactions, want)
+	}
+	if _, err := repo.RemoveReaction(msg.ID, "bob", "🎉"); !errors.Is(err, ErrReactionNotFound) {
+		t.Errorf("Expected ErrReactionNotFound, got %v", err)
+	}
+	if _, err := repo.RemoveReaction(999, "bob", "🎉"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected ErrMessageNotFound, got %v", err)
+	}
+
+	// Reactions go with their message, and a new one starts without any
+	repo.Delete(msg.ID)
+	if next, _ := repo.Create("alice", "again"); next.Reactions != nil {
+		t.Errorf("Expected no reactions, got %+v", next.Reactions)
+	}
+}
+
+func testRepositoryImport(t *testing.T, repo MessageRepository) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	edited := created.Add(time.Hour)
+	reply, err := repo.Import(models.Message{ID: 12, Username: "bob", Content: "answer", Timestamp: created.Add(time.Minute), ParentID: 10})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	parent, err := repo.Import(models.Message{ID: 10, Username: "alice", Content: "question", Timestamp: created, Version: 3, EditedAt: &edited})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if parent.ID != 10 || !parent.Timestamp.Equal(created) || parent.Version != 3 || !parent.EditedAt.Equal(edited) || parent.ReplyCount != 1 {
+		t.Errorf("Unexpected imported parent %+v", parent)
+	}
+	if reply.ID != 12 || reply.ParentID != 10 || reply.Version != 1 {
+		t.Errorf("Unexpected imported reply %+v", reply)
+	}
+	if got, _ := repo.GetByID(10); !reflect.DeepEqual(got, parent) {
+		t.Errorf("GetByID returned %+v, want %+v", got, parent)
+	}
+	if history, _ := repo.History(10); len(history) != 1 || history[0].Version != 3 || !history[0].Timestamp.Equal(edited) {
+		t.Errorf("Unexpected history %+v", history)
+	}
+
+	if _, err := repo.Import(models.Message{ID: 10, Username: "carol", Content: "again"}); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("Expected ErrDuplicateID, got %v", err)
+	}
+	if _, err := repo.Import(models.Message{ID: -1, Username: "carol", Content: "bad"}); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Expected ErrInvalidID, got %v", err)
+	}
+
+	// New IDs continue above the imported ones
+	renumbered, _ := repo.Import(models.Message{Username: "carol", Content: "renumbered"})
+	next, _ := repo.Create("dave", "new")
+	if renumbered.ID <= 12 || next.ID <= renumbered.ID || renumbered.Timestamp.IsZero() {
+		t.Errorf("Expected IDs above 12, got %+v and %+v", renumbered, next)
+	}
+}
+
+func TestSQLiteStorage_MigratesOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.db")
+	old := sqliteDialect
+	old.migrations = old.migrations[:1]
+	repo, err := openSQL(old, path, nil)
+	if err != nil {
+		t.Fatalf("Opening with the first migration failed: %v", err)
+	}
+	repo.db.Exec("INSERT INTO messages (username, content, created_at) VALUES ('alice', 'from before', ?)", now())
+	repo.Close()
+
+	migrated := openTestRepository(t, Config{Driver: DriverSQLite, DSN: path})
+	msg, err := migrated.UpdateIfVersion(1, 1, "edited")
+	if err != nil || msg.Version != 2 {
+		t.Fatalf("Got %+v, %v after migrating", msg, err)
+	}
+	if history, _ := migrated.History(1); len(history) != 2 || history[0].Content != "from before" {
+		t.Errorf("Unexpected history after migrating: %+v", history)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	p := position{timestamp: time.Date(2025, 1, 2, 3, 4, 5, 123456789, time.UTC), id: 42}
+	got, err := decodeCursor(encodeCursor(p))
+	if err != nil || !got.timestamp.Equal(p.timestamp) || got.id != p.id {
+		t.Errorf("Got %+v, %v; want %+v", got, err, p)
+	}
+
+	// Equal timestamps are ordered by ID
+	if !p.before(position{timestamp: p.timestamp, id: 43}) || p.before(position{timestamp: p.timestamp, id: 41}) {
+		t.Error("Expected ties to be broken by ID")
+	}
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	if _, err := Open(Config{Driver: "oracle"}); err == nil {
+		t.Error("Expected an error for an unknown driver")
+	}
+}
+
+func TestSQLiteStorage_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.db")
+	repo := openTestRepository(t, Config{Driver: DriverSQLite, DSN: path})
+	created, _ := repo.Create("alice", "kept on disk")
+	repo.Close()
+
+	repo = openTestRepository(t, Config{Driver: DriverSQLite, DSN: path})
+	got, err := repo.GetByID(created.ID)
+	if err != nil || !reflect.DeepEqual(got, created) {
+		t.Errorf("Got %+v, %v after reopening; want %+v", got, err, created)
+	}
+}
+
+func TestNumberedPlaceholders(t *testing.T) {
+	got := numberedPlaceholders("UPDATE messages SET content = ? WHERE id = ?")
+	if want := "UPDATE messages SET content = $1 WHERE id = $2"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,564 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lab03-backend/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect holds what differs between the SQL databases SQLStorage supports
+type dialect struct {
+	driver string
+	// migrations are applied in order on open; each is a list of statements
+	// run in one transaction. Append new migrations, never edit old ones.
+	migrations [][]string
+	// placeholders rewrites the "?" placeholders in a query when the driver
+	// uses another syntax
+	placeholders func(query string) string
+	// syncIDs, when set, moves the ID sequence past IDs inserted explicitly
+	syncIDs string
+}
+
+// SQLStorage stores messages in a SQL database
+type SQLStorage struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// openSQL connects to the database and creates the schema
+func openSQL(d dialect, dsn string, configure func(*sql.DB)) (*SQLStorage, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", d.driver, err)
+	}
+	if configure != nil {
+		configure(db)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to %s: %w", d.driver, err)
+	}
+
+	s := &SQLStorage{db: db, dialect: d}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies the migrations that have not run yet
+func (s *SQLStorage) migrate() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)"); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	var applied int
+	if err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&applied); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+
+	for version := applied + 1; version <= len(s.dialect.migrations); version++ {
+		err := s.inTx(func(tx *sql.Tx) error {
+			for _, stmt := range s.dialect.migrations[version-1] {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(s.query("INSERT INTO schema_migrations (version) VALUES (?)"), version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, committing if it returns nil
+func (s *SQLStorage) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// query rewrites placeholders for the dialect
+func (s *SQLStorage) query(q string) string {
+	if s.dialect.placeholders == nil {
+		return q
+	}
+	return s.dialect.placeholders(q)
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// messageColumns are read by scanMessage from "messages m"
+const messageColumns = "id, username, content, created_at, version, edited_at, parent_id, " +
+	"(SELECT COUNT(*) FROM messages r WHERE r.parent_id = m.id)"
+
+// scanMessage reads the columns selected by messageColumns
+func scanMessage(row interface{ Scan(...any) error }) (*models.Message, error) {
+	var msg models.Message
+	var editedAt sql.NullTime
+	var parentID sql.NullInt64
+	if err := row.Scan(&msg.ID, &msg.Username, &msg.Content, &msg.Timestamp, &msg.Version, &editedAt, &parentID, &msg.ReplyCount); err != nil {
+		return nil, err
+	}
+	msg.Timestamp = msg.Timestamp.UTC()
+	if editedAt.Valid {
+		t := editedAt.Time.UTC()
+		msg.EditedAt = &t
+	}
+	msg.ParentID = int(parentID.Int64)
+	return &msg, nil
+}
+
+// selectMessages returns the messages selected by the clauses following
+// "SELECT ... FROM messages m", with their reactions
+func (s *SQLStorage) selectMessages(q querier, clauses string, args ...any) ([]*models.Message, error) {
+	rows, err := q.Query(s.query("SELECT "+messageColumns+" FROM messages m "+clauses), args...)
+	if err != nil {
+		return nil, err
+	}
+	messages := []*models.Message{}
+	byID := make(map[int]*models.Message)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		messages = append(messages, msg)
+		byID[msg.ID] = msg
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return messages, nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(messages)-1) + "?"
+	ids := make([]any, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	rows, err = q.Query(s.query("SELECT message_id, emoji, COUNT(*) FROM message_reactions WHERE message_id IN ("+
+		placeholders+") GROUP BY message_id, emoji"), ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[int]map[string]int)
+	for rows.Next() {
+		var id, n int
+		var emoji string
+		if err := rows.Scan(&id, &emoji, &n); err != nil {
+			return nil, err
+		}
+		if counts[id] == nil {
+			counts[id] = make(map[string]int)
+		}
+		counts[id][emoji] = n
+	}
+	for id, c := range counts {
+		byID[id].Reactions = reactionCounts(c)
+	}
+	return messages, rows.Err()
+}
+
+// get returns one message
+func (s *SQLStorage) get(q querier, id int) (*models.Message, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+	messages, err := s.selectMessages(q, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return messages[0], nil
+}
+
+// now returns the current time at the precision every supported database keeps,
+// so returned messages are identical to what is read back later
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// List returns every message ordered by ID
+func (s *SQLStorage) List() ([]*models.Message, error) {
+	return s.selectMessages(s.db, "ORDER BY id")
+}
+
+// GetByID returns a message by its ID
+func (s *SQLStorage) GetByID(id int) (*models.Message, error) {
+	return s.get(s.db, id)
+}
+
+// Create adds a new message
+func (s *SQLStorage) Create(username, content string) (*models.Message, error) {
+	return s.create(username, content, 0)
+}
+
+// Reply adds a message replying to parentID
+func (s *SQLStorage) Reply(parentID int, username, content string) (*models.Message, error) {
+	if parentID <= 0 {
+		return nil, ErrInvalidID
+	}
+	return s.create(username, content, parentID)
+}
+
+func (s *SQLStorage) create(username, content string, parentID int) (*models.Message, error) {
+	var msg *models.Message
+	err := s.inTx(func(tx *sql.Tx) error {
+		parent := sql.NullInt64{Int64: int64(parentID), Valid: parentID != 0}
+		if parent.Valid {
+			if err := s.mustExist(tx, parentID); err != nil {
+				return err
+			}
+		}
+
+		var id int
+		createdAt := now()
+		if err := tx.QueryRow(s.query(
+			"INSERT INTO messages (username, content, created_at, version, parent_id) VALUES (?, ?, ?, 1, ?) RETURNING id"),
+			username, content, createdAt, parent).Scan(&id); err != nil {
+			return err
+		}
+		if err := s.addRevision(tx, id, 1, content, createdAt); err != nil {
+			return err
+		}
+		var err error
+		msg, err = s.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Import stores msg as given, keeping its ID unless it is 0
+func (s *SQLStorage) Import(msg models.Message) (*models.Message, error) {
+	revised, err := prepareImport(&msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var imported *models.Message
+	err = s.inTx(func(tx *sql.Tx) error {
+		parent := sql.NullInt64{Int64: int64(msg.ParentID), Valid: msg.ParentID != 0}
+		id := msg.ID
+		if id == 0 {
+			if err := tx.QueryRow(s.query(
+				"INSERT INTO messages (username, content, created_at, version, edited_at, parent_id) VALUES (?, ?, ?, ?, ?, ?) RETURNING id"),
+				msg.Username, msg.Content, msg.Timestamp, msg.Version, msg.EditedAt, parent).Scan(&id); err != nil {
+				return err
+			}
+		} else {
+			if err := s.mustExist(tx, id); err == nil {
+				return ErrDuplicateID
+			} else if !errors.Is(err, ErrMessageNotFound) {
+				return err
+			}
+			if _, err := tx.Exec(s.query(
+				"INSERT INTO messages (id, username, content, created_at, version, edited_at, parent_id) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+				id, msg.Username, msg.Content, msg.Timestamp, msg.Version, msg.EditedAt, parent); err != nil {
+				return err
+			}
+			if s.dialect.syncIDs != "" {
+				if _, err := tx.Exec(s.dialect.syncIDs); err != nil {
+					return err
+				}
+			}
+		}
+		if err := s.addRevision(tx, id, msg.Version, msg.Content, revised); err != nil {
+			return err
+		}
+		var err error
+		imported, err = s.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// mustExist returns ErrMessageNotFound unless message id exists
+func (s *SQLStorage) mustExist(q querier, id int) error {
+	var exists bool
+	if err := q.QueryRow(s.query("SELECT EXISTS (SELECT 1 FROM messages WHERE id = ?)"), id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// Update modifies the content of an existing message
+func (s *SQLStorage) Update(id int, content string) (*models.Message, error) {
+	return s.UpdateIfVersion(id, 0, content)
+}
+
+// UpdateIfVersion modifies a message if its version is still version, or
+// unconditionally when version is 0
+func (s *SQLStorage) UpdateIfVersion(id, version int, content string) (*models.Message, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+
+	var msg *models.Message
+	err := s.inTx(func(tx *sql.Tx) error {
+		editedAt := now()
+		query := "UPDATE messages SET content = ?, version = version + 1, edited_at = ? WHERE id = ?"
+		args := []any{content, editedAt, id}
+		if version != 0 {
+			query += " AND version = ?"
+			args = append(args, version)
+		}
+
+		var newVersion int
+		err := tx.QueryRow(s.query(query+" RETURNING version"), args...).Scan(&newVersion)
+		if errors.Is(err, sql.ErrNoRows) {
+			if err := s.mustExist(tx, id); err != nil {
+				return err
+			}
+			return ErrVersionConflict
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.addRevision(tx, id, newVersion, content, editedAt); err != nil {
+			return err
+		}
+		msg, err = s.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *SQLStorage) addRevision(tx *sql.Tx, id, version int, content string, at time.Time) error {
+	_, err := tx.Exec(s.query("INSERT INTO message_revisions (message_id, version, content, created_at) VALUES (?, ?, ?, ?)"),
+		id, version, content, at)
+	return err
+}
+
+// History returns every revision of a message, oldest first
+func (s *SQLStorage) History(id int) ([]models.Revision, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+	rows, err := s.db.Query(s.query(
+		"SELECT version, content, created_at FROM message_revisions WHERE message_id = ? ORDER BY version"), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.Revision
+	for rows.Next() {
+		var rev models.Revision
+		if err := rows.Scan(&rev.Version, &rev.Content, &rev.Timestamp); err != nil {
+			return nil, err
+		}
+		rev.Timestamp = rev.Timestamp.UTC()
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Every message has at least its original revision
+	if len(revisions) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return revisions, nil
+}
+
+// Delete removes a message
+func (s *SQLStorage) Delete(id int) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	result, err := s.db.Exec(s.query("DELETE FROM messages WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// AddReaction records username reacting to a message with emoji
+func (s *SQLStorage) AddReaction(id int, username, emoji string) (*models.Message, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+	var msg *models.Message
+	err := s.inTx(func(tx *sql.Tx) error {
+		if err := s.mustExist(tx, id); err != nil {
+			return err
+		}
+		result, err := tx.Exec(s.query(
+			"INSERT INTO message_reactions (message_id, username, emoji, created_at) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING"),
+			id, username, emoji, now())
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrDuplicateReaction
+		}
+		msg, err = s.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// RemoveReaction removes a reaction added by AddReaction
+func (s *SQLStorage) RemoveReaction(id int, username, emoji string) (*models.Message, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+	var msg *models.Message
+	err := s.inTx(func(tx *sql.Tx) error {
+		if err := s.mustExist(tx, id); err != nil {
+			return err
+		}
+		result, err := tx.Exec(s.query(
+			"DELETE FROM message_reactions WHERE message_id = ? AND username = ? AND emoji = ?"), id, username, emoji)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrReactionNotFound
+		}
+		msg, err = s.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Query returns one page of messages matching q
+func (s *SQLStorage) Query(q Query) (Page, error) {
+	start, err := q.start(s.GetByID)
+	if err != nil {
+		return Page{}, err
+	}
+	limit := q.pageSize()
+
+	var where []string
+	var args []any
+	if q.ParentID != 0 {
+		where = append(where, "parent_id = ?")
+		args = append(args, q.ParentID)
+	}
+	if q.TopLevel {
+		where = append(where, "parent_id IS NULL")
+	}
+	if q.Username != "" {
+		where = append(where, "username = ?")
+		args = append(args, q.Username)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, q.Until.UTC())
+	}
+	if q.Search != "" {
+		where = append(where, `LOWER(content) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(strings.ToLower(q.Search))+"%")
+	}
+	order := "ASC"
+	compare := ">"
+	if q.Descending {
+		order = "DESC"
+		compare = "<"
+	}
+	if start != nil {
+		where = append(where, fmt.Sprintf("(created_at %[1]s ? OR (created_at = ? AND id %[1]s ?))", compare))
+		args = append(args, start.timestamp.UTC(), start.timestamp.UTC(), start.id)
+	}
+
+	var clauses string
+	if len(where) > 0 {
+		clauses = "WHERE " + strings.Join(where, " AND ")
+	}
+	clauses += fmt.Sprintf(" ORDER BY created_at %[1]s, id %[1]s LIMIT ?", order)
+	args = append(args, limit+1)
+
+	results, err := s.selectMessages(s.db, clauses, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	return paginate(results, limit), nil
+}
+
+// escapeLike escapes the LIKE wildcards in s, using backslash as the escape
+// character
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Total returns the number of stored messages
+func (s *SQLStorage) Total() (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&n)
+	return n, err
+}
+
+// Close closes the database connection
+func (s *SQLStorage) Close() error {
+	return s.db.Close()
+}
+
+// numberedPlaceholders rewrites "?" placeholders as $1, $2, ...
+func numberedPlaceholders(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var sqliteDialect = dialect{
+	driver: "sqlite3",
+	migrations: [][]string{
+		{`CREATE TABLE IF NOT EXISTS messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			content TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)`},
+		{
+			`ALTER TABLE messages ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+			`ALTER TABLE messages ADD COLUMN edited_at DATETIME`,
+			`CREATE TABLE message_revisions (
+				message_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+				version INTEGER NOT NULL,
+				content TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				PRIMARY KEY (message_id, version)
+			)`,
+			`INSERT INTO message_revisions (message_id, version, content, created_at)
+				SELECT id, 1, content, created_at FROM messages`,
+		},
+		{
+			// Replies outlive a deleted parent, so parent_id has no foreign key
+			`ALTER TABLE messages ADD COLUMN parent_id INTEGER`,
+			`CREATE INDEX messages_parent_id ON messages (parent_id)`,
+			`CREATE TABLE message_reactions (
+				message_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+				username TEXT NOT NULL,
+				emoji TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				PRIMARY KEY (message_id, username, emoji)
+			)`,
+		},
+	},
+}
+
+// NewSQLiteStorage opens or creates a SQLite database. An empty path or
+// ":memory:" keeps the database in memory for the lifetime of the storage.
+func NewSQLiteStorage(path string) (*SQLStorage, error) {
+	if path == "" {
+		path = ":memory:"
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	// SQLite serialises writers anyway, and every connection to ":memory:"
+	// would see its own database
+	return openSQL(sqliteDialect, path+separator+"_busy_timeout=5000&_foreign_keys=on", func(db *sql.DB) {
+		db.SetMaxOpenConns(1)
+	})
+}
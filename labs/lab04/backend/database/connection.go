@@ -3,56 +3,187 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Database drivers accepted by InitDBWithConfig
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
 )
 
 // Config holds database configuration
+// Driver selects the database, DriverSQLite when empty. DSN is the Postgres
+// connection string; SQLite uses DatabasePath unless DSN is set.
 type Config struct {
+	Driver string
+	DSN    string
+	// Schema is the Postgres schema holding the tables, created if missing.
+	// Empty uses the server's search_path.
+	Schema          string
 	DatabasePath    string
 	MaxOpenConns    int
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
 	ConnMaxIdleTime time.Duration
+	// PingAttempts is how often to try reaching the database before giving
+	// up, at least once. PingBackoff is the wait after the first failure,
+	// doubled after each further one.
+	PingAttempts int
+	PingBackoff  time.Duration
 }
 
 // DefaultConfig returns a default database configuration
 func DefaultConfig() *Config {
 	return &Config{
+		Driver:          DriverSQLite,
 		DatabasePath:    "./lab04.db",
 		MaxOpenConns:    25,
 		MaxIdleConns:    5,
 		ConnMaxLifetime: 5 * time.Minute,
 		ConnMaxIdleTime: 2 * time.Minute,
+		PingAttempts:    5,
+		PingBackoff:     500 * time.Millisecond,
 	}
 }
 
-// TODO: Implement InitDB function
+// InitDB opens the database described by DefaultConfig
 func InitDB() (*sql.DB, error) {
-	// TODO: Initialize database connection with SQLite
-	// - Open database connection using sqlite3 driver
-	// - Apply connection pool configuration from DefaultConfig()
-	// - Test connection with Ping()
-	// - Return the database connection or error
-	return nil, fmt.Errorf("TODO: implement InitDB function")
+	return InitDBWithConfig(DefaultConfig())
 }
 
-// TODO: Implement InitDBWithConfig function
+// InitDBWithConfig opens the database described by config, applies the pool
+// settings and waits until the database answers
 func InitDBWithConfig(config *Config) (*sql.DB, error) {
-	// TODO: Initialize database connection with custom configuration
-	// - Open database connection using the provided config
-	// - Apply all connection pool settings
-	// - Test connection with Ping()
-	// - Return the database connection or error
-	return nil, fmt.Errorf("TODO: implement InitDBWithConfig function")
+	if config == nil {
+		return nil, fmt.Errorf("database config cannot be nil")
+	}
+
+	driverName, dsn, err := config.source()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %v", config.driver(), err)
+	}
+
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	if err := ping(db, config.PingAttempts, config.PingBackoff); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s database: %v", config.driver(), err)
+	}
+	if config.Schema != "" {
+		if _, err := db.Exec("CREATE SCHEMA IF NOT EXISTS " + pq.QuoteIdentifier(config.Schema)); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create schema %s: %v", config.Schema, err)
+		}
+	}
+	return db, nil
 }
 
-// TODO: Implement CloseDB function
+// CloseDB closes the database connection
 func CloseDB(db *sql.DB) error {
-	// TODO: Properly close database connection
-	// - Check if db is not nil
-	// - Close the database connection
-	// - Return any error that occurs
-	return fmt.Errorf("TODO: implement CloseDB function")
+	if db == nil {
+		return fmt.Errorf("database connection cannot be nil")
+	}
+	return db.Close()
+}
+
+// DriverOf returns the driver db was opened with, DriverSQLite or
+// DriverPostgres, or "" for other drivers
+func DriverOf(db *sql.DB) string {
+	switch db.Driver().(type) {
+	case *sqlite3.SQLiteDriver:
+		return DriverSQLite
+	case *pq.Driver:
+		return DriverPostgres
+	}
+	return ""
+}
+
+func (c *Config) driver() string {
+	if c.Driver == "" {
+		return DriverSQLite
+	}
+	return c.Driver
+}
+
+// source returns the database/sql driver name and data source for c
+func (c *Config) source() (driverName, dsn string, err error) {
+	switch c.driver() {
+	case DriverSQLite:
+		dsn := c.DSN
+		if dsn == "" {
+			dsn = c.DatabasePath
+		}
+		if dsn == "" {
+			return "", "", fmt.Errorf("database path cannot be empty")
+		}
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		// SQLite only enforces the ON DELETE CASCADE constraints when asked.
+		// The driver uses the first value of a parameter, so one already in
+		// the DSN wins.
+		return "sqlite3", dsn + separator + "_foreign_keys=on", nil
+	case DriverPostgres:
+		if c.DSN == "" {
+			return "", "", fmt.Errorf("postgres DSN cannot be empty")
+		}
+		if c.Schema == "" {
+			return "postgres", c.DSN, nil
+		}
+		if !schemaName.MatchString(c.Schema) {
+			return "", "", fmt.Errorf("invalid schema name %q", c.Schema)
+		}
+		// Every connection in the pool starts with the schema as its search_path
+		dsn, err := withPostgresParam(c.DSN, "search_path", c.Schema)
+		return "postgres", dsn, err
+	default:
+		return "", "", fmt.Errorf("unknown database driver %q", c.Driver)
+	}
+}
+
+// schemaName matches the schema names Config accepts, which need no quoting
+// in a search_path
+var schemaName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// withPostgresParam adds a connection parameter to a URL or key=value DSN
+func withPostgresParam(dsn, key, value string) (string, error) {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " " + key + "=" + value, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid postgres DSN: %v", err)
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// ping tries to reach db up to attempts times, sleeping backoff after the
+// first failure and twice as long after each following one
+func ping(db *sql.DB, attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = db.Ping(); err == nil || attempt >= attempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
 }
@@ -2,6 +2,7 @@ package database
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -194,3 +195,121 @@ func TestCloseDB(t *testing.T) {
 		t.Error("Database should be closed and ping should fail")
 	}
 }
+
+func TestInitDBWithConfig_Drivers(t *testing.T) {
+	if _, err := InitDBWithConfig(&Config{Driver: "oracle", DSN: "x"}); err == nil {
+		t.Error("InitDBWithConfig() should reject unknown drivers")
+	}
+
+	if _, err := InitDBWithConfig(&Config{Driver: DriverPostgres}); err == nil {
+		t.Error("InitDBWithConfig() should require a DSN for postgres")
+	}
+
+	db, err := InitDBWithConfig(&Config{DatabasePath: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("InitDBWithConfig() failed: %v", err)
+	}
+	defer CloseDB(db)
+
+	if driver := DriverOf(db); driver != DriverSQLite {
+		t.Errorf("DriverOf() = %q, want %q", driver, DriverSQLite)
+	}
+}
+
+func TestConfig_SQLiteForeignKeys(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"path", Config{DatabasePath: filepath.Join(dir, "path.db")}},
+		{"path with parameters", Config{DatabasePath: filepath.Join(dir, "params.db") + "?_busy_timeout=5000"}},
+		{"DSN", Config{DSN: "file:" + filepath.Join(dir, "dsn.db")}},
+		{"DSN with parameters", Config{DSN: "file:" + filepath.Join(dir, "dsn_params.db") + "?cache=shared"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := InitDBWithConfig(&tt.config)
+			if err != nil {
+				t.Fatalf("InitDBWithConfig() failed: %v", err)
+			}
+			defer CloseDB(db)
+
+			var enabled int
+			if err := db.QueryRow("PRAGMA foreign_keys").Scan(&enabled); err != nil {
+				t.Fatalf("Reading foreign_keys failed: %v", err)
+			}
+			if enabled != 1 {
+				t.Error("Foreign keys are not enforced")
+			}
+		})
+	}
+}
+
+func TestConfig_PostgresSchema(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"postgres://user@localhost/db?sslmode=disable", "postgres://user@localhost/db?search_path=app&sslmode=disable"},
+		{"postgresql://user@localhost/db", "postgresql://user@localhost/db?search_path=app"},
+		{"host=localhost dbname=db", "host=localhost dbname=db search_path=app"},
+	}
+	for _, tt := range tests {
+		_, dsn, err := (&Config{Driver: DriverPostgres, DSN: tt.dsn, Schema: "app"}).source()
+		if err != nil || dsn != tt.want {
+			t.Errorf("source() for %q = %q, %v, want %q", tt.dsn, dsn, err, tt.want)
+		}
+	}
+
+	if _, _, err := (&Config{Driver: DriverPostgres, DSN: "host=localhost", Schema: "app; DROP"}).source(); err == nil {
+		t.Error("source() should reject invalid schema names")
+	}
+}
+
+func TestInitDBWithConfig_PingRetries(t *testing.T) {
+	// Nothing listens on port 1, so every attempt fails
+	config := &Config{
+		Driver:       DriverPostgres,
+		DSN:          "postgres://user@127.0.0.1:1/db?sslmode=disable&connect_timeout=1",
+		PingAttempts: 3,
+		PingBackoff:  20 * time.Millisecond,
+	}
+
+	start := time.Now()
+	if _, err := InitDBWithConfig(config); err == nil {
+		t.Fatal("InitDBWithConfig() should fail when the database is unreachable")
+	}
+
+	// Two waits: 20ms, then 40ms
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("InitDBWithConfig() gave up after %v, want at least 60ms of backoff", elapsed)
+	}
+}
+
+func TestMigrate_Postgres(t *testing.T) {
+	dsn := os.Getenv("LAB04_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LAB04_POSTGRES_DSN is not set")
+	}
+
+	db, err := InitDBWithConfig(&Config{Driver: DriverPostgres, DSN: dsn, Schema: testSchema, MaxOpenConns: 5, MaxIdleConns: 1})
+	if err != nil {
+		t.Fatalf("InitDBWithConfig() failed: %v", err)
+	}
+	defer CloseDB(db)
+
+	if driver := DriverOf(db); driver != DriverPostgres {
+		t.Errorf("DriverOf() = %q, want %q", driver, DriverPostgres)
+	}
+
+	if err := RunMigrations(db); err != nil {
+		t.Fatalf("RunMigrations() failed: %v", err)
+	}
+
+	for _, table := range []string{"users", "posts", "categories", "post_categories"} {
+		if _, err := db.Exec("SELECT COUNT(*) FROM " + table); err != nil {
+			t.Errorf("%s table not created properly: %v", table, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// OpenGORM returns a GORM handle using db's connection pool, so both see the
+// same database and GORM needs no configuration of its own
+func OpenGORM(db *sql.DB) (*gorm.DB, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database connection cannot be nil")
+	}
+
+	var dialector gorm.Dialector
+	switch DriverOf(db) {
+	case DriverSQLite:
+		dialector = sqlite.New(sqlite.Config{Conn: db})
+	case DriverPostgres:
+		dialector = postgres.New(postgres.Config{Conn: db})
+	default:
+		return nil, fmt.Errorf("GORM does not support database driver %T", db.Driver())
+	}
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GORM: %v", err)
+	}
+	return gormDB, nil
+}
@@ -1,48 +1,218 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"lab04-backend/migrations"
 
 	"github.com/pressly/goose/v3"
 )
 
-// RunMigrations runs database migrations using goose
+// Migration kinds accepted by CreateMigration
+const (
+	MigrationSQL = "sql"
+	MigrationGo  = "go"
+)
+
+// RunMigrations applies every pending migration
 func RunMigrations(db *sql.DB) error {
+	provider, err := migrationProvider(db)
+	if err != nil {
+		return err
+	}
+	if _, err := provider.Up(context.Background()); err != nil {
+		return fmt.Errorf("failed to run migrations: %v", err)
+	}
+	return nil
+}
+
+// RollbackMigration rolls back the last applied migration
+func RollbackMigration(db *sql.DB) error {
+	provider, err := migrationProvider(db)
+	if err != nil {
+		return err
+	}
+	if _, err := provider.Down(context.Background()); err != nil {
+		return fmt.Errorf("failed to roll back migration: %v", err)
+	}
+	return nil
+}
+
+// ResetMigrations rolls back every applied migration, removing all tables
+// and data
+func ResetMigrations(db *sql.DB) error {
+	provider, err := migrationProvider(db)
+	if err != nil {
+		return err
+	}
+	if _, err := provider.DownTo(context.Background(), 0); err != nil {
+		return fmt.Errorf("failed to reset migrations: %v", err)
+	}
+	return nil
+}
+
+// GetMigrationStatus returns every known migration, oldest first, with
+// whether and when it was applied
+func GetMigrationStatus(db *sql.DB) ([]*goose.MigrationStatus, error) {
+	provider, err := migrationProvider(db)
+	if err != nil {
+		return nil, err
+	}
+	status, err := provider.Status(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration status: %v", err)
+	}
+	return status, nil
+}
+
+// CreateMigration writes an empty migration called name to the migrations
+// directory dir and returns the paths of the new files. A MigrationSQL
+// migration gets one file for SQLite and one with the same version for
+// Postgres; a MigrationGo migration is a single file run on both.
+func CreateMigration(dir, name, kind string) ([]string, error) {
+	name = snakeCase(name)
+	if name == "" {
+		return nil, fmt.Errorf("migration name cannot be empty")
+	}
+	version := time.Now().UTC().Format("20060102150405")
+	base := version + "_" + name
+
+	var paths []string
+	tmpl := sqlMigrationTemplate
+	switch kind {
+	case MigrationSQL:
+		paths = []string{filepath.Join(dir, base+".sql"), filepath.Join(dir, "postgres", base+".sql")}
+	case MigrationGo:
+		paths = []string{filepath.Join(dir, base+".go")}
+		tmpl = goMigrationTemplate
+	default:
+		return nil, fmt.Errorf("unknown migration kind %q, want %q or %q", kind, MigrationSQL, MigrationGo)
+	}
+
+	for i, path := range paths {
+		if err := writeMigration(path, tmpl, camelCase(name)); err != nil {
+			for _, written := range paths[:i] {
+				os.Remove(written)
+			}
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// migrationProvider returns a goose provider for the embedded migrations of
+// the database db is connected to
+func migrationProvider(db *sql.DB) (*goose.Provider, error) {
 	if db == nil {
-		return fmt.Errorf("database connection cannot be nil")
+		return nil, fmt.Errorf("database connection cannot be nil")
 	}
 
-	// Set goose dialect for SQLite
-	if err := goose.SetDialect("sqlite3"); err != nil {
-		return fmt.Errorf("failed to set goose dialect: %v", err)
+	var provider *goose.Provider
+	var err error
+	switch DriverOf(db) {
+	case DriverSQLite:
+		provider, err = goose.NewProvider(goose.DialectSQLite3, db, migrations.SQLite())
+	case DriverPostgres:
+		provider, err = goose.NewProvider(goose.DialectPostgres, db, migrations.Postgres())
+	default:
+		return nil, fmt.Errorf("no migrations for database driver %T", db.Driver())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %v", err)
 	}
+	return provider, nil
+}
 
-	// Get path to migrations directory (relative to backend directory)
-	migrationsDir := "../migrations"
+func writeMigration(path string, tmpl *template.Template, name string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create migration file: %v", err)
+	}
+	if err := tmpl.Execute(f, name); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to write migration file: %v", err)
+	}
+	return f.Close()
+}
 
-	// Run migrations from the migrations directory
-	if err := goose.Up(db, migrationsDir); err != nil {
-		return fmt.Errorf("failed to run migrations: %v", err)
+// snakeCase turns "Add user roles" or "addUserRoles" into "add_user_roles"
+func snakeCase(name string) string {
+	var b strings.Builder
+	separate := false
+	for i, c := range strings.TrimSpace(name) {
+		switch {
+		case unicode.IsUpper(c):
+			if i > 0 {
+				separate = true
+			}
+			c = unicode.ToLower(c)
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			separate = true
+			continue
+		}
+		if separate && b.Len() > 0 {
+			b.WriteByte('_')
+		}
+		separate = false
+		b.WriteRune(c)
 	}
+	return b.String()
+}
 
-	return nil
+// camelCase turns "add_user_roles" into "AddUserRoles"
+func camelCase(name string) string {
+	var b strings.Builder
+	for _, word := range strings.Split(name, "_") {
+		for i, c := range word {
+			if i == 0 {
+				c = unicode.ToUpper(c)
+			}
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
 }
 
-// TODO: Implement this function
-// RollbackMigration rolls back the last migration using goose
-func RollbackMigration(db *sql.DB) error {
-	return nil
+var sqlMigrationTemplate = template.Must(template.New("sql").Parse(`-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
+`))
+
+var goMigrationTemplate = template.Must(template.New("go").Parse(`package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(up{{.}}, down{{.}})
 }
 
-// TODO: Implement this function
-// GetMigrationStatus checks migration status using goose
-func GetMigrationStatus(db *sql.DB) error {
+func up{{.}}(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
 	return nil
 }
 
-// TODO: Implement this function
-// CreateMigration creates a new migration file
-func CreateMigration(name string) error {
+func down{{.}}(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
 	return nil
 }
+`))
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pressly/goose/v3"
+)
+
+// freshDatabases returns a constructor for every database available in this
+// environment, each returning a database with no migrations applied.
+// Postgres runs only when LAB04_POSTGRES_DSN is set, in a schema of its own.
+func freshDatabases() map[string]func(t *testing.T) *sql.DB {
+	databases := map[string]func(t *testing.T) *sql.DB{
+		DriverSQLite: func(t *testing.T) *sql.DB {
+			return openTestDB(t, &Config{DatabasePath: filepath.Join(t.TempDir(), "test.db"), MaxOpenConns: 1})
+		},
+	}
+	if dsn := os.Getenv("LAB04_POSTGRES_DSN"); dsn != "" {
+		databases[DriverPostgres] = func(t *testing.T) *sql.DB {
+			db := openTestDB(t, &Config{Driver: DriverPostgres, DSN: dsn, Schema: testSchema, MaxOpenConns: 1})
+			for appliedCount(t, db) > 0 {
+				if err := RollbackMigration(db); err != nil {
+					t.Fatalf("RollbackMigration() failed: %v", err)
+				}
+			}
+			return db
+		}
+	}
+	return databases
+}
+
+// testSchema keeps this package's Postgres tables apart from the ones the
+// repository tests use
+const testSchema = "lab04_database_test"
+
+func openTestDB(t *testing.T, config *Config) *sql.DB {
+	t.Helper()
+	db, err := InitDBWithConfig(config)
+	if err != nil {
+		t.Fatalf("InitDBWithConfig() failed: %v", err)
+	}
+	t.Cleanup(func() { CloseDB(db) })
+	return db
+}
+
+// appliedCount returns how many migrations are applied to db
+func appliedCount(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	status, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatalf("GetMigrationStatus() failed: %v", err)
+	}
+	applied := 0
+	for _, s := range status {
+		if s.State == goose.StatePending {
+			continue
+		}
+		if s.AppliedAt.IsZero() {
+			t.Errorf("Migration %d is applied without a time", s.Source.Version)
+		}
+		applied++
+	}
+	return applied
+}
+
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	t.Helper()
+	var query string
+	switch DriverOf(db) {
+	case DriverPostgres:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+	default:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1"
+	}
+	var count int
+	if err := db.QueryRow(query, table).Scan(&count); err != nil {
+		t.Fatalf("Looking up table %s failed: %v", table, err)
+	}
+	return count > 0
+}
+
+// TestMigrations_UpAndDown applies every migration, then rolls them back one
+// at a time, checking the status and schema at each step
+func TestMigrations_UpAndDown(t *testing.T) {
+	// A Go migration runs alongside the embedded SQL ones
+	goose.AddNamedMigrationContext("29990101000000_go_migration.go",
+		func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "CREATE TABLE go_migration (id INTEGER PRIMARY KEY)")
+			return err
+		},
+		func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "DROP TABLE go_migration")
+			return err
+		},
+	)
+	defer goose.ResetGlobalMigrations()
+
+	// Tables created by each migration, oldest first
+	steps := [][]string{
+		{"users"},
+		{"posts"},
+		{"categories", "post_categories"},
+		{"go_migration"},
+	}
+
+	for name, open := range freshDatabases() {
+		t.Run(name, func(t *testing.T) {
+			db := open(t)
+
+			status, err := GetMigrationStatus(db)
+			if err != nil {
+				t.Fatalf("GetMigrationStatus() failed: %v", err)
+			}
+			if len(status) != len(steps) {
+				t.Fatalf("GetMigrationStatus() returned %d migrations, want %d", len(status), len(steps))
+			}
+			if applied := appliedCount(t, db); applied != 0 {
+				t.Fatalf("Fresh database has %d migrations applied", applied)
+			}
+
+			if err := RunMigrations(db); err != nil {
+				t.Fatalf("RunMigrations() failed: %v", err)
+			}
+			if applied := appliedCount(t, db); applied != len(steps) {
+				t.Errorf("After RunMigrations() %d migrations are applied, want %d", applied, len(steps))
+			}
+			for _, tables := range steps {
+				for _, table := range tables {
+					if !tableExists(t, db, table) {
+						t.Errorf("Table %s was not created", table)
+					}
+				}
+			}
+
+			// Running them again does nothing
+			if err := RunMigrations(db); err != nil {
+				t.Fatalf("Second RunMigrations() failed: %v", err)
+			}
+
+			for i := len(steps) - 1; i >= 0; i-- {
+				if err := RollbackMigration(db); err != nil {
+					t.Fatalf("RollbackMigration() of step %d failed: %v", i+1, err)
+				}
+				if applied := appliedCount(t, db); applied != i {
+					t.Errorf("After rolling back step %d, %d migrations are applied, want %d", i+1, applied, i)
+				}
+				for _, table := range steps[i] {
+					if tableExists(t, db, table) {
+						t.Errorf("Table %s was not dropped", table)
+					}
+				}
+			}
+
+			if err := RollbackMigration(db); err == nil {
+				t.Error("RollbackMigration() should fail with no migrations applied")
+			}
+
+			// And they apply again from scratch
+			if err := RunMigrations(db); err != nil {
+				t.Fatalf("RunMigrations() after rollback failed: %v", err)
+			}
+			if applied := appliedCount(t, db); applied != len(steps) {
+				t.Errorf("After reapplying, %d migrations are applied, want %d", applied, len(steps))
+			}
+
+			// Resetting rolls them all back at once
+			for i := 0; i < 2; i++ {
+				if err := ResetMigrations(db); err != nil {
+					t.Fatalf("ResetMigrations() #%d failed: %v", i+1, err)
+				}
+			}
+			if applied := appliedCount(t, db); applied != 0 {
+				t.Errorf("After ResetMigrations() %d migrations are applied", applied)
+			}
+			if tableExists(t, db, "users") {
+				t.Error("Table users was not dropped by ResetMigrations()")
+			}
+		})
+	}
+}
+
+func TestMigrations_NilDatabase(t *testing.T) {
+	if err := RunMigrations(nil); err == nil {
+		t.Error("RunMigrations(nil) should return an error")
+	}
+	if err := RollbackMigration(nil); err == nil {
+		t.Error("RollbackMigration(nil) should return an error")
+	}
+	if err := ResetMigrations(nil); err == nil {
+		t.Error("ResetMigrations(nil) should return an error")
+	}
+	if _, err := GetMigrationStatus(nil); err == nil {
+		t.Error("GetMigrationStatus(nil) should return an error")
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "postgres"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := CreateMigration(dir, "Add user roles", MigrationSQL)
+	if err != nil {
+		t.Fatalf("CreateMigration(sql) failed: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("CreateMigration(sql) created %d files, want 2", len(paths))
+	}
+	if filepath.Dir(paths[1]) != filepath.Join(dir, "postgres") {
+		t.Errorf("CreateMigration(sql) wrote %s, want it in postgres/", paths[1])
+	}
+	if filepath.Base(paths[0]) != filepath.Base(paths[1]) {
+		t.Errorf("SQLite and Postgres migrations differ: %s and %s", paths[0], paths[1])
+	}
+	if !strings.HasSuffix(paths[0], "_add_user_roles.sql") {
+		t.Errorf("CreateMigration(sql) wrote %s, want a name ending in _add_user_roles.sql", paths[0])
+	}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "-- +goose Up") || !strings.Contains(string(content), "-- +goose Down") {
+			t.Errorf("%s is not a goose SQL migration:\n%s", path, content)
+		}
+	}
+
+	paths, err = CreateMigration(dir, "backfillEmails", MigrationGo)
+	if err != nil {
+		t.Fatalf("CreateMigration(go) failed: %v", err)
+	}
+	if len(paths) != 1 || !strings.HasSuffix(paths[0], "_backfill_emails.go") {
+		t.Fatalf("CreateMigration(go) wrote %v, want one file ending in _backfill_emails.go", paths)
+	}
+	content, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"package migrations", "goose.AddMigrationContext(upBackfillEmails, downBackfillEmails)"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Go migration does not contain %q:\n%s", want, content)
+		}
+	}
+
+	if _, err := CreateMigration(dir, "  ", MigrationSQL); err == nil {
+		t.Error("CreateMigration() should reject an empty name")
+	}
+	if _, err := CreateMigration(dir, "x", "yaml"); err == nil {
+		t.Error("CreateMigration() should reject unknown kinds")
+	}
+}
@@ -1,36 +1,133 @@
 package main
 
 import (
+	"database/sql"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"lab04-backend/database"
 	"lab04-backend/repository"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
+// databaseConfig reads the database from the environment: DB_DRIVER is
+// "sqlite" (default) or "postgres" and DATABASE_URL is the SQLite file or
+// the Postgres connection string
+func databaseConfig() *database.Config {
+	config := database.DefaultConfig()
+	if driver := os.Getenv("DB_DRIVER"); driver != "" {
+		config.Driver = driver
+	}
+	if url := os.Getenv("DATABASE_URL"); url != "" {
+		if config.Driver == database.DriverSQLite {
+			config.DatabasePath = url
+		} else {
+			config.DSN = url
+		}
+	}
+	return config
+}
+
+// migrate implements "main migrate up|down|status" and
+// "main migrate create [-dir DIR] [-type sql|go] NAME"
+func migrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: migrate up|down|reset|status|create NAME")
+	}
+	if args[0] == "create" {
+		flags := flag.NewFlagSet("migrate create", flag.ExitOnError)
+		dir := flags.String("dir", "migrations", "migrations directory")
+		kind := flags.String("type", database.MigrationSQL, `"sql" or "go"`)
+		flags.Parse(args[1:])
+
+		paths, err := database.CreateMigration(*dir, flags.Arg(0), *kind)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, path := range paths {
+			fmt.Println("Created", path)
+		}
+		if *kind == database.MigrationGo {
+			fmt.Println("Rebuild the program to include the new Go migration")
+		}
+		return
+	}
+
+	db, err := database.InitDBWithConfig(databaseConfig())
+	if err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		err = database.RunMigrations(db)
+	case "down":
+		err = database.RollbackMigration(db)
+	case "reset":
+		err = database.ResetMigrations(db)
+	case "status":
+		err = printMigrationStatus(db)
+	default:
+		err = fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printMigrationStatus(db *sql.DB) error {
+	status, err := database.GetMigrationStatus(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-25s %s\n", "Applied At", "Migration")
+	for _, s := range status {
+		applied := "Pending"
+		if !s.AppliedAt.IsZero() {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-25s %s\n", applied, s.Source.Path)
+	}
+	return nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
 	// TODO: Initialize database connection
-	db, err := database.InitDB()
+	db, err := database.InitDBWithConfig(databaseConfig())
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.Close()
 
-	// TODO: Run migrations (using goose-based approach)
+	// Run the embedded migrations (using goose-based approach)
 	if err := database.RunMigrations(db); err != nil {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	gormDB, err := database.OpenGORM(db)
+	if err != nil {
+		log.Fatal("Failed to open GORM:", err)
+	}
+
 	// TODO: Create repository instances
 	userRepo := repository.NewUserRepository(db)
 	postRepo := repository.NewPostRepository(db)
+	categoryRepo := repository.NewCategoryRepository(gormDB)
+	unitOfWork := repository.NewUnitOfWork(db, gormDB)
 
 	// Demo operations
 	fmt.Println("Database initialized successfully!")
 	fmt.Printf("User repository: %T\n", userRepo)
 	fmt.Printf("Post repository: %T\n", postRepo)
+	fmt.Printf("Category repository: %T\n", categoryRepo)
+	fmt.Printf("Unit of work: %T\n", unitOfWork)
 
 	// TODO: Add some demo data operations here
 	// You can test your CRUD operations
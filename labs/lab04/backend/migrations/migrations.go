@@ -0,0 +1,28 @@
+// Package migrations embeds the database migrations so they are found
+// wherever the binary runs. SQL migrations are written once per database:
+// the SQLite ones in this directory and the Postgres ones, with the same
+// versions, in postgres/. Go migrations in this package register themselves
+// with goose and run on both databases.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed *.sql postgres/*.sql
+var files embed.FS
+
+// SQLite returns the SQL migrations for SQLite
+func SQLite() fs.FS {
+	return files
+}
+
+// Postgres returns the SQL migrations for Postgres
+func Postgres() fs.FS {
+	postgres, err := fs.Sub(files, "postgres")
+	if err != nil {
+		panic(err) // The directory is embedded, so this cannot happen
+	}
+	return postgres
+}
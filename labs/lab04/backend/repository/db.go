@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DBTX is what the SQL repositories need from the database. It is a *sql.DB,
+// or a *sql.Tx when the repositories come from UnitOfWork.WithTx.
+type DBTX interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
@@ -1,16 +1,21 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"lab04-backend/models"
+
+	"github.com/georgysavva/scany/v2/sqlscan"
 )
 
 // PostRepository handles database operations for posts
 // This repository demonstrates SCANY MAPPING approach for result scanning
 type PostRepository struct {
-	db *sql.DB
+	db DBTX
 }
 
 // NewPostRepository creates a new PostRepository
@@ -18,84 +23,115 @@ func NewPostRepository(db *sql.DB) *PostRepository {
 	return &PostRepository{db: db}
 }
 
-// TODO: Implement Create method using scany for result mapping
+// postColumns are the columns of models.Post; content may be NULL
+const postColumns = "id, user_id, title, COALESCE(content, '') AS content, published, created_at, updated_at"
+
+// Create inserts a new post and returns it with its ID and timestamps
 func (r *PostRepository) Create(req *models.CreatePostRequest) (*models.Post, error) {
-	// TODO: Create a new post in the database using scany for result mapping
-	// - Validate the request using req.Validate()
-	// - Insert into posts table with RETURNING clause
-	// - Use sqlscan.Get() to scan the RETURNING result into a Post struct
-	// Example: sqlscan.Get(context.Background(), r.db, &post, query, args...)
-	// This eliminates manual row scanning compared to user repository
-	return nil, fmt.Errorf("TODO: implement Create method with scany mapping")
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	var post models.Post
+	err := sqlscan.Get(context.Background(), r.db, &post,
+		"INSERT INTO posts (user_id, title, content, published, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5) RETURNING "+postColumns,
+		req.UserID, req.Title, req.Content, req.Published, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
 }
 
-// TODO: Implement GetByID method using scany
+// GetByID returns the post with id, or sql.ErrNoRows if there is none
 func (r *PostRepository) GetByID(id int) (*models.Post, error) {
-	// TODO: Get post by ID from database using scany
-	// - Use sqlscan.Get() instead of manual row.Scan()
-	// Example: sqlscan.Get(context.Background(), r.db, &post, "SELECT * FROM posts WHERE id = $1", id)
-	// Notice how this eliminates the need for manual field scanning
-	return nil, fmt.Errorf("TODO: implement GetByID method with scany")
+	var post models.Post
+	if err := sqlscan.Get(context.Background(), r.db, &post, "SELECT "+postColumns+" FROM posts WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &post, nil
 }
 
-// TODO: Implement GetByUserID method using scany
+// GetByUserID returns the posts of a user, newest first
 func (r *PostRepository) GetByUserID(userID int) ([]models.Post, error) {
-	// TODO: Get all posts by user ID using scany
-	// - Use sqlscan.Select() for multiple rows instead of manual rows.Next() loop
-	// Example: sqlscan.Select(context.Background(), r.db, &posts, query, userID)
-	// This eliminates manual iteration and scanning
-	return nil, fmt.Errorf("TODO: implement GetByUserID method with scany")
+	return r.selectPosts("WHERE user_id = $1", userID)
 }
 
-// TODO: Implement GetPublished method using scany
+// GetPublished returns the published posts, newest first
 func (r *PostRepository) GetPublished() ([]models.Post, error) {
-	// TODO: Get all published posts using scany
-	// - Use sqlscan.Select() for multiple rows
-	// - Query posts where published = true
-	// - Order by created_at DESC
-	return nil, fmt.Errorf("TODO: implement GetPublished method with scany")
+	return r.selectPosts("WHERE published = $1", true)
 }
 
-// TODO: Implement GetAll method using scany
+// GetAll returns every post, newest first
 func (r *PostRepository) GetAll() ([]models.Post, error) {
-	// TODO: Get all posts from database using scany
-	// - Use sqlscan.Select() instead of manual rows iteration
-	// Example: sqlscan.Select(context.Background(), r.db, &posts, "SELECT * FROM posts ORDER BY created_at DESC")
-	// Compare this simplicity with manual scanning in user repository
-	return nil, fmt.Errorf("TODO: implement GetAll method with scany")
+	return r.selectPosts("")
 }
 
-// TODO: Implement Update method using scany
+// selectPosts returns the posts matching where, newest first
+func (r *PostRepository) selectPosts(where string, args ...interface{}) ([]models.Post, error) {
+	posts := []models.Post{}
+	query := "SELECT " + postColumns + " FROM posts " + where + " ORDER BY created_at DESC, id DESC"
+	if err := sqlscan.Select(context.Background(), r.db, &posts, query, args...); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// Update changes the non-nil fields of req and returns the updated post, or
+// sql.ErrNoRows if there is no post with id
 func (r *PostRepository) Update(id int, req *models.UpdatePostRequest) (*models.Post, error) {
-	// TODO: Update post in database using scany
-	// - Build dynamic UPDATE query based on non-nil fields in req
-	// - Update updated_at timestamp
-	// - Use sqlscan.Get() with RETURNING clause to get updated post
-	// This avoids a separate SELECT query after UPDATE
-	return nil, fmt.Errorf("TODO: implement Update method with scany")
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if req.Title != nil {
+		set("title", *req.Title)
+	}
+	if req.Content != nil {
+		set("content", *req.Content)
+	}
+	if req.Published != nil {
+		set("published", *req.Published)
+	}
+	set("updated_at", time.Now())
+	args = append(args, id)
+
+	var post models.Post
+	query := fmt.Sprintf("UPDATE posts SET %s WHERE id = $%d RETURNING %s", strings.Join(sets, ", "), len(args), postColumns)
+	if err := sqlscan.Get(context.Background(), r.db, &post, query, args...); err != nil {
+		return nil, err
+	}
+	return &post, nil
 }
 
-// TODO: Implement Delete method (standard SQL)
+// Delete removes the post with id, or returns sql.ErrNoRows if there is none
 func (r *PostRepository) Delete(id int) error {
-	// TODO: Delete post from database
-	// - Delete from posts table by ID
-	// - Return error if post doesn't exist
-	// Note: Delete operations typically don't need scany since no data is returned
-	return fmt.Errorf("TODO: implement Delete method")
+	result, err := r.db.Exec("DELETE FROM posts WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-// TODO: Implement Count method (standard SQL)
+// Count returns the number of posts
 func (r *PostRepository) Count() (int, error) {
-	// TODO: Count total number of posts
-	// - Return count of posts in database
-	// - Can use standard QueryRow.Scan() for single values like count
-	return 0, fmt.Errorf("TODO: implement Count method")
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&count)
+	return count, err
 }
 
-// TODO: Implement CountByUserID method (standard SQL)
+// CountByUserID returns the number of posts of a user
 func (r *PostRepository) CountByUserID(userID int) (int, error) {
-	// TODO: Count posts by user ID
-	// - Return count of posts for specific user
-	// - Use standard QueryRow.Scan() for single integer result
-	return 0, fmt.Errorf("TODO: implement CountByUserID method")
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM posts WHERE user_id = $1", userID).Scan(&count)
+	return count, err
 }
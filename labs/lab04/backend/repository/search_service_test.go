@@ -2,23 +2,12 @@ package repository
 
 import (
 	"testing"
-
-	"lab04-backend/database"
 )
 
 // TestSearchService tests the Squirrel query builder approach
 func TestSearchService(t *testing.T) {
 	// Initialize database for testing
-	db, err := database.InitDB()
-	if err != nil {
-		t.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer database.CloseDB(db)
-
-	// Run migrations
-	if err := database.RunMigrations(db); err != nil {
-		t.Fatalf("Failed to run migrations: %v", err)
-	}
+	db := openTestDB(t)
 
 	// Create service instance
 	searchService := NewSearchService(db)
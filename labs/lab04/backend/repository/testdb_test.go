@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lab04-backend/database"
+)
+
+// openTestDB returns a migrated, empty database closed when t ends. It is a
+// fresh SQLite file unless LAB04_POSTGRES_DSN is set, in which case the
+// tables in this package's own Postgres schema are emptied instead, so other
+// packages' tests can run at the same time.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	config := &database.Config{
+		Driver:       database.DriverSQLite,
+		DatabasePath: filepath.Join(t.TempDir(), "test.db"),
+		MaxOpenConns: 5,
+		MaxIdleConns: 1,
+	}
+	if dsn := os.Getenv("LAB04_POSTGRES_DSN"); dsn != "" {
+		config.Driver = database.DriverPostgres
+		config.DSN = dsn
+		config.Schema = "lab04_repository_test"
+	}
+
+	db, err := database.InitDBWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	t.Cleanup(func() { database.CloseDB(db) })
+
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	if config.Driver == database.DriverPostgres {
+		if _, err := db.Exec("TRUNCATE users, posts, categories, post_categories RESTART IDENTITY CASCADE"); err != nil {
+			t.Fatalf("Failed to reset test database: %v", err)
+		}
+	}
+	return db
+}
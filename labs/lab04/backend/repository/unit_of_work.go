@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// UnitOfWork runs functions in a transaction with repositories bound to it,
+// so changes made through several repositories are kept or undone together
+type UnitOfWork struct {
+	db     *sql.DB
+	gormDB *gorm.DB
+}
+
+// NewUnitOfWork creates a UnitOfWork. gormDB must use db's connection pool,
+// as the handle from database.OpenGORM does.
+func NewUnitOfWork(db *sql.DB, gormDB *gorm.DB) *UnitOfWork {
+	return &UnitOfWork{db: db, gormDB: gormDB}
+}
+
+// Repositories are bound to one transaction and must not be used after the
+// WithTx call that created them returns
+type Repositories struct {
+	Users      *UserRepository
+	Posts      *PostRepository
+	Categories *CategoryRepository
+
+	tx    *sql.Tx
+	depth int // Number of enclosing savepoints
+}
+
+// WithTx runs fn in a new transaction, which is committed if fn returns nil
+// and rolled back if it returns an error or panics. Panics are re-raised
+// after the rollback.
+func (u *UnitOfWork) WithTx(ctx context.Context, fn func(repos *Repositories) error) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	// GORM runs on the same transaction, and its own transactions become
+	// savepoints in it
+	gormTx := u.gormDB.Session(&gorm.Session{NewDB: true, Context: ctx})
+	gormTx.Statement.ConnPool = tx
+
+	repos := &Repositories{
+		Users:      &UserRepository{db: tx},
+		Posts:      &PostRepository{db: tx},
+		Categories: &CategoryRepository{db: gormTx},
+		tx:         tx,
+	}
+	return run(fn, repos, tx.Commit, tx.Rollback)
+}
+
+// WithTx runs fn in a savepoint of the transaction r is bound to. If fn
+// returns an error or panics only its own changes are rolled back; the
+// error is returned, or the panic re-raised, for the caller to handle.
+func (r *Repositories) WithTx(ctx context.Context, fn func(repos *Repositories) error) error {
+	nested := *r
+	nested.depth++
+	savepoint := fmt.Sprintf("sp_%d", nested.depth)
+
+	if _, err := r.tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	release := func() error {
+		_, err := r.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint)
+		return err
+	}
+	rollback := func() error {
+		if _, err := r.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); err != nil {
+			return err
+		}
+		return release()
+	}
+	return run(fn, &nested, release, rollback)
+}
+
+// run calls fn with repos, then commit if it succeeded or rollback if it
+// failed or panicked
+func run(fn func(*Repositories) error, repos *Repositories, commit, rollback func() error) error {
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(repos); err != nil {
+		if rollbackErr := rollback(); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back: %w", rollbackErr))
+		}
+		return err
+	}
+	if err := commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
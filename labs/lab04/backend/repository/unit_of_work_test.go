@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"lab04-backend/database"
+	"lab04-backend/models"
+)
+
+func setupUnitOfWork(t *testing.T) (*UnitOfWork, *sql.DB) {
+	db := openTestDB(t)
+	gormDB, err := database.OpenGORM(db)
+	if err != nil {
+		t.Fatalf("OpenGORM() failed: %v", err)
+	}
+	return NewUnitOfWork(db, gormDB), db
+}
+
+// rowCounts returns the number of rows in each table
+func rowCounts(t *testing.T, db *sql.DB) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for _, table := range []string{"users", "posts", "categories", "post_categories"} {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatalf("Counting %s failed: %v", table, err)
+		}
+		counts[table] = count
+	}
+	return counts
+}
+
+func expectRows(t *testing.T, db *sql.DB, want map[string]int) {
+	t.Helper()
+	got := rowCounts(t, db)
+	for _, table := range []string{"users", "posts", "categories", "post_categories"} {
+		if got[table] != want[table] {
+			t.Errorf("%s has %d rows, want %d", table, got[table], want[table])
+		}
+	}
+}
+
+// createAuthor creates a user with their first post in two categories
+func createAuthor(repos *Repositories, email string) error {
+	user, err := repos.Users.Create(&models.CreateUserRequest{Name: "Author", Email: email})
+	if err != nil {
+		return err
+	}
+	post, err := repos.Posts.Create(&models.CreatePostRequest{UserID: user.ID, Title: "First post", Content: "Hello", Published: true})
+	if err != nil {
+		return err
+	}
+	categories := []models.Category{{Name: "Go " + email}, {Name: "Databases " + email}}
+	if err := repos.Categories.CreateWithTransaction(categories); err != nil {
+		return err
+	}
+	for i := range categories {
+		if err := repos.Categories.AddPosts(&categories[i], post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUnitOfWork_Commit(t *testing.T) {
+	uow, db := setupUnitOfWork(t)
+
+	err := uow.WithTx(context.Background(), func(repos *Repositories) error {
+		return createAuthor(repos, "author@example.com")
+	})
+	if err != nil {
+		t.Fatalf("WithTx() failed: %v", err)
+	}
+
+	expectRows(t, db, map[string]int{"users": 1, "posts": 1, "categories": 2, "post_categories": 2})
+}
+
+func TestUnitOfWork_RollbackOnError(t *testing.T) {
+	uow, db := setupUnitOfWork(t)
+	failure := errors.New("failure after the writes")
+
+	err := uow.WithTx(context.Background(), func(repos *Repositories) error {
+		if err := createAuthor(repos, "author@example.com"); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithTx() error = %v, want %v", err, failure)
+	}
+
+	expectRows(t, db, map[string]int{})
+}
+
+func TestUnitOfWork_RollbackOnDatabaseError(t *testing.T) {
+	uow, db := setupUnitOfWork(t)
+
+	err := uow.WithTx(context.Background(), func(repos *Repositories) error {
+		if err := createAuthor(repos, "author@example.com"); err != nil {
+			return err
+		}
+		// The email is taken, so this insert fails
+		_, err := repos.Users.Create(&models.CreateUserRequest{Name: "Copy", Email: "author@example.com"})
+		return err
+	})
+	if err == nil {
+		t.Fatal("WithTx() should return the duplicate email error")
+	}
+
+	expectRows(t, db, map[string]int{})
+}
+
+func TestUnitOfWork_RollbackOnPanic(t *testing.T) {
+	uow, db := setupUnitOfWork(t)
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("WithTx() panicked with %v, want boom", p)
+			}
+		}()
+		uow.WithTx(context.Background(), func(repos *Repositories) error {
+			if err := createAuthor(repos, "author@example.com"); err != nil {
+				t.Fatalf("createAuthor() failed: %v", err)
+			}
+			panic("boom")
+		})
+	}()
+
+	expectRows(t, db, map[string]int{})
+
+	// The connection went back to the pool in a usable state
+	if _, err := NewUserRepository(db).Create(&models.CreateUserRequest{Name: "After", Email: "after@example.com"}); err != nil {
+		t.Errorf("Create() after the panic failed: %v", err)
+	}
+}
+
+func TestUnitOfWork_Savepoints(t *testing.T) {
+	uow, db := setupUnitOfWork(t)
+	ctx := context.Background()
+
+	err := uow.WithTx(ctx, func(repos *Repositories) error {
+		if err := createAuthor(repos, "first@example.com"); err != nil {
+			return err
+		}
+
+		// A failing savepoint undoes only its own writes
+		err := repos.WithTx(ctx, func(repos *Repositories) error {
+			if err := createAuthor(repos, "second@example.com"); err != nil {
+				return err
+			}
+			_, err := repos.Users.Create(&models.CreateUserRequest{Name: "Copy", Email: "first@example.com"})
+			return err
+		})
+		if err == nil {
+			t.Error("Nested WithTx() should return the duplicate email error")
+		}
+
+		// Savepoints nest, and successful ones are kept
+		return repos.WithTx(ctx, func(repos *Repositories) error {
+			if err := createAuthor(repos, "third@example.com"); err != nil {
+				return err
+			}
+			failure := errors.New("inner failure")
+			err := repos.WithTx(ctx, func(repos *Repositories) error {
+				if _, err := repos.Users.Create(&models.CreateUserRequest{Name: "Inner", Email: "inner@example.com"}); err != nil {
+					return err
+				}
+				return failure
+			})
+			if !errors.Is(err, failure) {
+				t.Errorf("Innermost WithTx() error = %v, want %v", err, failure)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTx() failed: %v", err)
+	}
+
+	expectRows(t, db, map[string]int{"users": 2, "posts": 2, "categories": 4, "post_categories": 4})
+	for _, email := range []string{"second@example.com", "inner@example.com"} {
+		if _, err := NewUserRepository(db).GetByEmail(email); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetByEmail(%s) error = %v, want sql.ErrNoRows", email, err)
+		}
+	}
+}
+
+func TestUnitOfWork_OuterRollbackUndoesSavepoints(t *testing.T) {
+	uow, db := setupUnitOfWork(t)
+	ctx := context.Background()
+	failure := errors.New("outer failure")
+
+	err := uow.WithTx(ctx, func(repos *Repositories) error {
+		err := repos.WithTx(ctx, func(repos *Repositories) error {
+			return createAuthor(repos, "author@example.com")
+		})
+		if err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithTx() error = %v, want %v", err, failure)
+	}
+
+	expectRows(t, db, map[string]int{})
+}
@@ -3,6 +3,8 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"lab04-backend/models"
 )
@@ -10,7 +12,7 @@ import (
 // UserRepository handles database operations for users
 // This repository demonstrates MANUAL SQL approach with database/sql package
 type UserRepository struct {
-	db *sql.DB
+	db DBTX
 }
 
 // NewUserRepository creates a new UserRepository
@@ -18,65 +20,103 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-// TODO: Implement Create method
+// userColumns are the columns scanUser reads, in order
+const userColumns = "id, name, email, created_at, updated_at"
+
+// Create inserts a new user and returns it with its ID and timestamps
 func (r *UserRepository) Create(req *models.CreateUserRequest) (*models.User, error) {
-	// TODO: Create a new user in the database
-	// - Validate the request
-	// - Insert into users table
-	// - Return the created user with ID and timestamps
-	// Use RETURNING clause to get the generated ID and timestamps
-	return nil, fmt.Errorf("TODO: implement Create method")
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	row := r.db.QueryRow(
+		"INSERT INTO users (name, email, created_at, updated_at) VALUES ($1, $2, $3, $3) RETURNING "+userColumns,
+		req.Name, req.Email, now,
+	)
+	return scanUser(row)
 }
 
-// TODO: Implement GetByID method
+// GetByID returns the user with id, or sql.ErrNoRows if there is none
 func (r *UserRepository) GetByID(id int) (*models.User, error) {
-	// TODO: Get user by ID from database
-	// - Query users table by ID
-	// - Return user or sql.ErrNoRows if not found
-	// - Handle scanning properly
-	return nil, fmt.Errorf("TODO: implement GetByID method")
+	return scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", id))
 }
 
-// TODO: Implement GetByEmail method
+// GetByEmail returns the user with email, or sql.ErrNoRows if there is none
 func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
-	// TODO: Get user by email from database
-	// - Query users table by email
-	// - Return user or sql.ErrNoRows if not found
-	// - Handle scanning properly
-	return nil, fmt.Errorf("TODO: implement GetByEmail method")
+	return scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = $1", email))
 }
 
-// TODO: Implement GetAll method
+// GetAll returns every user, oldest first
 func (r *UserRepository) GetAll() ([]models.User, error) {
-	// TODO: Get all users from database
-	// - Query all users ordered by created_at
-	// - Return slice of users
-	// - Handle empty result properly
-	return nil, fmt.Errorf("TODO: implement GetAll method")
+	rows, err := r.db.Query("SELECT " + userColumns + " FROM users ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
 }
 
-// TODO: Implement Update method
+// Update changes the non-nil fields of req and returns the updated user, or
+// sql.ErrNoRows if there is no user with id
 func (r *UserRepository) Update(id int, req *models.UpdateUserRequest) (*models.User, error) {
-	// TODO: Update user in database
-	// - Build dynamic UPDATE query based on non-nil fields in req
-	// - Update updated_at timestamp
-	// - Return updated user
-	// - Handle case where user doesn't exist
-	return nil, fmt.Errorf("TODO: implement Update method")
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if req.Name != nil {
+		set("name", *req.Name)
+	}
+	if req.Email != nil {
+		set("email", *req.Email)
+	}
+	set("updated_at", time.Now())
+	args = append(args, id)
+
+	query := fmt.Sprintf("UPDATE users SET %s WHERE id = $%d RETURNING %s", strings.Join(sets, ", "), len(args), userColumns)
+	return scanUser(r.db.QueryRow(query, args...))
 }
 
-// TODO: Implement Delete method
+// Delete removes the user with id and, through the foreign key, their posts.
+// It returns sql.ErrNoRows if there is no such user.
 func (r *UserRepository) Delete(id int) error {
-	// TODO: Delete user from database
-	// - Delete from users table by ID
-	// - Return error if user doesn't exist
-	// - Consider cascading deletes for posts
-	return fmt.Errorf("TODO: implement Delete method")
+	result, err := r.db.Exec("DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-// TODO: Implement Count method
+// Count returns the number of users
 func (r *UserRepository) Count() (int, error) {
-	// TODO: Count total number of users
-	// - Return count of users in database
-	return 0, fmt.Errorf("TODO: implement Count method")
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+// scanUser reads userColumns from row
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	if err := row.Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
@@ -1,41 +1,16 @@
 package repository
 
 import (
-	"os"
 	"testing"
 
-	"lab04-backend/database"
 	"lab04-backend/models"
 )
 
 func setupTestDB(t *testing.T) (*UserRepository, func()) {
-	// Create test database
-	testDB := "./test_user_repo.db"
-	config := &database.Config{
-		DatabasePath:    testDB,
-		MaxOpenConns:    5,
-		MaxIdleConns:    1,
-		ConnMaxLifetime: 0,
-		ConnMaxIdleTime: 0,
-	}
+	repo := NewUserRepository(openTestDB(t))
 
-	db, err := database.InitDBWithConfig(config)
-	if err != nil {
-		t.Fatalf("Failed to initialize test database: %v", err)
-	}
-
-	err = database.RunMigrations(db)
-	if err != nil {
-		t.Fatalf("Failed to migrate test database: %v", err)
-	}
-
-	repo := NewUserRepository(db)
-
-	// Return cleanup function
-	cleanup := func() {
-		database.CloseDB(db)
-		os.Remove(testDB)
-	}
+	// The database is closed when the test ends
+	cleanup := func() {}
 
 	return repo, cleanup
 }
@@ -0,0 +1,474 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrForbidden is returned when a user may not access an attachment
+	ErrForbidden = errors.New("access to attachment denied")
+	// ErrTooLarge is returned when an upload exceeds Config.MaxSize
+	ErrTooLarge = errors.New("attachment too large")
+	// ErrUnsupportedType is returned when an upload's content type is not allowed
+	ErrUnsupportedType = errors.New("attachment type not allowed")
+	// ErrUnauthorized is returned when a request needs a user and has none,
+	// or its credentials are invalid
+	ErrUnauthorized = errors.New("authentication required")
+)
+
+// Attachment describes an uploaded file
+type Attachment struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	MIMEType     string    `json:"mime_type"`
+	Size         int64     `json:"size"`
+	Owner        string    `json:"owner"`
+	HasThumbnail bool      `json:"has_thumbnail"`
+	Shared       bool      `json:"shared"` // With the whole chat
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// record is the metadata sidecar stored next to each attachment, so
+// attachments survive a restart
+type record struct {
+	Attachment
+	Recipients []string `json:"recipients,omitempty"`
+}
+
+// Authenticator returns the user making a request, or "" when the request
+// carries no credentials. An error means the credentials are invalid.
+type Authenticator func(r *http.Request) (string, error)
+
+// Config holds upload limits and how requests are authenticated
+type Config struct {
+	// MaxSize is the largest accepted file in bytes
+	MaxSize int64
+	// AllowedTypes lists accepted MIME types, detected from file contents
+	AllowedTypes []string
+	// ThumbnailSize is the longest side of generated image thumbnails
+	ThumbnailSize int
+	// MaxImagePixels is the largest image, in pixels, a thumbnail is made of
+	MaxImagePixels int
+	// Authenticate identifies the user making a request. Without it every
+	// request is anonymous, so nothing can be uploaded.
+	Authenticate Authenticator
+}
+
+// DefaultConfig returns the limits used by main
+func DefaultConfig() Config {
+	return Config{
+		MaxSize:        10 << 20,
+		AllowedTypes:   []string{"image/png", "image/jpeg", "image/gif", "application/pdf", "text/plain"},
+		ThumbnailSize:  128,
+		MaxImagePixels: 25_000_000,
+	}
+}
+
+// Service manages attachment uploads and downloads.
+// A user may download an attachment they uploaded, or one that its owner
+// has shared with them or with the whole chat. Metadata is kept in storage
+// beside each file and loaded on first use after a restart.
+type Service struct {
+	storage     Storage
+	config      Config
+	allowed     map[string]bool
+	attachments map[string]*Attachment
+	recipients  map[string]map[string]bool // Users each attachment is shared with
+	mutex       sync.RWMutex
+	router      *mux.Router
+}
+
+// NewService creates an attachment service storing files in storage
+func NewService(storage Storage, config Config) *Service {
+	allowed := make(map[string]bool, len(config.AllowedTypes))
+	for _, mimeType := range config.AllowedTypes {
+		allowed[mimeType] = true
+	}
+
+	s := &Service{
+		storage:     storage,
+		config:      config,
+		allowed:     allowed,
+		attachments: make(map[string]*Attachment),
+		recipients:  make(map[string]map[string]bool),
+		router:      mux.NewRouter(),
+	}
+
+	s.setupRoutes()
+	return s
+}
+
+// setupRoutes configures HTTP routes
+func (s *Service) setupRoutes() {
+	s.router.HandleFunc("/attachments", s.handleUpload).Methods("POST")
+	s.router.HandleFunc("/attachments/{id}", s.handleDownload).Methods("GET")
+	s.router.HandleFunc("/attachments/{id}/thumbnail", s.handleThumbnail).Methods("GET")
+}
+
+// GetRouter returns the HTTP router
+func (s *Service) GetRouter() *mux.Router {
+	return s.router
+}
+
+// newID returns a random attachment identifier
+func newID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// validID matches the identifiers newID generates
+var validID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// thumbnailKey is the storage key of an attachment's thumbnail
+func thumbnailKey(id string) string {
+	return id + ".thumb.png"
+}
+
+// metadataKey is the storage key of an attachment's metadata sidecar
+func metadataKey(id string) string {
+	return id + ".json"
+}
+
+// Upload stores the contents of r as a new attachment owned by owner.
+// The content type is detected from the data, not taken from the client.
+func (s *Service) Upload(ctx context.Context, owner, name string, r io.Reader) (Attachment, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Attachment{}, err
+	}
+	head = head[:n]
+
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil || !s.allowed[mimeType] {
+		return Attachment{}, ErrUnsupportedType
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	// Read one byte past the limit so oversized uploads can be detected
+	body := io.LimitReader(io.MultiReader(bytes.NewReader(head), r), s.config.MaxSize+1)
+	size, err := s.storage.Save(ctx, id, body)
+	if err == nil && size > s.config.MaxSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		s.storage.Delete(ctx, id)
+		return Attachment{}, err
+	}
+
+	attachment := &Attachment{
+		ID:        id,
+		Name:      name,
+		MIMEType:  mimeType,
+		Size:      size,
+		Owner:     owner,
+		CreatedAt: time.Now(),
+	}
+
+	if thumbnailTypes[mimeType] {
+		if err := s.saveThumbnail(ctx, id); err != nil {
+			log.Printf("⚠️ Failed to create thumbnail for %s: %v", id, err)
+		} else {
+			attachment.HasThumbnail = true
+		}
+	}
+
+	if err := s.saveMetadata(ctx, *attachment, nil); err != nil {
+		s.storage.Delete(ctx, id)
+		s.storage.Delete(ctx, thumbnailKey(id))
+		return Attachment{}, err
+	}
+
+	s.mutex.Lock()
+	s.attachments[id] = attachment
+	s.mutex.Unlock()
+
+	log.Printf("📎 Attachment %s uploaded by %s (%s, %d bytes)", id, owner, mimeType, size)
+	return *attachment, nil
+}
+
+// saveThumbnail generates and stores the thumbnail of a stored image
+func (s *Service) saveThumbnail(ctx context.Context, id string) error {
+	src, err := s.storage.Open(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var buf bytes.Buffer
+	if err := makeThumbnail(&buf, src, s.config.ThumbnailSize, s.config.MaxImagePixels); err != nil {
+		return err
+	}
+
+	_, err = s.storage.Save(ctx, thumbnailKey(id), &buf)
+	return err
+}
+
+// saveMetadata writes the metadata sidecar of an attachment
+func (s *Service) saveMetadata(ctx context.Context, attachment Attachment, recipients map[string]bool) error {
+	rec := record{Attachment: attachment}
+	for recipient := range recipients {
+		rec.Recipients = append(rec.Recipients, recipient)
+	}
+	slices.Sort(rec.Recipients)
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.storage.Save(ctx, metadataKey(attachment.ID), bytes.NewReader(data))
+	return err
+}
+
+// load reads an attachment's metadata sidecar into memory unless it is
+// already there
+func (s *Service) load(ctx context.Context, id string) error {
+	s.mutex.RLock()
+	_, ok := s.attachments[id]
+	s.mutex.RUnlock()
+	if ok {
+		return nil
+	}
+	if !validID.MatchString(id) {
+		return ErrNotFound
+	}
+
+	content, err := s.storage.Open(ctx, metadataKey(id))
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	var rec record
+	if err := json.NewDecoder(content).Decode(&rec); err != nil {
+		return fmt.Errorf("attachment %s metadata: %w", id, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.attachments[id]; ok {
+		return nil // Loaded concurrently
+	}
+	attachment := rec.Attachment
+	s.attachments[id] = &attachment
+	if len(rec.Recipients) > 0 {
+		s.recipients[id] = make(map[string]bool, len(rec.Recipients))
+		for _, recipient := range rec.Recipients {
+			s.recipients[id][recipient] = true
+		}
+	}
+	return nil
+}
+
+// Get returns the attachment if userID may access it
+func (s *Service) Get(userID, id string) (Attachment, error) {
+	if err := s.load(context.Background(), id); err != nil {
+		return Attachment{}, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	attachment, ok := s.attachments[id]
+	if !ok {
+		return Attachment{}, ErrNotFound
+	}
+	if attachment.Owner != userID && !attachment.Shared && !s.recipients[id][userID] {
+		return Attachment{}, ErrForbidden
+	}
+	return *attachment, nil
+}
+
+// Share makes the attachment downloadable by recipients, or by everyone in
+// the chat when there are none. Only the owner may share an attachment.
+func (s *Service) Share(userID, id string, recipients ...string) (Attachment, error) {
+	if err := s.load(context.Background(), id); err != nil {
+		return Attachment{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	attachment, ok := s.attachments[id]
+	if !ok {
+		return Attachment{}, ErrNotFound
+	}
+	if attachment.Owner != userID {
+		return Attachment{}, ErrForbidden
+	}
+
+	// The sidecar is written first so memory never holds a share that
+	// would be lost on restart
+	updated := *attachment
+	shared := maps.Clone(s.recipients[id])
+	if len(recipients) == 0 {
+		updated.Shared = true
+	} else if shared == nil {
+		shared = make(map[string]bool, len(recipients))
+	}
+	for _, recipient := range recipients {
+		shared[recipient] = true
+	}
+	if err := s.saveMetadata(context.Background(), updated, shared); err != nil {
+		return Attachment{}, err
+	}
+	*attachment = updated
+	if shared != nil {
+		s.recipients[id] = shared
+	}
+	return updated, nil
+}
+
+// requestUser returns the authenticated user making the request, or "" for
+// anonymous requests
+func (s *Service) requestUser(r *http.Request) (string, error) {
+	if s.config.Authenticate == nil {
+		return "", nil
+	}
+	userID, err := s.config.Authenticate(r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	return userID, nil
+}
+
+// writeError maps service errors to HTTP status codes
+func writeError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrUnauthorized):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrTooLarge), errors.As(err, &maxBytesErr):
+		http.Error(w, ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrUnsupportedType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	default:
+		log.Printf("❌ Attachment request failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// handleUpload handles multipart uploads with the file in the "file" field
+func (s *Service) handleUpload(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.requestUser(r)
+	if err == nil && userID == "" {
+		err = ErrUnauthorized
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Leave room for multipart headers around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxSize+64<<10)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, "Missing file field", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		attachment, err := s.Upload(r.Context(), userID, part.FileName(), part)
+		part.Close()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(attachment)
+		return
+	}
+}
+
+// handleDownload streams an attachment to an authorized user
+func (s *Service) handleDownload(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, false)
+}
+
+// handleThumbnail streams an image attachment's thumbnail to an authorized user
+func (s *Service) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, true)
+}
+
+// serve writes the attachment or its thumbnail to the response
+func (s *Service) serve(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	userID, err := s.requestUser(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	attachment, err := s.Get(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	key, contentType := attachment.ID, attachment.MIMEType
+	if thumbnail {
+		if !attachment.HasThumbnail {
+			http.Error(w, "Attachment has no thumbnail", http.StatusNotFound)
+			return
+		}
+		key, contentType = thumbnailKey(attachment.ID), "image/png"
+	}
+
+	content, err := s.storage.Open(r.Context(), key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !thumbnail {
+		w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	}
+	io.Copy(w, content)
+}
@@ -0,0 +1,369 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testAuthenticate accepts "Bearer valid-<user>" as a token for user
+func testAuthenticate(r *http.Request) (string, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", nil
+	}
+	user, ok := strings.CutPrefix(token, "valid-")
+	if !ok {
+		return "", errors.New("invalid token")
+	}
+	return user, nil
+}
+
+func newTestService(t *testing.T, config Config) *Service {
+	t.Helper()
+
+	if config.Authenticate == nil {
+		config.Authenticate = testAuthenticate
+	}
+
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return NewService(storage, config)
+}
+
+// testPNG returns a PNG image of the given size
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// uploadRequest builds a multipart upload request for user
+func uploadRequest(t *testing.T, user, filename string, content []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/attachments", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if user != "" {
+		req.Header.Set("Authorization", "Bearer valid-"+user)
+	}
+	return req
+}
+
+// upload performs an upload through the router and decodes the response
+func upload(t *testing.T, service *Service, user, filename string, content []byte) Attachment {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	service.GetRouter().ServeHTTP(rr, uploadRequest(t, user, filename, content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var attachment Attachment
+	if err := json.NewDecoder(rr.Body).Decode(&attachment); err != nil {
+		t.Fatalf("Failed to decode upload response: %v", err)
+	}
+	return attachment
+}
+
+func get(service *Service, path, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if user != "" {
+		req.Header.Set("Authorization", "Bearer valid-"+user)
+	}
+	rr := httptest.NewRecorder()
+	service.GetRouter().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUpload_DownloadRoundTrip(t *testing.T) {
+	service := newTestService(t, DefaultConfig())
+	content := []byte("hello attachments")
+
+	attachment := upload(t, service, "alice", "notes.txt", content)
+	if attachment.ID == "" {
+		t.Fatal("Expected attachment ID")
+	}
+	if attachment.MIMEType != "text/plain" {
+		t.Errorf("Expected MIME type text/plain, got %s", attachment.MIMEType)
+	}
+	if attachment.Size != int64(len(content)) {
+		t.Errorf("Expected size %d, got %d", len(content), attachment.Size)
+	}
+	if attachment.HasThumbnail {
+		t.Error("Text attachment should not have a thumbnail")
+	}
+
+	rr := get(service, "/attachments/"+attachment.ID, "alice")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), content) {
+		t.Errorf("Downloaded content mismatch: %q", rr.Body.String())
+	}
+	if disposition := rr.Header().Get("Content-Disposition"); !strings.Contains(disposition, "notes.txt") {
+		t.Errorf("Expected filename in Content-Disposition, got '%s'", disposition)
+	}
+}
+
+func TestUpload_RequiresUser(t *testing.T) {
+	service := newTestService(t, DefaultConfig())
+
+	rr := httptest.NewRecorder()
+	service.GetRouter().ServeHTTP(rr, uploadRequest(t, "", "notes.txt", []byte("hi")))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", rr.Code)
+	}
+}
+
+func TestRequests_Authentication(t *testing.T) {
+	service := newTestService(t, DefaultConfig())
+	attachment := upload(t, service, "alice", "notes.txt", []byte("hi"))
+
+	// Claiming a user without credentials does not work
+	req := httptest.NewRequest("GET", "/attachments/"+attachment.ID+"?user_id=alice", nil)
+	req.Header.Set("X-User-ID", "alice")
+	rr := httptest.NewRecorder()
+	service.GetRouter().ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for an anonymous download, got %d", rr.Code)
+	}
+
+	req = uploadRequest(t, "", "notes.txt", []byte("hi"))
+	req.Header.Set("X-User-ID", "alice")
+	rr = httptest.NewRecorder()
+	service.GetRouter().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an anonymous upload, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/attachments/"+attachment.ID, nil)
+	req.Header.Set("Authorization", "Bearer forged")
+	rr = httptest.NewRecorder()
+	service.GetRouter().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an invalid token, got %d", rr.Code)
+	}
+
+	// Without an authenticator nobody can upload
+	config := DefaultConfig()
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	NewService(storage, config).GetRouter().ServeHTTP(rr, uploadRequest(t, "alice", "notes.txt", []byte("hi")))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without an authenticator, got %d", rr.Code)
+	}
+}
+
+func TestUpload_Limits(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxSize = 1024
+	service := newTestService(t, config)
+
+	tests := []struct {
+		name     string
+		content  []byte
+		expected int
+	}{
+		{"too large", bytes.Repeat([]byte("a"), 2048), http.StatusRequestEntityTooLarge},
+		{"exactly at limit", bytes.Repeat([]byte("a"), 1024), http.StatusCreated},
+		{"disallowed type", []byte("<html><body>hi</body></html>"), http.StatusUnsupportedMediaType},
+		{"executable", append([]byte("MZ\x90\x00"), make([]byte, 100)...), http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			service.GetRouter().ServeHTTP(rr, uploadRequest(t, "alice", "file", tt.content))
+			if rr.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestDownload_Authorization(t *testing.T) {
+	service := newTestService(t, DefaultConfig())
+	attachment := upload(t, service, "alice", "secret.txt", []byte("top secret"))
+
+	if rr := get(service, "/attachments/"+attachment.ID, "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 before sharing, got %d", rr.Code)
+	}
+	if rr := get(service, "/attachments/unknown", "alice"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown attachment, got %d", rr.Code)
+	}
+
+	if _, err := service.Share("bob", attachment.ID); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden when sharing someone else's attachment, got %v", err)
+	}
+	if _, err := service.Share("alice", attachment.ID); err != nil {
+		t.Fatalf("Share failed: %v", err)
+	}
+
+	if rr := get(service, "/attachments/"+attachment.ID, "bob"); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 after sharing, got %d", rr.Code)
+	}
+}
+
+func TestShare_Recipients(t *testing.T) {
+	service := newTestService(t, DefaultConfig())
+	attachment := upload(t, service, "alice", "secret.txt", []byte("for bob"))
+
+	shared, err := service.Share("alice", attachment.ID, "bob")
+	if err != nil {
+		t.Fatalf("Share failed: %v", err)
+	}
+	if shared.Shared {
+		t.Error("Sharing with a recipient should not share with the whole chat")
+	}
+
+	if rr := get(service, "/attachments/"+attachment.ID, "bob"); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for the recipient, got %d", rr.Code)
+	}
+	for _, user := range []string{"carol", ""} {
+		if rr := get(service, "/attachments/"+attachment.ID, user); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for %q, got %d", user, rr.Code)
+		}
+	}
+
+	// Recipients cannot pass the attachment on
+	if _, err := service.Share("bob", attachment.ID, "carol"); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden when a recipient shares, got %v", err)
+	}
+	if _, err := service.Share("bob", attachment.ID); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden when a recipient broadcasts, got %v", err)
+	}
+	if rr := get(service, "/attachments/"+attachment.ID, "carol"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for carol after refused shares, got %d", rr.Code)
+	}
+}
+
+func TestService_ReloadsMetadata(t *testing.T) {
+	config := DefaultConfig()
+	config.Authenticate = testAuthenticate
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	before := NewService(storage, config)
+	attachment := upload(t, before, "alice", "notes.txt", []byte("for bob"))
+	if _, err := before.Share("alice", attachment.ID, "bob"); err != nil {
+		t.Fatalf("Share failed: %v", err)
+	}
+
+	// A restarted service finds the file, its owner and its recipients
+	after := NewService(storage, config)
+	for user, want := range map[string]int{"alice": http.StatusOK, "bob": http.StatusOK, "carol": http.StatusForbidden} {
+		if rr := get(after, "/attachments/"+attachment.ID, user); rr.Code != want {
+			t.Errorf("Expected %d for %s after a restart, got %d", want, user, rr.Code)
+		}
+	}
+	if rr := get(after, "/attachments/"+attachment.ID, "alice"); rr.Body.String() != "for bob" {
+		t.Errorf("Unexpected content %q", rr.Body)
+	}
+	if rr := get(after, "/attachments/0123456789abcdef0123456789abcdef", "alice"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown attachment, got %d", rr.Code)
+	}
+}
+
+func TestUpload_Thumbnail(t *testing.T) {
+	service := newTestService(t, DefaultConfig())
+	attachment := upload(t, service, "alice", "photo.png", testPNG(t, 400, 200))
+
+	if attachment.MIMEType != "image/png" {
+		t.Fatalf("Expected image/png, got %s", attachment.MIMEType)
+	}
+	if !attachment.HasThumbnail {
+		t.Fatal("Expected image attachment to have a thumbnail")
+	}
+
+	rr := get(service, "/attachments/"+attachment.ID+"/thumbnail", "alice")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	thumb, err := png.Decode(rr.Body)
+	if err != nil {
+		t.Fatalf("Failed to decode thumbnail: %v", err)
+	}
+	if bounds := thumb.Bounds(); bounds.Dx() != 128 || bounds.Dy() != 64 {
+		t.Errorf("Expected 128x64 thumbnail, got %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	if rr := get(service, "/attachments/"+attachment.ID+"/thumbnail", "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for thumbnail of unshared image, got %d", rr.Code)
+	}
+}
+
+func TestUpload_ThumbnailPixelLimit(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxImagePixels = 100 * 100
+	service := newTestService(t, config)
+
+	// Too large images are still stored, just without a thumbnail
+	attachment := upload(t, service, "alice", "photo.png", testPNG(t, 400, 200))
+	if attachment.HasThumbnail {
+		t.Error("Expected no thumbnail for an image over the pixel limit")
+	}
+
+	var buf bytes.Buffer
+	if err := makeThumbnail(&buf, bytes.NewReader(testPNG(t, 101, 100)), 64, 100*100); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Expected ErrImageTooLarge, got %v", err)
+	}
+	if err := makeThumbnail(&buf, bytes.NewReader(testPNG(t, 100, 100)), 64, 100*100); err != nil {
+		t.Errorf("Image at the pixel limit failed: %v", err)
+	}
+}
+
+func TestLocalStorage_RejectsInvalidKeys(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	for _, key := range []string{"../escape", "a/b", "", ".."} {
+		if _, err := storage.Save(context.Background(), key, strings.NewReader("x")); err == nil {
+			t.Errorf("Expected error for key %q", key)
+		}
+	}
+
+	if _, err := storage.Open(context.Background(), "missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
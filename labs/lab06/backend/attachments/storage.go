@@ -0,0 +1,103 @@
+package attachments
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrNotFound is returned when an attachment or stored object does not exist
+var ErrNotFound = errors.New("attachment not found")
+
+// Storage stores attachment contents by key. Implementations must be safe
+// for concurrent use; LocalStorage is the only one for now, an
+// S3-compatible backend can implement the same interface later.
+type Storage interface {
+	// Save writes r under key and returns the number of bytes written
+	Save(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Open returns a reader for the object stored under key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key; missing keys are not an error
+	Delete(ctx context.Context, key string) error
+}
+
+var validKey = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// LocalStorage stores attachments as files in a directory
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocalStorage creates a storage rooted at dir, creating it if needed
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{dir: dir}, nil
+}
+
+// path maps a key to a file inside the storage directory
+func (s *LocalStorage) path(key string) (string, error) {
+	if !validKey.MatchString(key) || key == "." || key == ".." {
+		return "", errors.New("invalid storage key")
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Save writes r to a temporary file and renames it into place, so readers
+// never observe a partially written object
+func (s *LocalStorage) Save(ctx context.Context, key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return n, err
+	}
+
+	return n, os.Rename(tmp.Name(), path)
+}
+
+// Open opens the file stored under key
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete removes the file stored under key
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
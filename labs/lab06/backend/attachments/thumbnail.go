@@ -0,0 +1,62 @@
+package attachments
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"  // register GIF decoder
+	_ "image/jpeg" // register JPEG decoder
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// ErrImageTooLarge is returned for images with more pixels than allowed,
+// which would take too much memory to decode
+var ErrImageTooLarge = errors.New("image has too many pixels")
+
+// thumbnailTypes are the MIME types a thumbnail is generated for
+var thumbnailTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// makeThumbnail decodes an image and writes a PNG scaled to fit within
+// maxSide x maxSide, preserving aspect ratio. Images already small enough
+// are re-encoded at their original size. Images with more than maxPixels
+// pixels are rejected before they are decoded.
+func makeThumbnail(w io.Writer, r io.Reader, maxSide, maxPixels int) error {
+	// The header gives the size; keep what it read for the full decode
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxPixels/config.Height {
+		return ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			height = max(1, height*maxSide/width)
+			width = maxSide
+		} else {
+			width = max(1, width*maxSide/height)
+			height = maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	return png.Encode(w, dst)
+}
@@ -1,22 +1,42 @@
 module lab06-backend
 
-go 1.23.0
-
-toolchain go1.23.1
+go 1.24.3
 
 // Protocol buffer generation:
 // protoc --go_out=. --go-grpc_out=. proto/calculator.proto
+// protoc --go_out=. proto/chat.proto
 
 require (
+	github.com/alicebob/miniredis/v2 v2.33.0
 	github.com/gorilla/mux v1.8.1
 	github.com/gorilla/websocket v1.5.3
+	github.com/redis/go-redis/v9 v9.7.3
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	golang.org/x/image v0.25.0
 	google.golang.org/grpc v1.73.0
 	google.golang.org/protobuf v1.36.6
+	lab02 v0.0.0
+	lab03-backend v0.0.0
 )
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/golang-jwt/jwt/v4 v4.5.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	golang.org/x/net v0.38.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250324211829-b45e905df463 // indirect
 )
+
+// The chat broker from lab02 is used as the WebSocket routing core
+replace lab02 => ../../lab02/backend
+
+// Chat users authenticate with the bearer tokens issued for lab03
+replace lab03-backend => ../../lab03/backend
+
+// Needed by lab03-backend, whose own replace directives do not apply here
+replace github.com/timur-harin/sum25-go-flutter-course/backend => ../../../backend
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
 
+	"lab02/chatcore"
+	"lab03-backend/auth"
+	"lab06-backend/attachments"
 	"lab06-backend/calculator"
 	"lab06-backend/gateway"
 	pb "lab06-backend/proto"
@@ -84,18 +90,33 @@ func startGatewayService() {
 
 // startWebSocketService starts the WebSocket service
 func startWebSocketService() {
-	wsServiceInstance := wsService.NewService()
+	attachmentsDir := os.Getenv("ATTACHMENTS_DIR")
+	if attachmentsDir == "" {
+		attachmentsDir = "data/attachments"
+	}
+	storage, err := attachments.NewLocalStorage(attachmentsDir)
+	if err != nil {
+		log.Fatalf("Failed to create attachment storage: %v", err)
+	}
+	authenticate := authenticator()
+	attachmentConfig := attachments.DefaultConfig()
+	attachmentConfig.Authenticate = authenticate
+	attachmentService := attachments.NewService(storage, attachmentConfig)
+
+	wsServiceInstance := newWebSocketService(attachmentService, authenticate)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", wsServiceInstance.GetHandler())
 	mux.HandleFunc("/stats", wsServiceInstance.GetStatsHandler())
+	mux.Handle("/attachments", attachmentService.GetRouter())
+	mux.Handle("/attachments/", attachmentService.GetRouter())
 
 	// Add CORS middleware
 	corsHandler := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -116,3 +137,111 @@ func startWebSocketService() {
 		log.Fatalf("Failed to serve WebSocket service: %v", err)
 	}
 }
+
+// authenticator identifies users by lab03 bearer tokens signed with
+// AUTH_SECRET, so the chat knows users by the same tokens as the lab03 API.
+// Browsers cannot set headers on WebSocket handshakes or image requests, so
+// the token may also be given in the token query parameter.
+func authenticator() func(r *http.Request) (string, error) {
+	secret := os.Getenv("AUTH_SECRET")
+	if secret == "" {
+		log.Println("AUTH_SECRET is not set; every WebSocket user is anonymous and attachments cannot be uploaded")
+		return func(*http.Request) (string, error) { return "", nil }
+	}
+	tokens, err := auth.NewTokenService(secret)
+	if err != nil {
+		log.Fatalf("Failed to create token service: %v", err)
+	}
+
+	return func(r *http.Request) (string, error) {
+		token := auth.BearerToken(r.Header.Get("Authorization"))
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if token == "" {
+			return "", nil
+		}
+		identity, err := tokens.Verify(token)
+		if err != nil {
+			return "", err
+		}
+		return identity.Username, nil
+	}
+}
+
+// newWebSocketService creates the WebSocket service, sharing messages with
+// other instances through Redis when REDIS_ADDR is set
+func newWebSocketService(attachmentService *attachments.Service, authenticate func(*http.Request) (string, error)) *wsService.Service {
+	config := wsService.DefaultConfig()
+	config.Authenticate = authenticate
+	config.ResolveAttachment = func(userID, recipient, attachmentID string) (wsService.Attachment, error) {
+		// Only the owner may attach a file; access is granted by
+		// ShareAttachment once the message is accepted
+		attachment, err := attachmentService.Get(userID, attachmentID)
+		if err == nil && attachment.Owner != userID {
+			err = attachments.ErrForbidden
+		}
+		if err != nil {
+			return wsService.Attachment{}, err
+		}
+		return wsService.Attachment{
+			ID:           attachment.ID,
+			Name:         attachment.Name,
+			MIMEType:     attachment.MIMEType,
+			Size:         attachment.Size,
+			HasThumbnail: attachment.HasThumbnail,
+		}, nil
+	}
+	config.ShareAttachment = func(userID, recipient, attachmentID string) error {
+		// Direct messages share with their recipient, broadcasts with everyone
+		var recipients []string
+		if recipient != "" {
+			recipients = []string{recipient}
+		}
+		_, err := attachmentService.Share(userID, attachmentID, recipients...)
+		return err
+	}
+	if name := os.Getenv("WS_SLOW_CONSUMER_POLICY"); name != "" {
+		policy, err := wsService.ParseSlowConsumerPolicy(name)
+		if err != nil {
+			log.Fatalf("Invalid WS_SLOW_CONSUMER_POLICY: %v", err)
+		}
+		config.SlowConsumerPolicy = policy
+	}
+	config.EnableCompression = os.Getenv("WS_COMPRESSION") == "true"
+
+	// Direct and broadcast messages are routed by the lab02 chat broker.
+	// Offline mailboxes are off: anyone can address a made-up name, and
+	// with a backplane the recipient may be connected to another node.
+	brokerConfig := chatcore.DefaultConfig()
+	brokerConfig.MailboxCapacity = 0
+	broker := chatcore.NewBrokerWithConfig(context.Background(), brokerConfig)
+	broker.Use(chatcore.SpamFilter(5, 10*time.Second))
+	go broker.Run()
+	config.Broker = broker
+
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		service, err := wsService.NewServiceWithConfig(config)
+		if err != nil {
+			log.Fatalf("Failed to create WebSocket service: %v", err)
+		}
+		return service
+	}
+
+	backplane := wsService.NewRedisBackplane(redisAddr, "lab06:websocket")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := backplane.Ping(ctx); err != nil {
+		log.Fatalf("Failed to connect to Redis backplane at %s: %v", redisAddr, err)
+	}
+
+	config.Backplane = backplane
+	service, err := wsService.NewServiceWithConfig(config)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to Redis backplane: %v", err)
+	}
+
+	log.Printf("WebSocket service using Redis backplane at %s", redisAddr)
+	return service
+}
@@ -0,0 +1,222 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrBackplaneClosed is returned when publishing to or subscribing on a closed backplane
+var ErrBackplaneClosed = errors.New("backplane closed")
+
+// Envelope wraps a hub message with the node that produced it
+type Envelope struct {
+	Node    string  `json:"node"`
+	Message Message `json:"message"`
+}
+
+// Backplane carries hub messages between service instances.
+// Every subscriber receives every published envelope, including the
+// publisher's own; hubs drop envelopes that carry their own node ID.
+type Backplane interface {
+	Publish(ctx context.Context, env Envelope) error
+	Subscribe(ctx context.Context) (<-chan Envelope, error)
+	Close() error
+}
+
+// newNodeID returns a random identifier for a hub instance
+func newNodeID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("⚠️ Failed to generate node ID: %v", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// MemoryBackplane is an in-process backplane shared by hubs in the same process.
+// Like Redis pub/sub it does not wait for slow subscribers: an envelope that
+// does not fit in a subscriber's buffer is dropped for that subscriber.
+type MemoryBackplane struct {
+	subscribers map[chan Envelope]struct{}
+	closed      bool
+	dropped     atomic.Uint64 // Envelopes dropped for full subscribers
+	mutex       sync.RWMutex
+}
+
+// NewMemoryBackplane creates a new in-process backplane
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{
+		subscribers: make(map[chan Envelope]struct{}),
+	}
+}
+
+// Publish delivers the envelope to every subscriber with room for it.
+// It never blocks, so the lock is not held while waiting on a subscriber.
+func (b *MemoryBackplane) Publish(ctx context.Context, env Envelope) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.closed {
+		return ErrBackplaneClosed
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub <- env:
+		default:
+			b.dropped.Add(1)
+			log.Printf("❌ Backplane subscriber full - dropping message from node %s", env.Node)
+		}
+	}
+	return nil
+}
+
+// Dropped returns how many envelopes were dropped for full subscribers
+func (b *MemoryBackplane) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Subscribe returns a channel receiving all published envelopes until ctx is done
+func (b *MemoryBackplane) Subscribe(ctx context.Context) (<-chan Envelope, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, ErrBackplaneClosed
+	}
+
+	sub := make(chan Envelope, 256)
+	b.subscribers[sub] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subscribers[sub]; ok {
+			delete(b.subscribers, sub)
+			close(sub)
+		}
+	}()
+
+	return sub, nil
+}
+
+// Close closes all subscriber channels
+func (b *MemoryBackplane) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub)
+	}
+	return nil
+}
+
+// RedisBackplane is a backplane built on Redis pub/sub
+type RedisBackplane struct {
+	client  *redis.Client
+	channel string
+	subs    []*redis.PubSub
+	closed  bool
+	mutex   sync.Mutex
+}
+
+// NewRedisBackplane creates a backplane publishing to channel on the Redis server at addr
+func NewRedisBackplane(addr, channel string) *RedisBackplane {
+	return &RedisBackplane{
+		client:  redis.NewClient(&redis.Options{Addr: addr}),
+		channel: channel,
+	}
+}
+
+// Ping checks that the Redis server is reachable
+func (b *RedisBackplane) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx).Err()
+}
+
+// Publish sends the envelope to the Redis channel
+func (b *RedisBackplane) Publish(ctx context.Context, env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, data).Err()
+}
+
+// Subscribe returns a channel receiving all envelopes published on the Redis channel
+func (b *RedisBackplane) Subscribe(ctx context.Context) (<-chan Envelope, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, ErrBackplaneClosed
+	}
+
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	// Wait for the subscription to be confirmed so no publish is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	b.subs = append(b.subs, pubsub)
+
+	out := make(chan Envelope, 256)
+	go func() {
+		defer close(out)
+		messages := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var env Envelope
+				if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+					log.Printf("⚠️ Dropping malformed backplane payload: %v", err)
+					continue
+				}
+				select {
+				case out <- env:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Close closes all subscriptions and the Redis client
+func (b *RedisBackplane) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, pubsub := range b.subs {
+		pubsub.Close()
+	}
+	b.subs = nil
+	return b.client.Close()
+}
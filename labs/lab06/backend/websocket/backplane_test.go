@@ -0,0 +1,195 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newBackplaneClient registers a mock client on the service's hub and drains its welcome message
+func newBackplaneClient(t *testing.T, service *Service, userID string) *Client {
+	t.Helper()
+
+	client := &Client{
+		send:   make(chan Message, 16),
+		hub:    service.hub,
+		userID: userID,
+	}
+	service.hub.register <- client
+
+	select {
+	case msg := <-client.send:
+		if msg.Type != "system" {
+			t.Fatalf("Expected welcome message, got type '%s'", msg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Client %s did not receive welcome message", userID)
+	}
+
+	return client
+}
+
+// expectContent waits for a message with the given content on the client
+func expectContent(t *testing.T, client *Client, content string) {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg := <-client.send:
+			if msg.Content == content {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Client %s did not receive '%s'", client.userID, content)
+		}
+	}
+}
+
+// expectNoContent verifies the client does not receive a message with the given content
+func expectNoContent(t *testing.T, client *Client, content string) {
+	t.Helper()
+
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case msg := <-client.send:
+			if msg.Content == content {
+				t.Fatalf("Client %s received unexpected duplicate '%s'", client.userID, content)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func testBackplanePropagation(t *testing.T, node1, node2 *Service) {
+	alice := newBackplaneClient(t, node1, "alice")
+	bob := newBackplaneClient(t, node2, "bob")
+
+	// Presence: alice sees bob join although he is connected to another node
+	expectContent(t, alice, "bob joined the chat")
+
+	node1.BroadcastMessage(Message{Type: "message", Content: "hello from node1", User: "alice"})
+	expectContent(t, alice, "hello from node1")
+	expectContent(t, bob, "hello from node1")
+
+	node2.BroadcastMessage(Message{Type: "message", Content: "hello from node2", User: "bob"})
+	expectContent(t, alice, "hello from node2")
+	expectContent(t, bob, "hello from node2")
+
+	// Each node must deliver exactly once despite receiving its own publish back
+	expectNoContent(t, alice, "hello from node1")
+	expectNoContent(t, bob, "hello from node2")
+
+	node2.hub.unregister <- bob
+	expectContent(t, alice, "bob left the chat")
+}
+
+func TestMemoryBackplane_Propagation(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	defer backplane.Close()
+
+	node1, err := NewServiceWithBackplane(backplane)
+	if err != nil {
+		t.Fatalf("Failed to create node1: %v", err)
+	}
+	node2, err := NewServiceWithBackplane(backplane)
+	if err != nil {
+		t.Fatalf("Failed to create node2: %v", err)
+	}
+
+	testBackplanePropagation(t, node1, node2)
+}
+
+func TestMemoryBackplane_Closed(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	sub, err := backplane.Subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	backplane.Close()
+
+	if _, ok := <-sub; ok {
+		t.Error("Expected subscription channel to be closed")
+	}
+	if err := backplane.Publish(context.Background(), Envelope{}); err != ErrBackplaneClosed {
+		t.Errorf("Expected ErrBackplaneClosed from Publish, got %v", err)
+	}
+	if _, err := NewServiceWithBackplane(backplane); err != ErrBackplaneClosed {
+		t.Errorf("Expected ErrBackplaneClosed from NewServiceWithBackplane, got %v", err)
+	}
+}
+
+func TestMemoryBackplane_SlowSubscriber(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	defer backplane.Close()
+
+	// Nobody reads from stalled
+	stalled, err := backplane.Subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	active, err := backplane.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < cap(stalled)+1; i++ {
+			backplane.Publish(context.Background(), Envelope{Node: "n1"})
+			<-active
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+	if dropped := backplane.Dropped(); dropped != 1 {
+		t.Errorf("Expected 1 dropped envelope, got %d", dropped)
+	}
+
+	// Unsubscribing needs the write lock, which Publish must not be holding
+	cancel()
+	select {
+	case _, ok := <-active:
+		if ok {
+			t.Error("Expected the cancelled subscription to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Unsubscribe did not complete")
+	}
+}
+
+func TestRedisBackplane_Propagation(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	// Separate backplanes model separate processes sharing one Redis server
+	backplane1 := NewRedisBackplane(server.Addr(), "test:websocket")
+	defer backplane1.Close()
+	backplane2 := NewRedisBackplane(server.Addr(), "test:websocket")
+	defer backplane2.Close()
+
+	if err := backplane1.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	node1, err := NewServiceWithBackplane(backplane1)
+	if err != nil {
+		t.Fatalf("Failed to create node1: %v", err)
+	}
+	node2, err := NewServiceWithBackplane(backplane2)
+	if err != nil {
+		t.Fatalf("Failed to create node2: %v", err)
+	}
+
+	testBackplanePropagation(t, node1, node2)
+}
@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"lab02/chatcore"
+)
+
+// brokerBridge routes user messages through a chatcore.Broker. Each user
+// with at least one connection is registered with the broker once; a pump
+// goroutine per user hands routed messages to the hub, which still owns
+// every client's send queue. Join/leave and other system messages bypass
+// the broker.
+type brokerBridge struct {
+	broker *chatcore.Broker
+	hub    *Hub
+	mutex  sync.Mutex
+	users  map[string]*bridgedUser
+}
+
+// bridgedUser is one user's broker registration and their connections
+type bridgedUser struct {
+	clients map[*Client]bool
+	recv    chan chatcore.Message
+	stop    chan struct{}
+}
+
+func newBrokerBridge(broker *chatcore.Broker, hub *Hub) *brokerBridge {
+	return &brokerBridge{
+		broker: broker,
+		hub:    hub,
+		users:  make(map[string]*bridgedUser),
+	}
+}
+
+// toBrokerMessage maps a WebSocket message to a broker message. Messages
+// without a recipient are broadcast. The original message travels as the
+// payload so fields the broker does not know about survive routing.
+func toBrokerMessage(message Message) chatcore.Message {
+	return chatcore.Message{
+		Sender:    message.User,
+		Recipient: message.Recipient,
+		Content:   message.Content,
+		Broadcast: message.Recipient == "",
+		Timestamp: message.Timestamp.Unix(),
+		Payload:   message,
+	}
+}
+
+// fromBrokerMessage maps a routed broker message back to a WebSocket
+// message. Content comes from the broker message since middleware may
+// have rewritten it.
+func fromBrokerMessage(routed chatcore.Message) Message {
+	message, ok := routed.Payload.(Message)
+	if !ok {
+		message = Message{
+			Type:      "message",
+			User:      routed.Sender,
+			Recipient: routed.Recipient,
+			Timestamp: time.Unix(routed.Timestamp, 0),
+		}
+	}
+	message.Content = routed.Content
+	return message
+}
+
+// attach registers client's user with the broker on their first connection
+func (b *brokerBridge) attach(client *Client) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	user, ok := b.users[client.userID]
+	if !ok {
+		user = &bridgedUser{
+			clients: make(map[*Client]bool),
+			recv:    make(chan chatcore.Message, b.hub.sendBufferSize),
+			stop:    make(chan struct{}),
+		}
+		b.users[client.userID] = user
+		b.broker.RegisterUser(client.userID, user.recv)
+		go b.pump(client.userID, user)
+	}
+	user.clients[client] = true
+}
+
+// detach unregisters client's user from the broker when their last
+// connection goes away
+func (b *brokerBridge) detach(client *Client) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	user, ok := b.users[client.userID]
+	if !ok {
+		return
+	}
+	delete(user.clients, client)
+	if len(user.clients) == 0 {
+		b.broker.UnregisterUser(client.userID)
+		delete(b.users, client.userID)
+		close(user.stop)
+	}
+}
+
+// pump forwards messages routed to userID to each of their connections
+func (b *brokerBridge) pump(userID string, user *bridgedUser) {
+	for {
+		select {
+		case <-user.stop:
+			return
+		case routed := <-user.recv:
+			message := fromBrokerMessage(routed)
+
+			b.mutex.Lock()
+			clients := make([]*Client, 0, len(user.clients))
+			for client := range user.clients {
+				clients = append(clients, client)
+			}
+			b.mutex.Unlock()
+
+			for _, client := range clients {
+				select {
+				case b.hub.direct <- delivery{client: client, message: message}:
+				case <-user.stop:
+					return
+				}
+			}
+			// The hub owns delivery from here; redelivering would only duplicate
+			b.broker.Ack(userID, routed.ID)
+		}
+	}
+}
+
+// send routes a message from a client through the broker, returning the
+// broker's error when middleware rejects it
+func (b *brokerBridge) send(message Message) error {
+	routed, err := b.broker.Submit(toBrokerMessage(message))
+	if err != nil {
+		return err
+	}
+	// Other nodes deliver the message locally without their broker, so
+	// they get it as this broker's middleware left it. A direct message
+	// only reaches the recipient's connections there.
+	b.hub.publish(fromBrokerMessage(routed))
+	log.Printf("🔀 Routed message from %s through broker", message.User)
+	return nil
+}
@@ -0,0 +1,298 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"lab02/chatcore"
+)
+
+// newBrokerServer starts a service routed through a chatcore broker
+func newBrokerServer(t *testing.T, middleware ...chatcore.Middleware) *httptest.Server {
+	t.Helper()
+	return newBrokerServerWithConfig(t, DefaultConfig(), middleware...)
+}
+
+// newBrokerServerWithConfig is newBrokerServer with a base configuration
+func newBrokerServerWithConfig(t *testing.T, config Config, middleware ...chatcore.Middleware) *httptest.Server {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	broker := chatcore.NewBroker(ctx)
+	broker.Use(middleware...)
+	go broker.Run()
+
+	config.Broker = broker
+	service, err := NewServiceWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(service.handleWebSocket))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// expectNoChat fails if a chat message arrives within a short period. The
+// read deadline breaks the connection, so call it last.
+func expectNoChat(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Type == "message" {
+			t.Errorf("Unexpected message from %s: %s", msg.User, msg.Content)
+		}
+	}
+}
+
+func TestBridge_MessageMapping(t *testing.T) {
+	original := Message{
+		Type:        "message",
+		Content:     "darn",
+		User:        "alice",
+		Recipient:   "bob",
+		Timestamp:   time.Now(),
+		Attachments: []Attachment{{ID: "a1", Name: "notes.txt"}},
+	}
+
+	routed := toBrokerMessage(original)
+	if routed.Sender != "alice" || routed.Recipient != "bob" || routed.Broadcast {
+		t.Errorf("Unexpected broker message: %+v", routed)
+	}
+
+	// Middleware may rewrite content; everything else survives routing
+	routed.Content = "****"
+	message := fromBrokerMessage(routed)
+	if message.Content != "****" || len(message.Attachments) != 1 || message.Recipient != "bob" {
+		t.Errorf("Unexpected WebSocket message: %+v", message)
+	}
+
+	if routed := toBrokerMessage(Message{User: "alice", Content: "hi"}); !routed.Broadcast {
+		t.Error("Messages without a recipient should be broadcast")
+	}
+
+	// Messages sent straight to the broker have no payload
+	message = fromBrokerMessage(chatcore.Message{Sender: "ops", Content: "deploy", Timestamp: 100})
+	if message.Type != "message" || message.User != "ops" || message.Timestamp.Unix() != 100 {
+		t.Errorf("Unexpected WebSocket message from bare broker message: %+v", message)
+	}
+}
+
+func TestBridge_DirectAndBroadcast(t *testing.T) {
+	server := newBrokerServer(t)
+	alice := dialCodec(t, server.URL, "alice", nil)
+	bob := dialCodec(t, server.URL, "bob", nil)
+	carol := dialCodec(t, server.URL, "carol", nil)
+	for _, conn := range []*websocket.Conn{alice, bob, carol} {
+		readUntil(t, conn, "system")
+	}
+
+	if err := alice.WriteJSON(Message{Type: "message", Content: "psst", Recipient: "bob"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	msg := readUntil(t, bob, "message")
+	if msg.Content != "psst" || msg.User != "alice" || msg.Recipient != "bob" {
+		t.Errorf("Unexpected direct message: %+v", msg)
+	}
+
+	if err := carol.WriteJSON(Message{Type: "message", Content: "hello all"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	// Carol's first chat message being the broadcast shows the direct one skipped her
+	for _, conn := range []*websocket.Conn{alice, bob, carol} {
+		if msg := readUntil(t, conn, "message"); msg.Content != "hello all" || msg.User != "carol" {
+			t.Errorf("Unexpected broadcast: %+v", msg)
+		}
+	}
+}
+
+func TestBridge_MultipleConnectionsPerUser(t *testing.T) {
+	server := newBrokerServer(t)
+	alice := dialCodec(t, server.URL, "alice", nil)
+	phone := dialCodec(t, server.URL, "bob", nil)
+	laptop := dialCodec(t, server.URL, "bob", nil)
+	for _, conn := range []*websocket.Conn{alice, phone, laptop} {
+		readUntil(t, conn, "system")
+	}
+
+	alice.WriteJSON(Message{Type: "message", Content: "both", Recipient: "bob"})
+	readUntil(t, phone, "message")
+	readUntil(t, laptop, "message")
+
+	// Closing one connection keeps the user registered with the broker
+	phone.Close()
+	readUntil(t, alice, "notification")
+	alice.WriteJSON(Message{Type: "message", Content: "still there", Recipient: "bob"})
+	if msg := readUntil(t, laptop, "message"); msg.Content != "still there" {
+		t.Errorf("Expected 'still there', got %q", msg.Content)
+	}
+}
+
+func TestBridge_OfflineDelivery(t *testing.T) {
+	server := newBrokerServer(t)
+	alice := dialCodec(t, server.URL, "alice", nil)
+	readUntil(t, alice, "system")
+
+	if err := alice.WriteJSON(Message{Type: "message", Content: "see you later", Recipient: "bob"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	bob := dialCodec(t, server.URL, "bob", nil)
+	if msg := readUntil(t, bob, "message"); msg.Content != "see you later" {
+		t.Errorf("Expected mailbox message, got %q", msg.Content)
+	}
+}
+
+func TestBridge_MiddlewareRejection(t *testing.T) {
+	server := newBrokerServer(t, chatcore.ProfanityFilter([]string{"darn"}, false))
+	alice := dialCodec(t, server.URL, "alice", nil)
+	bob := dialCodec(t, server.URL, "bob", nil)
+	readUntil(t, bob, "system")
+
+	if err := alice.WriteJSON(Message{Type: "message", Content: "oh darn"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	msg := readUntil(t, alice, "error")
+	if !strings.Contains(msg.Content, chatcore.ErrProfanity.Error()) {
+		t.Errorf("Expected profanity error, got %q", msg.Content)
+	}
+	expectNoChat(t, bob)
+}
+
+func TestReadPump_DirectMessageWithoutBroker(t *testing.T) {
+	conn := dialLimited(t, DefaultConfig())
+
+	if err := conn.WriteJSON(Message{Type: "message", Content: "psst", Recipient: "bob"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	msg := readUntil(t, conn, "error")
+	if !strings.Contains(msg.Content, "direct messages") {
+		t.Errorf("Unexpected error content: %q", msg.Content)
+	}
+}
+
+func TestBridge_SendReturnsBrokerError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	broker := chatcore.NewBroker(ctx)
+	cancel()
+
+	bridge := newBrokerBridge(broker, newHub(DefaultConfig()))
+	if err := bridge.send(Message{User: "alice", Content: "hi"}); !errors.Is(err, chatcore.ErrBrokerClosed) {
+		t.Errorf("Expected ErrBrokerClosed, got %v", err)
+	}
+}
+
+// TestBridge_BackplaneCarriesMiddlewareResult checks that other nodes get a
+// broadcast as the broker's middleware left it, and never get one it rejected
+func TestBridge_BackplaneCarriesMiddlewareResult(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	defer backplane.Close()
+
+	config := DefaultConfig()
+	config.Backplane = backplane
+	server := newBrokerServerWithConfig(t, config,
+		chatcore.MaxLength(20),
+		chatcore.ProfanityFilter([]string{"darn"}, true),
+	)
+	remote, err := NewServiceWithBackplane(backplane)
+	if err != nil {
+		t.Fatalf("Failed to create remote node: %v", err)
+	}
+
+	alice := dialCodec(t, server.URL, "alice", nil)
+	readUntil(t, alice, "system")
+	bob := newBackplaneClient(t, remote, "bob")
+
+	if err := alice.WriteJSON(Message{Type: "message", Content: "oh darn"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	expectContent(t, bob, "oh ****")
+
+	if err := alice.WriteJSON(Message{Type: "message", Content: strings.Repeat("x", 21)}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	readUntil(t, alice, "error")
+	expectNoContent(t, bob, strings.Repeat("x", 21))
+	expectNoContent(t, bob, "oh darn")
+}
+
+func TestBridge_DirectMessageAcrossNodes(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	defer backplane.Close()
+
+	config := DefaultConfig()
+	config.Backplane = backplane
+	server := newBrokerServerWithConfig(t, config)
+	remote, err := NewServiceWithBackplane(backplane)
+	if err != nil {
+		t.Fatalf("Failed to create remote node: %v", err)
+	}
+
+	alice := dialCodec(t, server.URL, "alice", nil)
+	readUntil(t, alice, "system")
+	bob := newBackplaneClient(t, remote, "bob")
+	carol := newBackplaneClient(t, remote, "carol")
+
+	if err := alice.WriteJSON(Message{Type: "message", Content: "psst", Recipient: "bob"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	expectContent(t, bob, "psst")
+	expectNoContent(t, carol, "psst")
+}
+
+func TestBridge_SharesAttachmentsOnlyWhenAccepted(t *testing.T) {
+	var mutex sync.Mutex
+	var shares []string
+
+	config := DefaultConfig()
+	config.ResolveAttachment = func(userID, recipient, attachmentID string) (Attachment, error) {
+		return Attachment{ID: attachmentID}, nil
+	}
+	config.ShareAttachment = func(userID, recipient, attachmentID string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		shares = append(shares, attachmentID+">"+recipient)
+		return nil
+	}
+	server := newBrokerServerWithConfig(t, config, chatcore.ProfanityFilter([]string{"darn"}, false))
+	alice := dialCodec(t, server.URL, "alice", nil)
+	bob := dialCodec(t, server.URL, "bob", nil)
+	readUntil(t, alice, "system")
+	readUntil(t, bob, "system")
+
+	rejected := Message{Type: "message", Content: "oh darn", Recipient: "bob", Attachments: []Attachment{{ID: "a1"}}}
+	if err := alice.WriteJSON(rejected); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	readUntil(t, alice, "error")
+
+	accepted := Message{Type: "message", Content: "look", Recipient: "bob", Attachments: []Attachment{{ID: "a2"}}}
+	if err := alice.WriteJSON(accepted); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	readUntil(t, bob, "message")
+	// readPump handles messages in order, so the pong follows the sharing
+	alice.WriteJSON(Message{Type: "ping"})
+	readUntil(t, alice, "pong")
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(shares) != 1 || shares[0] != "a2>bob" {
+		t.Errorf("Expected only the accepted attachment to be shared with bob, got %v", shares)
+	}
+}
@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "lab06-backend/proto"
+)
+
+// Subprotocol names negotiated through Sec-WebSocket-Protocol
+const (
+	SubprotocolJSON     = "chat.v1.json"
+	SubprotocolMsgPack  = "chat.v1.msgpack"
+	SubprotocolProtobuf = "chat.v1.protobuf"
+)
+
+// Codec encodes messages for one WebSocket subprotocol
+type Codec interface {
+	// Subprotocol is the name clients request to select this codec
+	Subprotocol() string
+	// FrameType is websocket.TextMessage or websocket.BinaryMessage
+	FrameType() int
+	Marshal(message Message) ([]byte, error)
+	Unmarshal(data []byte, message *Message) error
+}
+
+// DefaultCodecs returns the built-in codecs in server preference order
+func DefaultCodecs() []Codec {
+	return []Codec{JSONCodec{}, MsgPackCodec{}, ProtobufCodec{}}
+}
+
+// JSONCodec encodes messages as JSON text frames. It is also used when a
+// client does not request any subprotocol.
+type JSONCodec struct{}
+
+// Subprotocol returns the JSON subprotocol name
+func (JSONCodec) Subprotocol() string { return SubprotocolJSON }
+
+// FrameType returns websocket.TextMessage
+func (JSONCodec) FrameType() int { return websocket.TextMessage }
+
+// Marshal encodes the message as JSON
+func (JSONCodec) Marshal(message Message) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+// Unmarshal decodes a JSON message
+func (JSONCodec) Unmarshal(data []byte, message *Message) error {
+	return json.Unmarshal(data, message)
+}
+
+// MsgPackCodec encodes messages as MessagePack binary frames using the
+// same field names as the JSON encoding
+type MsgPackCodec struct{}
+
+// Subprotocol returns the MessagePack subprotocol name
+func (MsgPackCodec) Subprotocol() string { return SubprotocolMsgPack }
+
+// FrameType returns websocket.BinaryMessage
+func (MsgPackCodec) FrameType() int { return websocket.BinaryMessage }
+
+// Marshal encodes the message as MessagePack
+func (MsgPackCodec) Marshal(message Message) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a MessagePack message
+func (MsgPackCodec) Unmarshal(data []byte, message *Message) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(message)
+}
+
+// ProtobufCodec encodes messages as proto.ChatMessage binary frames
+type ProtobufCodec struct{}
+
+// Subprotocol returns the protobuf subprotocol name
+func (ProtobufCodec) Subprotocol() string { return SubprotocolProtobuf }
+
+// FrameType returns websocket.BinaryMessage
+func (ProtobufCodec) FrameType() int { return websocket.BinaryMessage }
+
+// Marshal encodes the message as a protobuf ChatMessage
+func (ProtobufCodec) Marshal(message Message) ([]byte, error) {
+	chat := &pb.ChatMessage{
+		Type:      message.Type,
+		Content:   message.Content,
+		User:      message.User,
+		Recipient: message.Recipient,
+	}
+	if !message.Timestamp.IsZero() {
+		chat.Timestamp = timestamppb.New(message.Timestamp)
+	}
+	for _, attachment := range message.Attachments {
+		chat.Attachments = append(chat.Attachments, &pb.Attachment{
+			Id:           attachment.ID,
+			Name:         attachment.Name,
+			MimeType:     attachment.MIMEType,
+			Size:         attachment.Size,
+			HasThumbnail: attachment.HasThumbnail,
+		})
+	}
+	return protobuf.Marshal(chat)
+}
+
+// Unmarshal decodes a protobuf ChatMessage
+func (ProtobufCodec) Unmarshal(data []byte, message *Message) error {
+	var chat pb.ChatMessage
+	if err := protobuf.Unmarshal(data, &chat); err != nil {
+		return err
+	}
+
+	*message = Message{
+		Type:      chat.GetType(),
+		Content:   chat.GetContent(),
+		User:      chat.GetUser(),
+		Recipient: chat.GetRecipient(),
+	}
+	if chat.Timestamp != nil {
+		message.Timestamp = chat.Timestamp.AsTime()
+	}
+	for _, attachment := range chat.GetAttachments() {
+		message.Attachments = append(message.Attachments, Attachment{
+			ID:           attachment.GetId(),
+			Name:         attachment.GetName(),
+			MIMEType:     attachment.GetMimeType(),
+			Size:         attachment.GetSize(),
+			HasThumbnail: attachment.GetHasThumbnail(),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	original := Message{
+		Type:      "message",
+		Content:   "Hello, мир",
+		User:      "alice",
+		Recipient: "bob",
+		Timestamp: time.Date(2025, 7, 1, 12, 30, 0, 123000000, time.UTC),
+		Attachments: []Attachment{
+			{ID: "a1", Name: "cat.png", MIMEType: "image/png", Size: 2048, HasThumbnail: true},
+		},
+	}
+
+	for _, codec := range DefaultCodecs() {
+		t.Run(codec.Subprotocol(), func(t *testing.T) {
+			data, err := codec.Marshal(original)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+
+			var decoded Message
+			if err := codec.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+
+			if decoded.Type != original.Type || decoded.Content != original.Content || decoded.User != original.User || decoded.Recipient != original.Recipient {
+				t.Errorf("Expected %+v, got %+v", original, decoded)
+			}
+			if !decoded.Timestamp.Equal(original.Timestamp) {
+				t.Errorf("Expected timestamp %v, got %v", original.Timestamp, decoded.Timestamp)
+			}
+			if len(decoded.Attachments) != 1 || decoded.Attachments[0] != original.Attachments[0] {
+				t.Errorf("Expected attachments %+v, got %+v", original.Attachments, decoded.Attachments)
+			}
+		})
+	}
+}
+
+func TestCodecs_RejectGarbage(t *testing.T) {
+	for _, codec := range DefaultCodecs() {
+		var message Message
+		if err := codec.Unmarshal([]byte{0xff, 0x00, 0x13}, &message); err == nil {
+			t.Errorf("%s: expected error for malformed payload", codec.Subprotocol())
+		}
+	}
+}
+
+// dialCodec connects to the server requesting the given subprotocols
+func dialCodec(t *testing.T, serverURL, userID string, subprotocols []string) *websocket.Conn {
+	t.Helper()
+
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	wsURL := "ws" + strings.TrimPrefix(serverURL, "http") + "?user_id=" + userID
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// readCodec reads messages with the codec until one of the given type arrives
+func readCodec(t *testing.T, conn *websocket.Conn, codec Codec, messageType string) Message {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		frameType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read '%s' message: %v", messageType, err)
+		}
+		if frameType != codec.FrameType() {
+			t.Fatalf("Expected frame type %d, got %d", codec.FrameType(), frameType)
+		}
+
+		var msg Message
+		if err := codec.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("Failed to decode message: %v", err)
+		}
+		if msg.Type == messageType {
+			return msg
+		}
+	}
+}
+
+func TestService_SubprotocolNegotiation(t *testing.T) {
+	service := NewService()
+	server := httptest.NewServer(http.HandlerFunc(service.handleWebSocket))
+	defer server.Close()
+
+	// One client per codec; every client must see every other client's message
+	codecs := DefaultCodecs()
+	conns := make([]*websocket.Conn, len(codecs))
+	for i, codec := range codecs {
+		conns[i] = dialCodec(t, server.URL, "user"+codec.Subprotocol(), []string{codec.Subprotocol()})
+		if got := conns[i].Subprotocol(); got != codec.Subprotocol() {
+			t.Fatalf("Expected subprotocol %s, got '%s'", codec.Subprotocol(), got)
+		}
+		readCodec(t, conns[i], codec, "system")
+	}
+
+	for i, sender := range codecs {
+		data, err := sender.Marshal(Message{Type: "message", Content: "from " + sender.Subprotocol()})
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if err := conns[i].WriteMessage(sender.FrameType(), data); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+
+		for j, receiver := range codecs {
+			msg := readCodec(t, conns[j], receiver, "message")
+			if msg.Content != "from "+sender.Subprotocol() {
+				t.Errorf("%s received '%s', expected message from %s", receiver.Subprotocol(), msg.Content, sender.Subprotocol())
+			}
+		}
+	}
+}
+
+func TestService_DefaultsToJSON(t *testing.T) {
+	service := NewService()
+	server := httptest.NewServer(http.HandlerFunc(service.handleWebSocket))
+	defer server.Close()
+
+	conn := dialCodec(t, server.URL, "legacy", nil)
+	if conn.Subprotocol() != "" {
+		t.Errorf("Expected no subprotocol, got '%s'", conn.Subprotocol())
+	}
+
+	var welcome Message
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&welcome); err != nil {
+		t.Fatalf("Failed to read JSON welcome: %v", err)
+	}
+	if welcome.Type != "system" {
+		t.Errorf("Expected system welcome message, got '%s'", welcome.Type)
+	}
+}
+
+func TestService_MalformedMessageKeepsConnection(t *testing.T) {
+	service := NewService()
+	server := httptest.NewServer(http.HandlerFunc(service.handleWebSocket))
+	defer server.Close()
+
+	codec := ProtobufCodec{}
+	conn := dialCodec(t, server.URL, "broken", []string{SubprotocolProtobuf})
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte{0xff, 0x00, 0x13}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	readCodec(t, conn, codec, "error")
+
+	data, _ := codec.Marshal(Message{Type: "message", Content: "still here"})
+	if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if msg := readCodec(t, conn, codec, "message"); msg.Content != "still here" {
+		t.Errorf("Expected 'still here', got '%s'", msg.Content)
+	}
+}
+
+func TestService_Compression(t *testing.T) {
+	config := DefaultConfig()
+	config.EnableCompression = true
+	service, err := NewServiceWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(service.handleWebSocket))
+	defer server.Close()
+
+	dialer := websocket.Dialer{EnableCompression: true, Subprotocols: []string{SubprotocolJSON}}
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?user_id=zip"
+	conn, resp, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	if extensions := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(extensions, "permessage-deflate") {
+		t.Fatalf("Expected permessage-deflate to be negotiated, got '%s'", extensions)
+	}
+
+	content := strings.Repeat("compress me ", 50)
+	if err := conn.WriteJSON(Message{Type: "message", Content: content}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if msg := readCodec(t, conn, JSONCodec{}, "message"); msg.Content != content {
+		t.Error("Compressed message content mismatch")
+	}
+}
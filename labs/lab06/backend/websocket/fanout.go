@@ -0,0 +1,236 @@
+package websocket
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// SlowConsumerPolicy decides what the hub does when a client's send queue is full
+type SlowConsumerPolicy int
+
+const (
+	// PolicyDisconnect closes the connection of a client that cannot keep up
+	PolicyDisconnect SlowConsumerPolicy = iota
+	// PolicyDropOldest discards the oldest queued message to make room for the new one
+	PolicyDropOldest
+	// PolicyDropNewest discards the new message and keeps the queue as is
+	PolicyDropNewest
+)
+
+// String returns the configuration name of the policy
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case PolicyDisconnect:
+		return "disconnect"
+	case PolicyDropOldest:
+		return "drop_oldest"
+	case PolicyDropNewest:
+		return "drop_newest"
+	default:
+		return fmt.Sprintf("SlowConsumerPolicy(%d)", int(p))
+	}
+}
+
+// ParseSlowConsumerPolicy parses a policy name as returned by String
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	switch name {
+	case "disconnect":
+		return PolicyDisconnect, nil
+	case "drop_oldest":
+		return PolicyDropOldest, nil
+	case "drop_newest":
+		return PolicyDropNewest, nil
+	default:
+		return 0, fmt.Errorf("unknown slow consumer policy %q", name)
+	}
+}
+
+// clientStats holds per-client queue counters, safe for concurrent reads
+type clientStats struct {
+	enqueued atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// ClientQueueStats is a snapshot of a client's send queue
+type ClientQueueStats struct {
+	UserID   string `json:"user_id"`
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Enqueued uint64 `json:"enqueued"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// delivery is a message addressed to a single client
+type delivery struct {
+	client  *Client
+	message Message
+}
+
+// enqueue places a message on the client's send queue, applying the hub's
+// slow consumer policy when the queue is full. It must only be called from
+// the hub goroutine, which is the sole sender on client.send. It returns
+// false when the client should be disconnected.
+func (h *Hub) enqueue(client *Client, message Message) bool {
+	select {
+	case client.send <- message:
+		client.stats.enqueued.Add(1)
+		return true
+	default:
+	}
+
+	policy := h.policy
+	if policy == PolicyDropOldest && cap(client.send) == 0 {
+		// Nothing is ever queued on an unbuffered channel
+		policy = PolicyDropNewest
+	}
+
+	switch policy {
+	case PolicyDropNewest:
+		client.stats.dropped.Add(1)
+		h.droppedMessages.Add(1)
+		return true
+	case PolicyDropOldest:
+		for {
+			select {
+			case <-client.send:
+				client.stats.dropped.Add(1)
+				h.droppedMessages.Add(1)
+			default:
+			}
+			select {
+			case client.send <- message:
+				client.stats.enqueued.Add(1)
+				return true
+			default:
+			}
+		}
+	default:
+		return false
+	}
+}
+
+// fanOut enqueues a message for every registered client except exclude,
+// then disconnects any client the policy rejected
+func (h *Hub) fanOut(message Message, exclude *Client) {
+	var slow []*Client
+
+	h.mutex.RLock()
+	log.Printf("📡 Broadcasting message from %s to %d clients: %s", message.User, len(h.clients), message.Content)
+	for client := range h.clients {
+		if client == exclude {
+			continue
+		}
+
+		// Tests may hold messages back to exercise late delivery
+		if h.deliveryDelay != nil {
+			if delay := h.deliveryDelay(message); delay > 0 {
+				go func(c *Client, msg Message) {
+					time.Sleep(delay)
+					h.direct <- delivery{client: c, message: msg}
+				}(client, message)
+				continue
+			}
+		}
+
+		if !h.enqueue(client, message) {
+			slow = append(slow, client)
+		}
+	}
+	h.mutex.RUnlock()
+
+	for _, client := range slow {
+		h.disconnectSlow(client)
+	}
+}
+
+// sendToUser enqueues a message for every connection of one user
+func (h *Hub) sendToUser(userID string, message Message) {
+	var slow []*Client
+
+	h.mutex.RLock()
+	for client := range h.clients {
+		if client.userID == userID && !h.enqueue(client, message) {
+			slow = append(slow, client)
+		}
+	}
+	h.mutex.RUnlock()
+
+	for _, client := range slow {
+		h.disconnectSlow(client)
+	}
+}
+
+// deliver enqueues a message for a single client if it is still registered
+func (h *Hub) deliver(d delivery) {
+	h.mutex.RLock()
+	_, ok := h.clients[d.client]
+	accepted := !ok || h.enqueue(d.client, d.message)
+	h.mutex.RUnlock()
+
+	if !accepted {
+		h.disconnectSlow(d.client)
+	}
+}
+
+// removeClient deletes the client and closes its send channel exactly once.
+// It reports whether the client was registered.
+func (h *Hub) removeClient(client *Client) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+	delete(h.clients, client)
+	close(client.send)
+	if h.bridge != nil {
+		h.bridge.detach(client)
+	}
+	return true
+}
+
+// disconnectSlow removes a client whose queue overflowed. A client may be
+// reported more than once while leave notifications cascade; only the
+// first report counts.
+func (h *Hub) disconnectSlow(client *Client) {
+	if !h.removeClient(client) {
+		return
+	}
+
+	log.Printf("❌ Send queue full for %s - disconnecting slow client", client.userID)
+	h.slowDisconnects.Add(1)
+	h.announceLeave(client)
+}
+
+// announceLeave tells every other client that client left the chat
+func (h *Hub) announceLeave(client *Client) {
+	notification := Message{
+		Type:      "notification",
+		Content:   client.userID + " left the chat",
+		User:      "system",
+		Timestamp: time.Now(),
+	}
+	log.Printf("📢 Notifying other clients that %s left", client.userID)
+	h.fanOut(notification, client)
+	h.publish(notification)
+}
+
+// queueStats returns a snapshot of every client's send queue
+func (h *Hub) queueStats() []ClientQueueStats {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	stats := make([]ClientQueueStats, 0, len(h.clients))
+	for client := range h.clients {
+		stats = append(stats, ClientQueueStats{
+			UserID:   client.userID,
+			Queued:   len(client.send),
+			Capacity: cap(client.send),
+			Enqueued: client.stats.enqueued.Load(),
+			Dropped:  client.stats.dropped.Load(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,248 @@
+package websocket
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	stressFastClients = 1600
+	stressSlowClients = 400
+	stressMessages    = 50
+	stressSlowBuffer  = 8
+)
+
+func TestSlowConsumerPolicy_Parse(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{PolicyDisconnect, PolicyDropOldest, PolicyDropNewest} {
+		parsed, err := ParseSlowConsumerPolicy(policy.String())
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", policy, err)
+		}
+		if parsed != policy {
+			t.Errorf("Expected %s, got %s", policy, parsed)
+		}
+	}
+
+	if _, err := ParseSlowConsumerPolicy("block"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+// stressHub builds a running hub with pre-registered fast and slow clients.
+// Fast clients drain their queues concurrently and are sized so they never
+// overflow; slow clients never read.
+func stressHub(t *testing.T, policy SlowConsumerPolicy) (*Hub, []*Client, []*Client, *sync.WaitGroup, []int) {
+	t.Helper()
+
+	hub := newHub(Config{SlowConsumerPolicy: policy})
+
+	fast := make([]*Client, stressFastClients)
+	received := make([]int, stressFastClients)
+	var readers sync.WaitGroup
+	for i := range fast {
+		fast[i] = &Client{
+			send:   make(chan Message, stressMessages+stressSlowClients),
+			hub:    hub,
+			userID: fmt.Sprintf("fast%d", i),
+		}
+		hub.clients[fast[i]] = true
+
+		readers.Add(1)
+		go func(i int) {
+			defer readers.Done()
+			for msg := range fast[i].send {
+				if msg.Type == "message" {
+					received[i]++
+				}
+			}
+		}(i)
+	}
+
+	slow := make([]*Client, stressSlowClients)
+	for i := range slow {
+		slow[i] = &Client{
+			send:   make(chan Message, stressSlowBuffer),
+			hub:    hub,
+			userID: fmt.Sprintf("slow%d", i),
+		}
+		hub.clients[slow[i]] = true
+	}
+
+	go hub.run()
+
+	for i := 0; i < stressMessages; i++ {
+		hub.broadcast <- Message{Type: "message", Content: fmt.Sprintf("msg%d", i), User: "stress"}
+	}
+	syncHub(hub)
+
+	return hub, fast, slow, &readers, received
+}
+
+// syncHub returns once the hub has finished processing every earlier event
+func syncHub(hub *Hub) {
+	hub.direct <- delivery{client: &Client{userID: "sync"}}
+}
+
+// closeFastClients unregisters the fast clients and waits for their readers
+func closeFastClients(t *testing.T, hub *Hub, fast []*Client, readers *sync.WaitGroup, received []int) {
+	t.Helper()
+
+	for _, client := range fast {
+		if dropped := client.stats.dropped.Load(); dropped != 0 {
+			t.Fatalf("%s dropped %d messages", client.userID, dropped)
+		}
+	}
+
+	// Leave notifications may overflow lagging readers during teardown,
+	// but every broadcast was already queued
+	for _, client := range fast {
+		hub.unregister <- client
+	}
+	readers.Wait()
+
+	for i, count := range received {
+		if count != stressMessages {
+			t.Fatalf("Fast client %d received %d messages, expected %d", i, count, stressMessages)
+		}
+	}
+}
+
+// drainQueue returns the contents of a queue nobody else is reading
+func drainQueue(client *Client) []string {
+	var contents []string
+	for {
+		select {
+		case msg, ok := <-client.send:
+			if !ok {
+				return contents
+			}
+			contents = append(contents, msg.Content)
+		default:
+			return contents
+		}
+	}
+}
+
+func TestFanOut_DropNewest(t *testing.T) {
+	hub, fast, slow, readers, received := stressHub(t, PolicyDropNewest)
+
+	stats := hub.queueStats()
+	if len(stats) != stressFastClients+stressSlowClients {
+		t.Fatalf("Expected %d clients in stats, got %d", stressFastClients+stressSlowClients, len(stats))
+	}
+
+	for _, client := range slow {
+		if dropped := client.stats.dropped.Load(); dropped != stressMessages-stressSlowBuffer {
+			t.Fatalf("%s dropped %d messages, expected %d", client.userID, dropped, stressMessages-stressSlowBuffer)
+		}
+		contents := drainQueue(client)
+		if len(contents) != stressSlowBuffer || contents[0] != "msg0" {
+			t.Fatalf("%s should keep the oldest messages, got %v", client.userID, contents)
+		}
+	}
+
+	if got := hub.droppedMessages.Load(); got != stressSlowClients*(stressMessages-stressSlowBuffer) {
+		t.Errorf("Expected %d dropped messages in total, got %d", stressSlowClients*(stressMessages-stressSlowBuffer), got)
+	}
+
+	closeFastClients(t, hub, fast, readers, received)
+}
+
+func TestFanOut_DropOldest(t *testing.T) {
+	hub, fast, slow, readers, received := stressHub(t, PolicyDropOldest)
+
+	first := fmt.Sprintf("msg%d", stressMessages-stressSlowBuffer)
+	for _, client := range slow {
+		if dropped := client.stats.dropped.Load(); dropped != stressMessages-stressSlowBuffer {
+			t.Fatalf("%s dropped %d messages, expected %d", client.userID, dropped, stressMessages-stressSlowBuffer)
+		}
+		contents := drainQueue(client)
+		if len(contents) != stressSlowBuffer || contents[0] != first {
+			t.Fatalf("%s should keep the newest messages, got %v", client.userID, contents)
+		}
+	}
+
+	if hub.slowDisconnects.Load() != 0 {
+		t.Errorf("Expected no disconnects, got %d", hub.slowDisconnects.Load())
+	}
+
+	closeFastClients(t, hub, fast, readers, received)
+}
+
+func TestFanOut_Disconnect(t *testing.T) {
+	hub, fast, slow, readers, received := stressHub(t, PolicyDisconnect)
+
+	if got := hub.slowDisconnects.Load(); got != stressSlowClients {
+		t.Fatalf("Expected %d slow disconnects, got %d", stressSlowClients, got)
+	}
+
+	hub.mutex.RLock()
+	for _, client := range slow {
+		if hub.clients[client] {
+			t.Fatalf("%s should have been disconnected", client.userID)
+		}
+	}
+	hub.mutex.RUnlock()
+
+	for _, client := range slow {
+		// The queue must have been closed exactly once after the buffered messages
+		drainQueue(client)
+		if _, ok := <-client.send; ok {
+			t.Fatalf("%s send channel should be closed", client.userID)
+		}
+		// A late unregister from the client's read pump must be harmless
+		hub.unregister <- client
+	}
+
+	closeFastClients(t, hub, fast, readers, received)
+}
+
+func TestFanOut_ConcurrentChurn(t *testing.T) {
+	hub := newHub(Config{SendBufferSize: 16, SlowConsumerPolicy: PolicyDisconnect})
+	hub.deliveryDelay = func(msg Message) time.Duration {
+		if msg.Content == "late" {
+			return 5 * time.Millisecond
+		}
+		return 0
+	}
+	go hub.run()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client := &Client{
+				send:   make(chan Message, hub.sendBufferSize),
+				hub:    hub,
+				userID: fmt.Sprintf("churn%d", i),
+			}
+			hub.register <- client
+
+			// Read a few messages, then go away like a closing read pump would
+			for j := 0; j < i%5; j++ {
+				if _, ok := <-client.send; !ok {
+					break
+				}
+			}
+			if i%3 == 0 {
+				hub.broadcast <- Message{Type: "message", Content: client.userID, User: client.userID}
+			}
+			hub.unregister <- client
+		}(i)
+	}
+	wg.Wait()
+
+	// Delayed deliveries must not race with channels being closed
+	hub.broadcast <- Message{Type: "message", Content: "late"}
+	time.Sleep(20 * time.Millisecond)
+
+	hub.mutex.RLock()
+	remaining := len(hub.clients)
+	hub.mutex.RUnlock()
+	if remaining != 0 {
+		t.Errorf("Expected all clients to be gone, got %d", remaining)
+	}
+}
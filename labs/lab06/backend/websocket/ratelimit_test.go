@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(2, 3, now)
+
+	for i := 0; i < 3; i++ {
+		if !bucket.allow(now) {
+			t.Fatalf("Expected burst token %d to be allowed", i)
+		}
+	}
+	if bucket.allow(now) {
+		t.Fatal("Expected empty bucket to reject")
+	}
+
+	// 2 tokens per second refills one token every 500ms
+	now = now.Add(500 * time.Millisecond)
+	if !bucket.allow(now) {
+		t.Fatal("Expected refilled token to be allowed")
+	}
+	if bucket.allow(now) {
+		t.Fatal("Expected bucket to be empty again")
+	}
+
+	// Refill never exceeds the burst size
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !bucket.allow(now) {
+			t.Fatalf("Expected token %d after long idle period", i)
+		}
+	}
+	if bucket.allow(now) {
+		t.Fatal("Expected refill to be capped at burst size")
+	}
+}
+
+// dialLimited starts a service with the given config and connects a client to it
+func dialLimited(t *testing.T, config Config) *websocket.Conn {
+	t.Helper()
+
+	service, err := NewServiceWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(service.handleWebSocket))
+	t.Cleanup(server.Close)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?user_id=limited"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// readUntil reads messages until one of the given type arrives
+func readUntil(t *testing.T, conn *websocket.Conn, messageType string) Message {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Failed to read '%s' message: %v", messageType, err)
+		}
+		if msg.Type == messageType {
+			return msg
+		}
+	}
+}
+
+// expectClose reads until the server closes the connection with the given code
+func expectClose(t *testing.T, conn *websocket.Conn, code int) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg Message
+		err := conn.ReadJSON(&msg)
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, code) {
+			t.Fatalf("Expected close code %d, got %v", code, err)
+		}
+		return
+	}
+}
+
+func TestReadPump_RateLimitWarnsThenDisconnects(t *testing.T) {
+	config := DefaultConfig()
+	config.RateLimit = 0.001
+	config.RateBurst = 3
+	config.MaxRateWarnings = 1
+	conn := dialLimited(t, config)
+
+	for i := 0; i < 4; i++ {
+		if err := conn.WriteJSON(Message{Type: "message", Content: "spam"}); err != nil {
+			t.Fatalf("Failed to send message %d: %v", i, err)
+		}
+	}
+
+	warning := readUntil(t, conn, "warning")
+	if !strings.Contains(warning.Content, "Rate limit") {
+		t.Errorf("Unexpected warning content: %s", warning.Content)
+	}
+
+	if err := conn.WriteJSON(Message{Type: "message", Content: "spam"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	expectClose(t, conn, websocket.ClosePolicyViolation)
+}
+
+func TestReadPump_RejectsLongContent(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxContentLength = 5
+	conn := dialLimited(t, config)
+
+	if err := conn.WriteJSON(Message{Type: "message", Content: "too long"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	readUntil(t, conn, "error")
+
+	// Length is counted in characters, not bytes
+	if err := conn.WriteJSON(Message{Type: "message", Content: "привет"[:10]}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	msg := readUntil(t, conn, "message")
+	if msg.Content != "приве" {
+		t.Errorf("Expected 5-character message to be broadcast, got '%s'", msg.Content)
+	}
+}
+
+func TestReadPump_RejectsOversizedFrame(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxMessageSize = 256
+	conn := dialLimited(t, config)
+
+	if err := conn.WriteJSON(Message{Type: "message", Content: strings.Repeat("x", 512)}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	expectClose(t, conn, websocket.CloseMessageTooBig)
+}
+
+func TestReadPump_IgnoresClientDelay(t *testing.T) {
+	conn := dialLimited(t, DefaultConfig())
+
+	start := time.Now()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"message","content":"now","delay":5000}`)); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	readUntil(t, conn, "message")
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Client-supplied delay should be ignored, message took %v", elapsed)
+	}
+}
@@ -1,30 +1,74 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
+
+	"lab02/chatcore"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
-	},
+// newUpgrader creates an upgrader offering the subprotocols of codecs
+func newUpgrader(codecs []Codec, enableCompression bool) websocket.Upgrader {
+	subprotocols := make([]string, len(codecs))
+	for i, codec := range codecs {
+		subprotocols[i] = codec.Subprotocol()
+	}
+
+	return websocket.Upgrader{
+		Subprotocols:      subprotocols,
+		EnableCompression: enableCompression,
+		CheckOrigin: func(r *http.Request) bool {
+			return true // Allow all origins for development
+		},
+	}
 }
 
-// Message represents a WebSocket message
+// Message represents a WebSocket message. Recipient makes it a direct
+// message, which requires a broker (see Config.Broker).
 type Message struct {
-	Type      string    `json:"type"`
-	Content   string    `json:"content"`
-	User      string    `json:"user"`
-	Timestamp time.Time `json:"timestamp"`
-	Delay     int       `json:"delay,omitempty"` // Delay in milliseconds for testing
+	Type        string       `json:"type"`
+	Content     string       `json:"content"`
+	User        string       `json:"user"`
+	Recipient   string       `json:"recipient,omitempty"`
+	Timestamp   time.Time    `json:"timestamp"`
+	Attachments []Attachment `json:"attachments,omitempty"`
 }
 
+// Attachment references a file uploaded through the attachments service
+type Attachment struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	MIMEType     string `json:"mime_type"`
+	Size         int64  `json:"size"`
+	HasThumbnail bool   `json:"has_thumbnail,omitempty"`
+}
+
+// AttachmentResolver checks that userID may share the attachment with the
+// message's recipient, "" for a broadcast, and returns its server-side
+// metadata. It must not grant access: the message may still be rejected.
+type AttachmentResolver func(userID, recipient, attachmentID string) (Attachment, error)
+
+// AttachmentSharer grants the message's recipient, or everyone for a
+// broadcast, access to a resolved attachment once the message is accepted
+type AttachmentSharer func(userID, recipient, attachmentID string) error
+
+// Authenticator returns the user making a WebSocket handshake, or "" when
+// the request carries no credentials. An error means the credentials are
+// invalid and the handshake is refused.
+type Authenticator func(r *http.Request) (string, error)
+
+// maxAttachments is the largest number of attachments in one message
+const maxAttachments = 10
+
 // Client represents a WebSocket client connection
 type Client struct {
 	conn     *websocket.Conn
@@ -33,35 +77,218 @@ type Client struct {
 	userID   string
 	isActive bool
 	mutex    sync.RWMutex
+	stats    clientStats
+	codec    Codec
 }
 
-// Hub maintains the set of active clients and broadcasts messages
+// Hub maintains the set of active clients and broadcasts messages.
+// The hub goroutine is the only sender on, and the only closer of, each
+// client's send channel.
 type Hub struct {
 	clients    map[*Client]bool
 	broadcast  chan Message
 	register   chan *Client
 	unregister chan *Client
+	direct     chan delivery
 	mutex      sync.RWMutex
+
+	policy          SlowConsumerPolicy
+	sendBufferSize  int
+	limits          connLimits
+	droppedMessages atomic.Uint64
+	slowDisconnects atomic.Uint64
+
+	// Cross-node fan-out; nil when running as a single instance
+	nodeID    string
+	backplane Backplane
+	remote    <-chan Envelope
+	outbound  chan Envelope
+
+	resolveAttachment AttachmentResolver
+	shareAttachment   AttachmentSharer
+
+	// Routing of user messages through chatcore; nil fans out in the hub
+	bridge *brokerBridge
+
+	// deliveryDelay lets tests hold back delivery of a message; nil in production
+	deliveryDelay func(Message) time.Duration
+}
+
+// connLimits bounds what a single connection may send. newHub replaces
+// zero or negative settings with the defaults, so limits cannot be turned
+// off through Config; only hubs built without newHub have zero limits,
+// which are not enforced.
+type connLimits struct {
+	maxMessageSize   int64
+	maxContentLength int
+	rateLimit        float64
+	rateBurst        int
+	maxRateWarnings  int
+}
+
+// Config holds tunable settings of the WebSocket service
+type Config struct {
+	// SendBufferSize is the capacity of each client's send queue
+	SendBufferSize int
+	// SlowConsumerPolicy decides what happens when a send queue is full
+	SlowConsumerPolicy SlowConsumerPolicy
+	// Backplane shares messages with other instances; nil for a single node
+	Backplane Backplane
+	// MaxMessageSize is the largest frame in bytes a client may send
+	MaxMessageSize int64
+	// MaxContentLength is the largest message content in characters
+	MaxContentLength int
+	// RateLimit is the sustained number of messages per second per connection
+	RateLimit float64
+	// RateBurst is the number of messages a connection may send at once
+	RateBurst int
+	// MaxRateWarnings is how many rate limit warnings a connection gets
+	// before it is disconnected
+	MaxRateWarnings int
+	// Codecs are the encodings clients may select by subprotocol, in server
+	// preference order. Clients that request no subprotocol get JSON.
+	Codecs []Codec
+	// EnableCompression negotiates permessage-deflate with clients that offer it
+	EnableCompression bool
+	// ResolveAttachment validates attachments referenced by clients;
+	// messages with attachments are rejected when it is nil
+	ResolveAttachment AttachmentResolver
+	// ShareAttachment is called for each attachment of a message after the
+	// broker accepts it, or before the hub broadcasts it without a broker
+	ShareAttachment AttachmentSharer
+	// Authenticate identifies connecting users. When it is nil clients name
+	// themselves with the user_id query parameter, which suits development
+	// only. Clients without a user are anonymous.
+	Authenticate Authenticator
+	// Broker routes user messages (direct and broadcast) when set, applying
+	// its middleware. The caller runs it. Without a broker, messages are
+	// broadcast by the hub and direct messages are rejected.
+	Broker *chatcore.Broker
+}
+
+// DefaultConfig returns the settings used by NewService
+func DefaultConfig() Config {
+	return Config{
+		SendBufferSize:     256,
+		SlowConsumerPolicy: PolicyDisconnect,
+		MaxMessageSize:     8192,
+		MaxContentLength:   1000,
+		RateLimit:          5,
+		RateBurst:          10,
+		MaxRateWarnings:    1,
+		Codecs:             DefaultCodecs(),
+	}
 }
 
 // Service represents the WebSocket service
 type Service struct {
-	hub *Hub
+	hub          *Hub
+	upgrader     websocket.Upgrader
+	codecs       map[string]Codec
+	authenticate Authenticator
 }
 
 // NewService creates a new WebSocket service
 func NewService() *Service {
-	hub := &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan Message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+	// Cannot fail without a backplane
+	service, _ := NewServiceWithConfig(DefaultConfig())
+	return service
+}
+
+// NewServiceWithBackplane creates a WebSocket service whose broadcasts and
+// join/leave notifications are shared with other instances through bp
+func NewServiceWithBackplane(bp Backplane) (*Service, error) {
+	config := DefaultConfig()
+	config.Backplane = bp
+	return NewServiceWithConfig(config)
+}
+
+// NewServiceWithConfig creates a WebSocket service with the given settings
+func NewServiceWithConfig(config Config) (*Service, error) {
+	hub := newHub(config)
+	if config.Broker != nil {
+		hub.bridge = newBrokerBridge(config.Broker, hub)
+	}
+
+	if config.Backplane != nil {
+		remote, err := config.Backplane.Subscribe(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		hub.nodeID = newNodeID()
+		hub.backplane = config.Backplane
+		hub.remote = remote
+		hub.outbound = make(chan Envelope, 256)
+		go hub.publishPump()
+	}
+
+	if len(config.Codecs) == 0 {
+		config.Codecs = DefaultCodecs()
+	}
+	codecs := make(map[string]Codec, len(config.Codecs))
+	for _, codec := range config.Codecs {
+		codecs[codec.Subprotocol()] = codec
+	}
+
+	authenticate := config.Authenticate
+	if authenticate == nil {
+		authenticate = func(r *http.Request) (string, error) {
+			return r.URL.Query().Get("user_id"), nil
+		}
 	}
 
-	service := &Service{hub: hub}
+	service := &Service{
+		hub:          hub,
+		upgrader:     newUpgrader(config.Codecs, config.EnableCompression),
+		codecs:       codecs,
+		authenticate: authenticate,
+	}
 	go hub.run()
 
-	return service
+	return service, nil
+}
+
+// newHub creates a hub with the given settings without starting it.
+// Zero-valued settings fall back to DefaultConfig.
+func newHub(config Config) *Hub {
+	defaults := DefaultConfig()
+	if config.SendBufferSize <= 0 {
+		config.SendBufferSize = defaults.SendBufferSize
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if config.MaxContentLength <= 0 {
+		config.MaxContentLength = defaults.MaxContentLength
+	}
+	if config.RateLimit <= 0 {
+		config.RateLimit = defaults.RateLimit
+	}
+	if config.RateBurst <= 0 {
+		config.RateBurst = defaults.RateBurst
+	}
+	if config.MaxRateWarnings <= 0 {
+		config.MaxRateWarnings = defaults.MaxRateWarnings
+	}
+
+	return &Hub{
+		clients:           make(map[*Client]bool),
+		broadcast:         make(chan Message),
+		register:          make(chan *Client),
+		unregister:        make(chan *Client),
+		direct:            make(chan delivery),
+		policy:            config.SlowConsumerPolicy,
+		sendBufferSize:    config.SendBufferSize,
+		resolveAttachment: config.ResolveAttachment,
+		shareAttachment:   config.ShareAttachment,
+		limits: connLimits{
+			maxMessageSize:   config.MaxMessageSize,
+			maxContentLength: config.MaxContentLength,
+			rateLimit:        config.RateLimit,
+			rateBurst:        config.RateBurst,
+			maxRateWarnings:  config.MaxRateWarnings,
+		},
+	}
 }
 
 // GetHandler returns the WebSocket HTTP handler
@@ -95,15 +322,17 @@ func (h *Hub) run() {
 				Timestamp: time.Now(),
 			}
 
-			select {
-			case client.send <- welcome:
-				log.Printf("👋 Welcome message sent to %s", client.userID)
-			default:
+			h.mutex.RLock()
+			accepted := h.enqueue(client, welcome)
+			h.mutex.RUnlock()
+			if !accepted {
 				log.Printf("❌ Failed to send welcome message to %s - closing connection", client.userID)
-				close(client.send)
-				h.mutex.Lock()
-				delete(h.clients, client)
-				h.mutex.Unlock()
+				h.removeClient(client)
+				continue
+			}
+
+			if h.bridge != nil {
+				h.bridge.attach(client)
 			}
 
 			// Notify others about new user
@@ -114,98 +343,64 @@ func (h *Hub) run() {
 				Timestamp: time.Now(),
 			}
 			log.Printf("📢 Notifying other clients that %s joined", client.userID)
-			h.broadcastToOthers(notification, client)
+			h.fanOut(notification, client)
+			h.publish(notification)
 
 		case client := <-h.unregister:
-			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				clientCount := len(h.clients)
-				h.mutex.Unlock()
-
-				log.Printf("➖ Client unregistered: %s (remaining clients: %d)", client.userID, clientCount)
-
-				// Notify others about user leaving
-				notification := Message{
-					Type:      "notification",
-					Content:   client.userID + " left the chat",
-					User:      "system",
-					Timestamp: time.Now(),
-				}
-				log.Printf("📢 Notifying other clients that %s left", client.userID)
-				h.broadcastToOthers(notification, client)
+			if h.removeClient(client) {
+				log.Printf("➖ Client unregistered: %s", client.userID)
+				h.announceLeave(client)
 			} else {
-				h.mutex.Unlock()
 				log.Printf("⚠️ Attempted to unregister unknown client: %s", client.userID)
 			}
 
 		case message := <-h.broadcast:
-			h.mutex.RLock()
-			clientCount := len(h.clients)
-			h.mutex.RUnlock()
+			h.fanOut(message, nil)
+			h.publish(message)
 
-			log.Printf("📡 Broadcasting message from %s to %d clients: %s", message.User, clientCount, message.Content)
+		case d := <-h.direct:
+			h.deliver(d)
 
-			h.mutex.RLock()
-			for client := range h.clients {
-				// Apply artificial delay if specified
-				if message.Delay > 0 {
-					log.Printf("⏱️ Applying %dms delay for message to %s", message.Delay, client.userID)
-					go func(c *Client, msg Message) {
-						time.Sleep(time.Duration(msg.Delay) * time.Millisecond)
-						select {
-						case c.send <- msg:
-							log.Printf("✅ Delayed message sent to %s", c.userID)
-						default:
-							log.Printf("❌ Failed to send delayed message to %s - closing connection", c.userID)
-							close(c.send)
-							h.mutex.Lock()
-							delete(h.clients, c)
-							h.mutex.Unlock()
-						}
-					}(client, message)
-				} else {
-					select {
-					case client.send <- message:
-						log.Printf("✅ Message sent to %s", client.userID)
-					default:
-						log.Printf("❌ Failed to send message to %s - closing connection", client.userID)
-						close(client.send)
-						delete(h.clients, client)
-					}
-				}
+		case env, ok := <-h.remote:
+			if !ok {
+				log.Printf("⚠️ Backplane subscription closed on node %s", h.nodeID)
+				h.remote = nil
+				continue
 			}
-			h.mutex.RUnlock()
+			if env.Node == h.nodeID {
+				continue
+			}
+			log.Printf("🌐 Message from node %s: %s", env.Node, env.Message.Content)
+			if env.Message.Recipient != "" {
+				h.sendToUser(env.Message.Recipient, env.Message)
+				continue
+			}
+			h.fanOut(env.Message, nil)
 		}
 	}
 }
 
-// broadcastToOthers sends a message to all clients except the specified one
-func (h *Hub) broadcastToOthers(message Message, sender *Client) {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
-
-	recipientCount := 0
-	for client := range h.clients {
-		if client != sender {
-			recipientCount++
-		}
+// publish queues a message for delivery to other nodes via the backplane
+func (h *Hub) publish(message Message) {
+	if h.backplane == nil {
+		return
 	}
 
-	log.Printf("📤 Broadcasting to %d other clients (excluding %s): %s", recipientCount, sender.userID, message.Content)
+	select {
+	case h.outbound <- Envelope{Node: h.nodeID, Message: message}:
+	default:
+		log.Printf("❌ Backplane queue full on node %s - dropping message", h.nodeID)
+	}
+}
 
-	for client := range h.clients {
-		if client != sender {
-			select {
-			case client.send <- message:
-				log.Printf("✅ Notification sent to %s", client.userID)
-			default:
-				log.Printf("❌ Failed to send notification to %s - closing connection", client.userID)
-				close(client.send)
-				delete(h.clients, client)
-			}
+// publishPump forwards queued messages to the backplane off the hub's event loop
+func (h *Hub) publishPump() {
+	for env := range h.outbound {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := h.backplane.Publish(ctx, env); err != nil {
+			log.Printf("❌ Backplane publish failed on node %s: %v", h.nodeID, err)
 		}
+		cancel()
 	}
 }
 
@@ -217,25 +412,37 @@ func (s *Service) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	userID, err := s.authenticate(r)
+	if err != nil {
+		log.Printf("❌ WebSocket authentication failed from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("❌ WebSocket upgrade failed: %v", err)
 		return
 	}
 
-	userID := r.URL.Query().Get("user_id")
+	codec, ok := s.codecs[conn.Subprotocol()]
+	if !ok {
+		codec = JSONCodec{}
+	}
+
 	if userID == "" {
 		userID = "anonymous_" + time.Now().Format("150405")
 	}
 
-	log.Printf("👤 WebSocket client connected: %s (from %s)", userID, r.RemoteAddr)
+	log.Printf("👤 WebSocket client connected: %s (from %s, codec %s)", userID, r.RemoteAddr, codec.Subprotocol())
 
 	client := &Client{
 		conn:     conn,
-		send:     make(chan Message, 256),
+		send:     make(chan Message, s.hub.sendBufferSize),
 		hub:      s.hub,
 		userID:   userID,
 		isActive: true,
+		codec:    codec,
 	}
 
 	s.hub.register <- client
@@ -253,9 +460,13 @@ func (s *Service) handleStats(w http.ResponseWriter, r *http.Request) {
 	s.hub.mutex.RUnlock()
 
 	stats := map[string]interface{}{
-		"active_connections": clientCount,
-		"service":            "websocket",
-		"timestamp":          time.Now().Unix(),
+		"active_connections":   clientCount,
+		"service":              "websocket",
+		"timestamp":            time.Now().Unix(),
+		"slow_consumer_policy": s.hub.policy.String(),
+		"dropped_messages":     s.hub.droppedMessages.Load(),
+		"slow_disconnects":     s.hub.slowDisconnects.Load(),
+		"clients":              s.hub.queueStats(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -272,6 +483,17 @@ func (c *Client) readPump() {
 		c.conn.Close()
 	}()
 
+	limits := c.hub.limits
+	if limits.maxMessageSize > 0 {
+		// Oversized frames fail the read and close the connection with 1009
+		c.conn.SetReadLimit(limits.maxMessageSize)
+	}
+	var limiter *tokenBucket
+	if limits.rateLimit > 0 {
+		limiter = newTokenBucket(limits.rateLimit, limits.rateBurst, time.Now())
+	}
+	warnings := 0
+
 	// Set read deadline and pong handler for keepalive
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
@@ -281,8 +503,7 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		var message Message
-		err := c.conn.ReadJSON(&message)
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("❌ WebSocket error for %s: %v", c.userID, err)
@@ -292,8 +513,40 @@ func (c *Client) readPump() {
 			break
 		}
 
+		if limiter != nil && !limiter.allow(time.Now()) {
+			if warnings >= limits.maxRateWarnings {
+				log.Printf("🚫 Rate limit exceeded again by %s - disconnecting", c.userID)
+				closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded")
+				c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+				return
+			}
+			warnings++
+			log.Printf("⚠️ Rate limit exceeded by %s (warning %d/%d)", c.userID, warnings, limits.maxRateWarnings)
+			c.sendSystem("warning", "Rate limit exceeded, slow down or you will be disconnected")
+			continue
+		}
+
+		var message Message
+		if err := c.codec.Unmarshal(data, &message); err != nil {
+			log.Printf("⚠️ Malformed %s message from %s: %v", c.codec.Subprotocol(), c.userID, err)
+			c.sendSystem("error", "Malformed message")
+			continue
+		}
+
 		log.Printf("📨 Message received from %s: type=%s, content=%s", c.userID, message.Type, message.Content)
 
+		if limits.maxContentLength > 0 && utf8.RuneCountInString(message.Content) > limits.maxContentLength {
+			log.Printf("⚠️ Message from %s rejected: content too long", c.userID)
+			c.sendSystem("error", fmt.Sprintf("Message content exceeds %d characters", limits.maxContentLength))
+			continue
+		}
+
+		if err := c.resolveAttachments(&message); err != nil {
+			log.Printf("⚠️ Message from %s rejected: %v", c.userID, err)
+			c.sendSystem("error", err.Error())
+			continue
+		}
+
 		// Add timestamp and user info
 		message.Timestamp = time.Now()
 		message.User = c.userID
@@ -308,20 +561,23 @@ func (c *Client) readPump() {
 		case "ping":
 			log.Printf("🏓 Ping received from %s, sending pong", c.userID)
 			// Send pong response
-			pong := Message{
-				Type:      "pong",
-				Content:   "pong",
-				User:      "system",
-				Timestamp: time.Now(),
+			c.sendSystem("pong", "pong")
+		default:
+			if c.hub.bridge != nil {
+				if err := c.hub.bridge.send(message); err != nil {
+					log.Printf("⚠️ Message from %s rejected by broker: %v", c.userID, err)
+					c.sendSystem("error", err.Error())
+					continue
+				}
+				c.shareAttachments(message)
+				continue
 			}
-			select {
-			case c.send <- pong:
-				log.Printf("✅ Pong sent to %s", c.userID)
-			default:
-				log.Printf("❌ Failed to send pong to %s - channel full", c.userID)
-				return
+			if message.Recipient != "" {
+				log.Printf("⚠️ Message from %s rejected: direct messages need a broker", c.userID)
+				c.sendSystem("error", "direct messages are not supported")
+				continue
 			}
-		default:
+			c.shareAttachments(message)
 			log.Printf("📤 Broadcasting message from %s to all clients", c.userID)
 			// Broadcast message to all clients
 			c.hub.broadcast <- message
@@ -329,6 +585,54 @@ func (c *Client) readPump() {
 	}
 }
 
+// resolveAttachments replaces client-supplied attachment metadata with the
+// server's, rejecting attachments the client may not share
+func (c *Client) resolveAttachments(message *Message) error {
+	if len(message.Attachments) == 0 {
+		return nil
+	}
+	if c.hub.resolveAttachment == nil {
+		return fmt.Errorf("attachments are not supported")
+	}
+	if len(message.Attachments) > maxAttachments {
+		return fmt.Errorf("a message may have at most %d attachments", maxAttachments)
+	}
+
+	for i, ref := range message.Attachments {
+		attachment, err := c.hub.resolveAttachment(c.userID, message.Recipient, ref.ID)
+		if err != nil {
+			return fmt.Errorf("attachment %s: %v", ref.ID, err)
+		}
+		message.Attachments[i] = attachment
+	}
+	return nil
+}
+
+// shareAttachments grants access to the attachments of an accepted
+// message. The message is on its way, so failures are reported to the
+// sender but do not stop it.
+func (c *Client) shareAttachments(message Message) {
+	if c.hub.shareAttachment == nil {
+		return
+	}
+	for _, attachment := range message.Attachments {
+		if err := c.hub.shareAttachment(c.userID, message.Recipient, attachment.ID); err != nil {
+			log.Printf("❌ Failed to share attachment %s from %s: %v", attachment.ID, c.userID, err)
+			c.sendSystem("error", fmt.Sprintf("attachment %s: %v", attachment.ID, err))
+		}
+	}
+}
+
+// sendSystem queues a system message for this client only
+func (c *Client) sendSystem(messageType, content string) {
+	c.hub.direct <- delivery{client: c, message: Message{
+		Type:      messageType,
+		Content:   content,
+		User:      "system",
+		Timestamp: time.Now(),
+	}}
+}
+
 // writePump writes messages to the WebSocket connection
 func (c *Client) writePump() {
 	log.Printf("✍️ WritePump started for client: %s", c.userID)
@@ -351,7 +655,12 @@ func (c *Client) writePump() {
 			}
 
 			log.Printf("📤 Sending message to %s: type=%s, content=%s", c.userID, message.Type, message.Content)
-			if err := c.conn.WriteJSON(message); err != nil {
+			data, err := c.codec.Marshal(message)
+			if err != nil {
+				log.Printf("❌ Failed to encode message for %s: %v", c.userID, err)
+				continue
+			}
+			if err := c.conn.WriteMessage(c.codec.FrameType(), data); err != nil {
 				log.Printf("❌ WebSocket write error for %s: %v", c.userID, err)
 				return
 			}
@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -60,7 +61,6 @@ func TestMessage_JSON(t *testing.T) {
 		Content:   "Hello World",
 		User:      "testuser",
 		Timestamp: time.Now(),
-		Delay:     100,
 	}
 
 	// Test JSON marshaling
@@ -86,10 +86,6 @@ func TestMessage_JSON(t *testing.T) {
 	if decoded.User != message.User {
 		t.Errorf("Expected user '%s', got '%s'", message.User, decoded.User)
 	}
-
-	if decoded.Delay != message.Delay {
-		t.Errorf("Expected delay %d, got %d", message.Delay, decoded.Delay)
-	}
 }
 
 func TestService_BroadcastMessage(t *testing.T) {
@@ -355,3 +351,89 @@ func TestWebSocket_PingPong(t *testing.T) {
 		t.Error("Did not receive pong response to ping")
 	}
 }
+
+func TestReadPump_Attachments(t *testing.T) {
+	config := DefaultConfig()
+	config.ResolveAttachment = func(userID, recipient, attachmentID string) (Attachment, error) {
+		if recipient != "" {
+			t.Errorf("Broadcast resolved attachment for recipient %q", recipient)
+		}
+		if userID != "limited" || attachmentID != "a1" {
+			return Attachment{}, errors.New("access denied")
+		}
+		return Attachment{ID: "a1", Name: "cat.png", MIMEType: "image/png", Size: 2048, HasThumbnail: true}, nil
+	}
+	conn := dialLimited(t, config)
+
+	// Client-supplied metadata is replaced with the server's
+	spoofed := Message{Type: "message", Attachments: []Attachment{{ID: "a1", MIMEType: "text/html", Size: 1}}}
+	if err := conn.WriteJSON(spoofed); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	msg := readUntil(t, conn, "message")
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("Expected 1 attachment, got %d", len(msg.Attachments))
+	}
+	if got := msg.Attachments[0]; got.MIMEType != "image/png" || got.Size != 2048 || got.Name != "cat.png" {
+		t.Errorf("Expected server-side attachment metadata, got %+v", got)
+	}
+
+	if err := conn.WriteJSON(Message{Type: "message", Attachments: []Attachment{{ID: "other"}}}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if errMsg := readUntil(t, conn, "error"); !strings.Contains(errMsg.Content, "access denied") {
+		t.Errorf("Unexpected error content: %s", errMsg.Content)
+	}
+}
+
+func TestReadPump_AttachmentsUnsupported(t *testing.T) {
+	conn := dialLimited(t, DefaultConfig())
+
+	if err := conn.WriteJSON(Message{Type: "message", Attachments: []Attachment{{ID: "a1"}}}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	readUntil(t, conn, "error")
+}
+
+func TestWebSocket_Authenticate(t *testing.T) {
+	config := DefaultConfig()
+	config.Authenticate = func(r *http.Request) (string, error) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			return "", nil
+		}
+		user, ok := strings.CutPrefix(token, "valid-")
+		if !ok {
+			return "", errors.New("invalid token")
+		}
+		return user, nil
+	}
+	service, err := NewServiceWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(service.handleWebSocket))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// The token decides the user, not user_id
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?token=valid-alice&user_id=mallory", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(Message{Type: "message", Content: "hi"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if msg := readUntil(t, conn, "message"); msg.User != "alice" {
+		t.Errorf("Expected message from alice, got %s", msg.User)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?token=forged", nil)
+	if err == nil {
+		t.Fatal("Expected the handshake with an invalid token to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %v", resp)
+	}
+}